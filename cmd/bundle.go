@@ -0,0 +1,122 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"latimer/core"
+	"latimer/helm"
+	"latimer/manifest"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var bundleOutput string
+
+// bundleCmd represents the bundle command
+var bundleCmd = &cobra.Command{
+	Use:   "bundle",
+	Short: "Manages air-gapped bundles of the charts of a manifest",
+	Long: `Manages air-gapped bundles of the charts of a manifest.  A bundle is a tarball holding the chart archives,
+their resolved values and an index listing the container images the charts reference along with the checksum
+of every file.  Install from a bundle with:
+
+latimer install -m manifest.yaml --bundle manifest-bundle.tgz --registry-mirror registry.site.local:5000`,
+}
+
+// bundleCreateCmd represents the bundle create command
+var bundleCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Pulls every chart of a manifest into a bundle",
+	Run: func(cmd *cobra.Command, args []string) {
+		latimerContext := core.GetLatimerContext()
+		filePath := latimerContext.ManifestPath
+		logrus.Infof("Bundle %v\n", filePath)
+		manifest, err := manifest.NewManifest(filePath, latimerContext.Values, latimerContext.Environment)
+		if err != nil {
+			logrus.Errorf("Error loading manifest file: %v", filePath)
+			exit(1)
+		}
+		if err := initRepositories(latimerContext, manifest.Descriptor, false); err != nil {
+			logrus.Errorf("Error setting up helm repositories: %v", err)
+			exit(1)
+		}
+		latimerContext.Lock, err = loadLockFile(manifest.GetID())
+		if err != nil {
+			logrus.Errorf("Error loading lock file: %v", err)
+			exit(1)
+		}
+		bundleDir, err := ioutil.TempDir(latimerContext.LatimerTempDir, "bundle-*")
+		if err != nil {
+			logrus.Errorf("Error creating bundle directory: %v", err)
+			exit(1)
+		}
+		defer os.RemoveAll(bundleDir)
+
+		sc := &core.SystemContext{
+			Name:        manifest.GetID(),
+			WorkTempDir: bundleDir,
+			Context:     latimerContext,
+		}
+		bundle, err := manifest.Bundle(sc, bundleDir)
+		if err != nil {
+			logrus.Errorf("Error bundling manifest %v: %v", filePath, err)
+			exit(1)
+		}
+		bundlePath := bundleOutput
+		if bundlePath == "" {
+			bundlePath = strings.TrimSuffix(filePath, filepath.Ext(filePath)) + "-bundle.tgz"
+		}
+		if err := helm.PackBundle(bundle, bundlePath); err != nil {
+			logrus.Errorf("Error writing bundle %v: %v", bundlePath, err)
+			exit(1)
+		}
+		for _, c := range bundle.Charts {
+			fmt.Printf("Bundled %v: %v %v [%v]\n", c.Name, c.ChartLocator, c.Version, c.Digest)
+		}
+		for _, image := range bundle.Images {
+			fmt.Printf("Image %v\n", image)
+		}
+		fmt.Printf("Bundle written to %v\n", bundlePath)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(bundleCmd)
+	bundleCmd.AddCommand(bundleCreateCmd)
+	bundleCreateCmd.Flags().StringVarP(&bundleOutput, "output", "o", "", "Path of the bundle tarball (default <manifest>-bundle.tgz)")
+}
+
+// openBundle extracts the bundle to the latimer temp directory and checks it was created for the manifest
+func openBundle(latimerContext *core.LatimerContext, bundlePath string, manifestID string) (*core.BundleIndex, error) {
+	bundleDir, err := ioutil.TempDir(latimerContext.LatimerTempDir, "bundle-*")
+	if err != nil {
+		return nil, err
+	}
+	bundle, err := helm.OpenBundle(bundlePath, bundleDir)
+	if err != nil {
+		return nil, err
+	}
+	if bundle.Manifest != manifestID {
+		return nil, fmt.Errorf("Bundle %v was created for manifest %v, not %v", bundlePath, bundle.Manifest, manifestID)
+	}
+	return bundle, nil
+}
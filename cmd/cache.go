@@ -0,0 +1,97 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"latimer/helm"
+	"os"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var pruneOlderThan time.Duration
+var pruneAll bool
+
+// cacheCmd represents the cache command
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Manages the chart cache shared between runs",
+	Long: `Manages the chart cache shared between runs (see --cache-dir).  Chart archives are stored by digest
+and indexed by chart locator and version, so repeat installs of locked or exactly pinned charts do not
+download them again.`,
+}
+
+// cacheListCmd represents the cache list command
+var cacheListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "Lists the charts in the cache",
+	Run: func(cmd *cobra.Command, args []string) {
+		cache := openChartCache()
+		var total int64
+		for _, entry := range cache.Entries {
+			total += entry.Size
+			fmt.Printf("%-40v %-12v %v %10d  last used %v\n", entry.ChartRef, entry.Version, entry.Digest, entry.Size, entry.LastUsed.Format(time.RFC3339))
+		}
+		fmt.Printf("%v charts, %v bytes in %v\n", len(cache.Entries), total, cache.Dir)
+	},
+}
+
+// cachePruneCmd represents the cache prune command
+var cachePruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Removes the charts of the cache not used recently",
+	Run: func(cmd *cobra.Command, args []string) {
+		cache := openChartCache()
+		unusedSince := time.Now().Add(-pruneOlderThan)
+		if pruneAll {
+			unusedSince = time.Now().Add(time.Hour)
+		}
+		removed, err := cache.Prune(unusedSince)
+		if err != nil {
+			logrus.Errorf("Error pruning chart cache %v: %v", cache.Dir, err)
+			os.Exit(1)
+		}
+		for _, entry := range removed {
+			fmt.Printf("Removed %v %v [%v]\n", entry.ChartRef, entry.Version, entry.Digest)
+		}
+		fmt.Printf("%v charts removed, %v charts kept\n", len(removed), len(cache.Entries))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(cacheCmd)
+	cacheCmd.AddCommand(cacheListCmd)
+	cacheCmd.AddCommand(cachePruneCmd)
+	cachePruneCmd.Flags().DurationVar(&pruneOlderThan, "older-than", 30*24*time.Hour, "Remove the charts not used for this long")
+	cachePruneCmd.Flags().BoolVar(&pruneAll, "all", false, "Remove all the charts")
+}
+
+// openChartCache opens the chart cache given on the command line, exiting on error
+func openChartCache() *helm.ChartCache {
+	if chartCacheDir == "" {
+		fmt.Println("Chart cache disabled (empty --cache-dir)")
+		os.Exit(1)
+	}
+	cache, err := helm.NewChartCache(chartCacheDir)
+	if err != nil {
+		logrus.Errorf("Error opening chart cache %v: %v", chartCacheDir, err)
+		os.Exit(1)
+	}
+	return cache
+}
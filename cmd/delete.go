@@ -16,16 +16,29 @@ limitations under the License.
 package cmd
 
 import (
+	"bufio"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"latimer/core"
 	"latimer/manifest"
 	"log"
 	"os"
+	"sort"
+	"strings"
 
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
 
+var deleteOnly []string
+var deleteExclude []string
+var deleteNoDeps bool
+var deleteCascade bool
+var deletePurgeData bool
+var deleteYes bool
+var deleteForceUnlock bool
+
 // deleteCmd represents the delete command
 var deleteCmd = &cobra.Command{
 	Use:   "delete",
@@ -48,14 +61,25 @@ charts:
 		latimerContext := core.GetLatimerContext()
 		filePath := latimerContext.ManifestPath
 		logrus.Infof("Delete %v\n", filePath)
-		manifest, err := manifest.NewManifest(filePath, latimerContext.Values)
+		selection := manifest.Selection{
+			Only:    deleteOnly,
+			Exclude: deleteExclude,
+			NoDeps:  deleteNoDeps,
+			Cascade: deleteCascade,
+		}
+		manifest, err := manifest.NewManifest(filePath, latimerContext.Values, latimerContext.Environment)
 		if err != nil {
 			logrus.Errorf("Error loading manifest file: %v", filePath)
-			os.Exit(1)
+			exit(1)
+		}
+		if err := manifest.Select(selection, true); err != nil {
+			logrus.Errorf("Invalid selection of manifest items: %v", err)
+			exit(1)
 		}
 		//log.Printf("\n%v\n", manifest.StringYaml())
 
 		descriptor := manifest.Descriptor
+		latimerContext.Targets = descriptor.Targets
 		// Each installable should work in it's own private temp directory
 		installableTempDir, err := ioutil.TempDir(latimerContext.LatimerTempDir, descriptor.Metadata.Name+"-*")
 		if err != nil {
@@ -63,17 +87,71 @@ charts:
 		}
 		defer os.RemoveAll(installableTempDir) // clean up
 
+		if deletePurgeData && !deleteYes {
+			fmt.Printf("--purge-data deletes the persistent volume claims of all the charts of manifest %v, along with\n", manifest.GetID())
+			fmt.Printf("the data of the volumes with a Delete reclaim policy.  Type the manifest name to confirm: ")
+			if !confirm(os.Stdin, manifest.GetID()) {
+				logrus.Errorf("Delete aborted")
+				exit(1)
+			}
+		}
+		latimerContext.PurgeData = deletePurgeData
+
 		sc := &core.SystemContext{
 			Name:        descriptor.Metadata.Name,
 			WorkTempDir: installableTempDir,
 			Context:     latimerContext,
 		}
-		manifest.Uninstall(sc)
+		if err := acquireLease(manifest, sc, deleteForceUnlock); err != nil {
+			logrus.Errorf("Cannot lock manifest %v: %v", manifest.GetID(), err)
+			exit(1)
+		}
+		status := manifest.Uninstall(sc)
+		manifest.ReleaseLease()
+		printRetainedVolumes(latimerContext)
+		if !status {
+			exit(1)
+		}
 	},
 }
 
+// confirm reads a line from the input and returns whether it matches the expected answer
+func confirm(in io.Reader, expected string) bool {
+	answer, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return false
+	}
+	return strings.TrimSpace(answer) == expected
+}
+
+// printRetainedVolumes lists the persistent volume claims kept on delete, by chart
+func printRetainedVolumes(latimerContext *core.LatimerContext) {
+	if len(latimerContext.RetainedVolumes) == 0 {
+		return
+	}
+	charts := make([]string, 0, len(latimerContext.RetainedVolumes))
+	for chartName := range latimerContext.RetainedVolumes {
+		charts = append(charts, chartName)
+	}
+	sort.Strings(charts)
+	fmt.Printf("Retained volumes (delete them with onDelete pvcs: delete or --purge-data):\n")
+	fmt.Printf("  %-20v %-16v %-32v %-42v %-9v %v\n", "CHART", "NAMESPACE", "CLAIM", "VOLUME", "CAPACITY", "STORAGECLASS")
+	for _, chartName := range charts {
+		for _, claim := range latimerContext.RetainedVolumes[chartName] {
+			fmt.Printf("  %-20v %-16v %-32v %-42v %-9v %v\n", chartName, claim.Namespace, claim.Name, claim.Volume, claim.Capacity, claim.StorageClass)
+		}
+	}
+}
+
 func init() {
 	rootCmd.AddCommand(deleteCmd)
+	deleteCmd.Flags().StringSliceVar(&deleteOnly, "only", []string{}, "Charts or packages to delete, refused if other items depend on them unless --cascade (default is all)")
+	deleteCmd.Flags().StringSliceVar(&deleteExclude, "exclude", []string{}, "Charts or packages to leave out")
+	deleteCmd.Flags().BoolVar(&deleteCascade, "cascade", false, "Delete the transitive dependents of the --only items first, in reverse install order")
+	deleteCmd.Flags().BoolVar(&deleteNoDeps, "no-deps", false, "Delete the --only items even though other items depend on them")
+	deleteCmd.Flags().BoolVar(&deletePurgeData, "purge-data", false, "Delete the persistent volume claims of all the charts, regardless of their onDelete policy")
+	deleteCmd.Flags().BoolVar(&deleteForceUnlock, "force-unlock", false, "Take over the lock of the manifest held by another run (eg a run that was killed)")
+	deleteCmd.Flags().BoolVar(&deleteYes, "yes", false, "Do not ask for confirmation of --purge-data")
 
 	// Here you will define your flags and configuration settings.
 
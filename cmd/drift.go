@@ -0,0 +1,129 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"latimer/core"
+	"latimer/manifest"
+	"os"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+const (
+	// driftExitCode is the exit code of the drift command when drift is detected (1 is for errors)
+	driftExitCode = 2
+)
+
+var driftOnly []string
+var driftExclude []string
+var driftOutput string
+var driftReport string
+
+// driftCmd represents the drift command
+var driftCmd = &cobra.Command{
+	Use:   "drift",
+	Short: "Reports the drift of the releases of a manifest from their live objects",
+	Long: `Compares the live objects of the release of each chart of a manifest with the objects of the release
+(replica counts, container images and environments, config map data) and reports:
+
+  modified  objects edited in the cluster (eg kubectl scale, kubectl set image)
+  deleted   objects of the release missing from the cluster
+  extra     objects labelled as part of the release but not in the release
+
+Charts without release are reported as missing.  The exit code is 0 when all the releases are in sync,
+2 when drift is detected and 1 on errors.  Use --output json for a machine-readable report.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		latimerContext := core.GetLatimerContext()
+		filePath := latimerContext.ManifestPath
+		logrus.Infof("Drift %v\n", filePath)
+		if driftOutput != "text" && driftOutput != "json" {
+			logrus.Errorf("Invalid output format %v, expecting text or json", driftOutput)
+			exit(1)
+		}
+		selection := manifest.Selection{Only: driftOnly, Exclude: driftExclude, NoDeps: true}
+		manifest, err := manifest.NewManifest(filePath, latimerContext.Values, latimerContext.Environment)
+		if err != nil {
+			logrus.Errorf("Error loading manifest file: %v [%v]", filePath, err)
+			exit(1)
+		}
+		if err := manifest.Select(selection, false); err != nil {
+			logrus.Errorf("Invalid selection of manifest items: %v", err)
+			exit(1)
+		}
+		latimerContext.Targets = manifest.Descriptor.Targets
+		sc := &core.SystemContext{
+			Name:    manifest.Descriptor.Metadata.Name,
+			Context: latimerContext,
+		}
+		report, err := manifest.Drift(sc)
+		if err != nil {
+			logrus.Errorf("Error comparing the releases with the cluster: %v", err)
+			exit(1)
+		}
+		reportBytes, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			logrus.Errorf("Error generating the drift report: %v", err)
+			exit(1)
+		}
+		if driftOutput == "json" {
+			fmt.Println(string(reportBytes))
+		} else {
+			printDrift(report)
+		}
+		if driftReport != "" {
+			if err := ioutil.WriteFile(driftReport, reportBytes, 0644); err != nil {
+				logrus.Errorf("Error saving drift report: %v", err)
+				exit(1)
+			}
+		}
+		if report.Drifted {
+			os.Exit(driftExitCode)
+		}
+	},
+}
+
+// printDrift writes the drift of each release to the standard output
+func printDrift(report *manifest.DriftReport) {
+	fmt.Printf("Manifest: %v\n", report.Manifest)
+	for _, release := range report.Releases {
+		fmt.Printf("Release %v (chart %v, namespace %v): %v\n", release.ReleaseName, release.Chart, release.Namespace, release.Status)
+		for _, object := range release.Objects {
+			if len(object.Fields) == 0 {
+				fmt.Printf("  %-9v %v\n", object.Drift, object.Ref)
+				continue
+			}
+			for _, field := range object.Fields {
+				fmt.Printf("  %-9v %v %v\n", object.Drift, object.Ref, field)
+			}
+		}
+	}
+	if !report.Drifted {
+		fmt.Printf("All releases are in sync\n")
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(driftCmd)
+	driftCmd.Flags().StringSliceVar(&driftOnly, "only", []string{}, "Charts or packages to check (default is all)")
+	driftCmd.Flags().StringSliceVar(&driftExclude, "exclude", []string{}, "Charts or packages to leave out")
+	driftCmd.Flags().StringVarP(&driftOutput, "output", "o", "text", "Output format: text or json")
+	driftCmd.Flags().StringVar(&driftReport, "report", "", "File the json drift report is written to")
+}
@@ -16,8 +16,12 @@ limitations under the License.
 package cmd
 
 import (
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"latimer/core"
+	"latimer/fleet"
+	"latimer/kube"
 	"latimer/manifest"
 	"log"
 	"os"
@@ -26,6 +30,16 @@ import (
 	"github.com/spf13/cobra"
 )
 
+var installOnly []string
+var installExclude []string
+var installNoDeps bool
+var installBundle string
+var installImageMirror string
+var installTargets string
+var installReport string
+var installPrune bool
+var installForceUnlock bool
+
 // installCmd represents the install command
 var installCmd = &cobra.Command{
 	Use:   "install",
@@ -48,14 +62,43 @@ charts:
 		latimerContext := core.GetLatimerContext()
 		filePath := latimerContext.ManifestPath
 		logrus.Infof("Install %v\n", filePath)
-		manifest, err := manifest.NewManifest(filePath, latimerContext.Values)
+		selection := manifest.Selection{
+			Only:    installOnly,
+			Exclude: installExclude,
+			NoDeps:  installNoDeps,
+		}
+		manifest, err := manifest.NewManifest(filePath, latimerContext.Values, latimerContext.Environment)
 		if err != nil {
 			logrus.Errorf("Error loading manifest file: %v", filePath)
-			os.Exit(1)
+			exit(1)
+		}
+		if err := manifest.Select(selection, false); err != nil {
+			logrus.Errorf("Invalid selection of manifest items: %v", err)
+			exit(1)
+		}
+		latimerContext.ImageMirror = installImageMirror
+		if installBundle != "" {
+			// Charts and values come from the bundle, no repository is accessed
+			latimerContext.Bundle, err = openBundle(latimerContext, installBundle, manifest.GetID())
+			if err != nil {
+				logrus.Errorf("Error opening bundle: %v", err)
+				exit(1)
+			}
+		} else {
+			if err := initRepositories(latimerContext, manifest.Descriptor, false); err != nil {
+				logrus.Errorf("Error setting up helm repositories: %v", err)
+				exit(1)
+			}
+			latimerContext.Lock, err = loadLockFile(manifest.GetID())
+			if err != nil {
+				logrus.Errorf("Error loading lock file: %v", err)
+				exit(1)
+			}
 		}
 		logrus.Infof("\n%v\n", manifest.StringYaml())
 
 		descriptor := manifest.Descriptor
+		latimerContext.Targets = descriptor.Targets
 		// Each installable should work in it's own private temp directory
 		installableTempDir, err := ioutil.TempDir(latimerContext.LatimerTempDir, descriptor.Metadata.Name+"-*")
 		if err != nil {
@@ -68,12 +111,119 @@ charts:
 			WorkTempDir: installableTempDir,
 			Context:     latimerContext,
 		}
-		manifest.Install(sc)
+		if installTargets == "" {
+			if err := acquireLease(manifest, sc, installForceUnlock); err != nil {
+				logrus.Errorf("Cannot lock manifest %v: %v", manifest.GetID(), err)
+				exit(1)
+			}
+			status := true
+			if err := pruneReleases(manifest, sc, installPrune); err != nil {
+				logrus.Errorf("%v", err)
+				status = false
+			} else {
+				status = manifest.Install(sc)
+			}
+			manifest.ReleaseLease()
+			if !status {
+				exit(1)
+			}
+			return
+		}
+		fleetDescriptor, err := core.LoadFleetDescriptor(installTargets)
+		if err != nil {
+			logrus.Errorf("Error loading fleet file: %v", err)
+			exit(1)
+		}
+		report := fleet.Rollout(fleetDescriptor, sc, func(clusterSC *core.SystemContext) error {
+			return installCluster(filePath, selection, clusterSC)
+		})
+		report.Print()
+		if installReport != "" {
+			if err := report.Save(installReport); err != nil {
+				logrus.Errorf("Error saving rollout report: %v", err)
+				exit(1)
+			}
+		}
+		if report.Halted || report.Count(fleet.Failed) > 0 {
+			exit(1)
+		}
 	},
 }
 
+// installCluster installs the manifest to the cluster of the system context and waits for its items to be ready.
+// Each cluster has its own instance of the manifest.
+func installCluster(filePath string, selection manifest.Selection, sc *core.SystemContext) error {
+	m, err := manifest.NewManifest(filePath, sc.Context.Values, sc.Context.Environment)
+	if err != nil {
+		return err
+	}
+	if err := m.Select(selection, false); err != nil {
+		return err
+	}
+	if err := acquireLease(m, sc, installForceUnlock); err != nil {
+		return err
+	}
+	defer m.ReleaseLease()
+	if err := pruneReleases(m, sc, installPrune); err != nil {
+		return err
+	}
+	if !m.Install(sc) {
+		return fmt.Errorf("Install of manifest %v failed", m.GetID())
+	}
+	return m.Wait(sc)
+}
+
+// pruneReleases uninstalls the releases latimer installed for the manifest whose chart is no longer in the
+// manifest.  Without prune, these releases are only reported.
+func pruneReleases(m *manifest.Manifest, sc *core.SystemContext, prune bool) error {
+	if prune {
+		if !m.Prune(sc) {
+			return fmt.Errorf("Pruning the releases of manifest %v failed", m.GetID())
+		}
+		return nil
+	}
+	stale, err := m.StaleReleases(sc)
+	if err != nil {
+		logrus.Warningf("Cannot read the releases of manifest %v [%v]", m.GetID(), err)
+		return nil
+	}
+	for _, r := range stale {
+		logrus.Warningf("Release %v is no longer in manifest %v, remove it with install --prune", r, m.GetID())
+	}
+	return nil
+}
+
+// acquireLease locks the manifest in its clusters for the duration of the run, so that concurrent runs do not
+// interleave their helm operations
+func acquireLease(m *manifest.Manifest, sc *core.SystemContext, force bool) error {
+	err := m.AcquireLease(sc, leaseHolder(), force)
+	var held *kube.LeaseHeldError
+	if errors.As(err, &held) {
+		return fmt.Errorf("%v, wait for that run to end or use --force-unlock if it is gone", err)
+	}
+	return err
+}
+
+// leaseHolder returns the identity of this run in the leases it holds: the host name and the process id
+func leaseHolder() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%v-%v", host, os.Getpid())
+}
+
 func init() {
 	rootCmd.AddCommand(installCmd)
+	installCmd.Flags().StringSliceVar(&installOnly, "only", []string{}, "Charts or packages to install along with their transitive prerequisites (default is all)")
+	installCmd.Flags().StringSliceVar(&installExclude, "exclude", []string{}, "Charts or packages to leave out")
+	installCmd.Flags().BoolVar(&installNoDeps, "no-deps", false, "Do not pull in the transitive prerequisites of the --only items")
+	installCmd.Flags().StringVar(&installBundle, "bundle", "", "Install the charts from an air-gapped bundle (see bundle create) without accessing any repository")
+	installCmd.Flags().StringVar(&installImageMirror, "registry-mirror", "", "Registry the container images are rewritten to (eg registry.site.local:5000)")
+	installCmd.Flags().BoolVar(&installPrune, "prune", false, "Uninstall the releases latimer installed for the manifest whose chart is no longer in the manifest")
+	installCmd.Flags().BoolVar(&installForceUnlock, "force-unlock", false, "Take over the lock of the manifest held by another run (eg a run that was killed)")
+	installCmd.Flags().StringVar(&installTargets, "targets", "", "Fleet file listing the clusters to roll the manifest out to, in waves")
+	installCmd.Flags().StringVar(&installReport, "report", "", "File the json report of the fleet rollout is written to (with --targets)")
 
 	// Here you will define your flags and configuration settings.
 
@@ -0,0 +1,99 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"latimer/core"
+	"latimer/manifest"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// lockCmd represents the lock command
+var lockCmd = &cobra.Command{
+	Use:   "lock",
+	Short: "Resolves the exact version and digest of every chart of a manifest into a lock file",
+	Long: `Resolves the exact version and digest of every chart of a manifest into a lock file.
+Each chart is pulled using its version constraint, eg:
+
+charts:
+  - name: "redis"
+    chartName: "bitnami/redis"
+    chartLocator: "bitnami/redis"
+    version: "~10.7"
+
+Subsequent installs use the locked versions and fail if a pulled chart does not match its locked digest.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		latimerContext := core.GetLatimerContext()
+		filePath := latimerContext.ManifestPath
+		logrus.Infof("Lock %v\n", filePath)
+		manifest, err := manifest.NewManifest(filePath, latimerContext.Values, latimerContext.Environment)
+		if err != nil {
+			logrus.Errorf("Error loading manifest file: %v", filePath)
+			exit(1)
+		}
+		if err := initRepositories(latimerContext, manifest.Descriptor, false); err != nil {
+			logrus.Errorf("Error setting up helm repositories: %v", err)
+			exit(1)
+		}
+		sc := &core.SystemContext{
+			Name:        manifest.GetID(),
+			WorkTempDir: latimerContext.LatimerTempDir,
+			Context:     latimerContext,
+		}
+		lf, err := manifest.Lock(sc)
+		if err != nil {
+			logrus.Errorf("Error locking manifest %v: %v", filePath, err)
+			exit(1)
+		}
+		lockPath := lockFilePathFor(latimerContext)
+		if err := lf.Save(lockPath); err != nil {
+			logrus.Errorf("Error writing lock file %v: %v", lockPath, err)
+			exit(1)
+		}
+		for _, c := range lf.Charts {
+			fmt.Printf("Locked %v: %v %v [%v]\n", c.Name, c.ChartLocator, c.Version, c.Digest)
+		}
+		fmt.Printf("Lock file written to %v\n", lockPath)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(lockCmd)
+}
+
+// lockFilePathFor returns the lock file path given on the command line, or the default one for the manifest
+func lockFilePathFor(latimerContext *core.LatimerContext) string {
+	if lockFilePath != "" {
+		return lockFilePath
+	}
+	return core.DefaultLockFilePath(latimerContext.ManifestPath)
+}
+
+// loadLockFile loads the lock file of the manifest, if any, checking it was generated for the manifest
+func loadLockFile(manifestID string) (*core.LockFile, error) {
+	lockPath := lockFilePathFor(core.GetLatimerContext())
+	lf, err := core.LoadLockFile(lockPath)
+	if err != nil || lf == nil {
+		return lf, err
+	}
+	if lf.Manifest != manifestID {
+		return nil, fmt.Errorf("Lock file %v was generated for manifest %v, not %v", lockPath, lf.Manifest, manifestID)
+	}
+	return lf, nil
+}
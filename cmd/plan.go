@@ -0,0 +1,67 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"latimer/core"
+	"latimer/manifest"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// planCmd represents the plan command
+var planCmd = &cobra.Command{
+	Use:   "plan",
+	Short: "Shows the installation order of the charts and packages defined in a manifest file input",
+	Long: `Shows the installation order of the charts and packages defined in a manifest file input,
+along with the items skipped because they are disabled or their condition is false.
+Uninstall follows the reverse order.  Hooks with a phase run before (pre-install, pre-delete)
+or after (post-install) all the items.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		latimerContext := core.GetLatimerContext()
+		filePath := latimerContext.ManifestPath
+		logrus.Infof("Plan %v\n", filePath)
+		manifest, err := manifest.NewManifest(filePath, latimerContext.Values, latimerContext.Environment)
+		if err != nil {
+			logrus.Errorf("Error loading manifest file: %v [%v]", filePath, err)
+			exit(1)
+		}
+		installList, skipped := manifest.Plan()
+		fmt.Printf("Manifest: %v\n", manifest.GetID())
+		fmt.Printf("Install order:\n")
+		for idx, item := range installList {
+			fmt.Printf("  %3d. %-8v %v\n", idx+1, item.Kind, item.Name)
+		}
+		for _, phase := range []string{core.PreInstallPhase, core.PostInstallPhase, core.PreDeletePhase} {
+			if hooks := manifest.HookNames(phase); len(hooks) > 0 {
+				fmt.Printf("%v hooks: %v\n", strings.Title(phase), hooks)
+			}
+		}
+		if len(skipped) > 0 {
+			fmt.Printf("Skipped:\n")
+			for _, item := range skipped {
+				fmt.Printf("       %-8v %v: %v\n", item.Kind, item.Name, item.Reason)
+			}
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(planCmd)
+}
@@ -0,0 +1,144 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"latimer/core"
+	"latimer/helm"
+	"latimer/kube"
+	"latimer/manifest"
+	"os"
+	"path/filepath"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// repoCmd represents the repo command
+var repoCmd = &cobra.Command{
+	Use:   "repo",
+	Short: "Manages the helm repositories declared in a manifest file input",
+	Long: `Manages the helm repositories declared in a manifest file input.  Repositories are kept in a
+latimer-managed repositories file of the manifest (see --repository-config), isolated from the user's helm
+configuration.  The charts of a manifest can only be pulled from the repositories it declares.
+A sample repositories section looks like:
+
+repositories:
+  - name: "bitnami"
+    url: "https://charts.bitnami.com/bitnami"
+  - name: "internal"
+    url: "https://charts.example.com"
+    usernameEnv: "CHARTS_USER"
+    passwordEnv: "CHARTS_PASSWORD"
+    caFile: "certs/ca.pem"
+  - name: "registry"
+    url: "oci://registry.example.com"
+    usernameEnv: "REGISTRY_USER"
+    passwordEnv: "REGISTRY_PASSWORD"`,
+}
+
+// repoUpdateCmd represents the repo update command
+var repoUpdateCmd = &cobra.Command{
+	Use:   "update",
+	Short: "Adds the manifest repositories to the latimer repositories file and refreshes their indexes",
+	Run: func(cmd *cobra.Command, args []string) {
+		latimerContext := core.GetLatimerContext()
+		filePath := latimerContext.ManifestPath
+		manifest, err := manifest.NewManifest(filePath, latimerContext.Values, latimerContext.Environment)
+		if err != nil {
+			logrus.Errorf("Error loading manifest file: %v", filePath)
+			os.Exit(1)
+		}
+		if len(manifest.Descriptor.Repositories) == 0 {
+			fmt.Printf("No repositories declared in manifest %v\n", filePath)
+			return
+		}
+		if err := initRepositories(latimerContext, manifest.Descriptor, true); err != nil {
+			logrus.Errorf("Error updating helm repositories: %v", err)
+			os.Exit(1)
+		}
+		for _, r := range manifest.Descriptor.Repositories {
+			fmt.Printf("Updated repository %v [%v]\n", r.Name, r.URL)
+		}
+	},
+}
+
+// repoListCmd represents the repo list command
+var repoListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "Lists the repositories of the latimer repositories file of the manifest",
+	Run: func(cmd *cobra.Command, args []string) {
+		latimerContext := core.GetLatimerContext()
+		filePath := latimerContext.ManifestPath
+		manifest, err := manifest.NewManifest(filePath, latimerContext.Values, latimerContext.Environment)
+		if err != nil {
+			logrus.Errorf("Error loading manifest file: %v", filePath)
+			os.Exit(1)
+		}
+		helmClient := helm.NewHelmClient()
+		helmClient.RepositoryConfig, helmClient.RepositoryCache = repositoryFiles(manifest.Descriptor)
+		entries, err := helmClient.ListRepositories()
+		if err != nil {
+			logrus.Errorf("Error reading repositories file %v: %v", helmClient.RepositoryConfig, err)
+			os.Exit(1)
+		}
+		for _, entry := range entries {
+			fmt.Printf("%-20v %v\n", entry.Name, entry.URL)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(repoCmd)
+	repoCmd.AddCommand(repoUpdateCmd)
+	repoCmd.AddCommand(repoListCmd)
+}
+
+// initRepositories switches the context to the latimer-managed repositories of the manifest, and adds the
+// declared repositories to them.  Each manifest has its own repositories file and index cache (unless
+// --repository-config is given), so that the same alias may name different repositories in different manifests.
+// The user's helm repositories are never used: charts from repositories the manifest does not declare are refused.
+func initRepositories(latimerContext *core.LatimerContext, descriptor *core.ManifestDescriptor, refresh bool) error {
+	latimerContext.Registries = make([]core.RepositoryDescriptor, 0)
+	latimerContext.Repositories = make([]core.RepositoryDescriptor, 0)
+	for _, r := range descriptor.Repositories {
+		if r.IsRegistry() {
+			latimerContext.Registries = append(latimerContext.Registries, r)
+		} else {
+			latimerContext.Repositories = append(latimerContext.Repositories, r)
+		}
+	}
+	if undeclared := descriptor.UndeclaredRepositories(); len(undeclared) > 0 {
+		return fmt.Errorf("Charts of manifest %v are pulled from repositories it does not declare %v, add them to its repositories",
+			descriptor.Metadata.Name, undeclared)
+	}
+	latimerContext.RepositoryConfig, latimerContext.RepositoryCache = repositoryFiles(descriptor)
+	helmClient := helm.NewHelmClient()
+	helmClient.RepositoryConfig = latimerContext.RepositoryConfig
+	helmClient.RepositoryCache = latimerContext.RepositoryCache
+	return helmClient.UpdateRepositories(descriptor.Repositories, refresh)
+}
+
+// repositoryFiles returns the latimer-managed repositories file and repository index cache of the manifest
+func repositoryFiles(descriptor *core.ManifestDescriptor) (string, string) {
+	name := kube.OwnerLabelValue(descriptor.Metadata.Name)
+	cache := filepath.Join(repositoryCache, name)
+	if repositoryConfig != "" {
+		return repositoryConfig, cache
+	}
+	return filepath.Join(latimerHome, "repositories", name+".yaml"), cache
+}
@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"io"
 	"latimer/core"
+	"latimer/helm"
 	"os"
 	"os/user"
 	"path/filepath"
@@ -33,8 +34,25 @@ import (
 
 var cfgFile string
 var kubeConfigPath string
+var kubeContext string
+var impersonate string
+var impersonateGroups []string
+var kubeQPS float32
+var kubeBurst int
 var manifestPath string
+var environment string
+var lockFilePath string
+var repositoryConfig string
+var repositoryCache string
+
+// latimerHome is the directory of the latimer files of the user
+var latimerHome string
+var chartCacheDir string
+var verifyCharts bool
+var keyring string
+var stateNamespace string
 var valuesLatimer []string = []string{}
+var chartValuesLatimer []string = []string{}
 
 //The verbose flag value
 var verbosity string
@@ -59,12 +77,20 @@ to quickly create a Cobra application.`,
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Println(err)
-		os.Exit(1)
+		exit(1)
 	}
+	helm.FlushChartCaches()
 	latimerContext := core.GetLatimerContext()
 	defer os.RemoveAll(latimerContext.LatimerTempDir) // clean up
 }
 
+// exit terminates the command with the exit code, after recording the usage of the cached charts (deferred
+// functions are not run)
+func exit(code int) {
+	helm.FlushChartCaches()
+	os.Exit(code)
+}
+
 func init() {
 	cobra.OnInitialize(initConfig, initLatimer)
 
@@ -86,10 +112,25 @@ func init() {
 
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.latimer.yaml)")
 	rootCmd.PersistentFlags().StringVar(&kubeConfigPath, "kubeconfig", defaultKubeConfigPath, "kubeconfig file (default is $HOME/.kube/config)")
+	rootCmd.PersistentFlags().StringVar(&kubeContext, "kube-context", "", "kubeconfig context to use for kubernetes and helm (default is the current context)")
+	rootCmd.PersistentFlags().StringVar(&impersonate, "as", "", "User to impersonate in kubernetes and helm requests")
+	rootCmd.PersistentFlags().StringArrayVar(&impersonateGroups, "as-group", []string{}, "Group to impersonate in kubernetes and helm requests (can specify multiple)")
+	rootCmd.PersistentFlags().Float32Var(&kubeQPS, "kube-qps", 0, "Maximum queries per second to the kubernetes API server (default is the client-go default)")
+	rootCmd.PersistentFlags().IntVar(&kubeBurst, "kube-burst", 0, "Maximum burst of queries to the kubernetes API server (default is the client-go default)")
 	rootCmd.PersistentFlags().StringVar(&manifestPath, "manifest", "default", "Path of the input manifest")
+	latimerHome = filepath.Join(user.HomeDir, ".latimer")
+	rootCmd.PersistentFlags().StringVar(&repositoryConfig, "repository-config", "", "latimer-managed helm repositories file (default is ~/.latimer/repositories/<manifest>.yaml)")
+	rootCmd.PersistentFlags().StringVar(&repositoryCache, "repository-cache", filepath.Join(user.HomeDir, ".latimer", "cache", "repository"), "latimer-managed helm repository index cache, with a directory per manifest")
+	rootCmd.PersistentFlags().StringVar(&chartCacheDir, "cache-dir", filepath.Join(user.HomeDir, ".latimer", "cache", "charts"), "Directory of the chart cache shared between runs (empty to disable)")
+	rootCmd.PersistentFlags().StringVar(&lockFilePath, "lock-file", "", "Path of the lock file pinning chart versions (default is latimer.lock next to the manifest)")
+	rootCmd.PersistentFlags().BoolVar(&verifyCharts, "verify", false, "Verify the provenance of every chart against the keyring (see also the chart verify setting)")
+	rootCmd.PersistentFlags().StringVar(&keyring, "keyring", "", "Public keyring used to verify chart provenance (default is ~/.gnupg/pubring.gpg)")
+	rootCmd.PersistentFlags().StringVar(&stateNamespace, "state-namespace", core.DefaultStateNamespace, "Namespace of the records latimer keeps in the cluster (eg the releases managed for a manifest)")
+	rootCmd.PersistentFlags().StringVar(&environment, "env", "", "Name of the manifest environment profile to apply (eg dev, stage, prod)")
 	//Default value is the warn level
 	rootCmd.PersistentFlags().StringVarP(&verbosity, "verbosity", "v", logrus.WarnLevel.String(), "Log level (debug, info, warn, error, fatal, panic")
 	rootCmd.PersistentFlags().StringArrayVar(&valuesLatimer, "set", []string{}, "set values on the command line (can specify multiple or separate values with commas: key1=val1,key2=val2)")
+	rootCmd.PersistentFlags().StringArrayVar(&chartValuesLatimer, "chart-set", []string{}, "override chart values on the command line (can specify multiple: chart:key1=val1,key2=val2), also read from $"+core.ChartSetEnvVar)
 
 	// Cobra also supports local flags, which will only run
 	// when this action is called directly.
@@ -125,7 +166,21 @@ func initConfig() {
 // Load the kube config settings
 func initLatimer() {
 	latimerContext := core.GetLatimerContext()
+	latimerContext.KubeContext = kubeContext
+	latimerContext.Impersonate = impersonate
+	latimerContext.ImpersonateGroups = impersonateGroups
+	latimerContext.KubeQPS = kubeQPS
+	latimerContext.KubeBurst = kubeBurst
 	latimerContext.InitLatimer(kubeConfigPath, manifestPath, valuesLatimer)
+	latimerContext.Environment = environment
+	latimerContext.ChartCacheDir = chartCacheDir
+	latimerContext.Verify = verifyCharts
+	latimerContext.Keyring = keyring
+	latimerContext.StateNamespace = stateNamespace
+	if err := latimerContext.InitChartValues(chartValuesLatimer); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
 }
 
 //setUpLogs set the log output ans the log level
@@ -16,36 +16,97 @@ limitations under the License.
 package cmd
 
 import (
-	"fmt"
+	"io/ioutil"
+	"latimer/core"
+	"latimer/manifest"
+	"log"
+	"os"
 
+	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
 
+var updateOnly []string
+var updateExclude []string
+var updateNoDeps bool
+var updateImageMirror string
+var updatePrune bool
+var updateForceUnlock bool
+
 // updateCmd represents the update command
 var updateCmd = &cobra.Command{
 	Use:   "update",
-	Short: "A brief description of your command",
-	Long: `A longer description that spans multiple lines and likely contains examples
-and usage of using your command. For example:
-
-Cobra is a CLI library for Go that empowers applications.
-This application is a tool to generate the needed files
-to quickly create a Cobra application.`,
+	Short: "Upgrades the releases of a manifest to the chart versions and values of the manifest file input",
+	Long: `Upgrades the releases of a manifest to the chart versions and values of the manifest file input.
+The charts not installed yet are installed, the resources are applied again and the hooks are run again, in
+install order.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println("update called")
+		latimerContext := core.GetLatimerContext()
+		filePath := latimerContext.ManifestPath
+		logrus.Infof("Update %v\n", filePath)
+		selection := manifest.Selection{
+			Only:    updateOnly,
+			Exclude: updateExclude,
+			NoDeps:  updateNoDeps,
+		}
+		manifest, err := manifest.NewManifest(filePath, latimerContext.Values, latimerContext.Environment)
+		if err != nil {
+			logrus.Errorf("Error loading manifest file: %v", filePath)
+			exit(1)
+		}
+		if err := manifest.Select(selection, false); err != nil {
+			logrus.Errorf("Invalid selection of manifest items: %v", err)
+			exit(1)
+		}
+		latimerContext.ImageMirror = updateImageMirror
+		if err := initRepositories(latimerContext, manifest.Descriptor, false); err != nil {
+			logrus.Errorf("Error setting up helm repositories: %v", err)
+			exit(1)
+		}
+		latimerContext.Lock, err = loadLockFile(manifest.GetID())
+		if err != nil {
+			logrus.Errorf("Error loading lock file: %v", err)
+			exit(1)
+		}
+
+		descriptor := manifest.Descriptor
+		latimerContext.Targets = descriptor.Targets
+		// Each installable should work in it's own private temp directory
+		installableTempDir, err := ioutil.TempDir(latimerContext.LatimerTempDir, descriptor.Metadata.Name+"-*")
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer os.RemoveAll(installableTempDir) // clean up
+
+		sc := &core.SystemContext{
+			Name:        descriptor.Metadata.Name,
+			WorkTempDir: installableTempDir,
+			Context:     latimerContext,
+		}
+		if err := acquireLease(manifest, sc, updateForceUnlock); err != nil {
+			logrus.Errorf("Cannot lock manifest %v: %v", manifest.GetID(), err)
+			exit(1)
+		}
+		status := true
+		if err := pruneReleases(manifest, sc, updatePrune); err != nil {
+			logrus.Errorf("%v", err)
+			status = false
+		} else {
+			status = manifest.Upgrade(sc)
+		}
+		manifest.ReleaseLease()
+		if !status {
+			exit(1)
+		}
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(updateCmd)
-
-	// Here you will define your flags and configuration settings.
-
-	// Cobra supports Persistent Flags which will work for this command
-	// and all subcommands, e.g.:
-	// updateCmd.PersistentFlags().String("foo", "", "A help for foo")
-
-	// Cobra supports local flags which will only run when this command
-	// is called directly, e.g.:
-	// updateCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	updateCmd.Flags().StringSliceVar(&updateOnly, "only", []string{}, "Charts or packages to update along with their transitive prerequisites (default is all)")
+	updateCmd.Flags().StringSliceVar(&updateExclude, "exclude", []string{}, "Charts or packages to leave out")
+	updateCmd.Flags().BoolVar(&updateNoDeps, "no-deps", false, "Do not pull in the transitive prerequisites of the --only items")
+	updateCmd.Flags().StringVar(&updateImageMirror, "registry-mirror", "", "Registry the container images are rewritten to (eg registry.site.local:5000)")
+	updateCmd.Flags().BoolVar(&updatePrune, "prune", false, "Uninstall the releases latimer installed for the manifest whose chart is no longer in the manifest")
+	updateCmd.Flags().BoolVar(&updateForceUnlock, "force-unlock", false, "Take over the lock of the manifest held by another run (eg a run that was killed)")
 }
@@ -0,0 +1,116 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"latimer/helm"
+	"os"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var valuesOutput string
+
+// valuesCmd represents the values command
+var valuesCmd = &cobra.Command{
+	Use:   "values",
+	Short: "Manages encrypted values files",
+	Long: `Manages values files encrypted in the latimer format (AES-256-GCM).  The key is read from the
+LATIMER_VALUES_KEY environment variable (base64) or from the file named by LATIMER_VALUES_KEY_FILE.
+Encrypted values files are decrypted transparently at install time, eg:
+
+latimer values keygen > ~/.latimer/values.key
+export LATIMER_VALUES_KEY_FILE=~/.latimer/values.key
+latimer values encrypt values-mysql.yaml -o values-mysql.enc.yaml
+
+Values files encrypted with sops are also decrypted at install time, using the sops binary and the age or
+PGP keys available locally.`,
+}
+
+// valuesKeygenCmd represents the values keygen command
+var valuesKeygenCmd = &cobra.Command{
+	Use:   "keygen",
+	Short: "Generates a random key for encrypted values files",
+	Run: func(cmd *cobra.Command, args []string) {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			logrus.Errorf("Error generating key: %v", err)
+			exit(1)
+		}
+		fmt.Println(base64.StdEncoding.EncodeToString(key))
+	},
+}
+
+// valuesEncryptCmd represents the values encrypt command
+var valuesEncryptCmd = &cobra.Command{
+	Use:   "encrypt FILE",
+	Short: "Encrypts a values file",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runValuesCrypt(args[0], helm.EncryptValues)
+	},
+}
+
+// valuesDecryptCmd represents the values decrypt command
+var valuesDecryptCmd = &cobra.Command{
+	Use:   "decrypt FILE",
+	Short: "Decrypts a values file to the standard output (or --output)",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runValuesCrypt(args[0], helm.DecryptValues)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(valuesCmd)
+	valuesCmd.AddCommand(valuesKeygenCmd)
+	valuesCmd.AddCommand(valuesEncryptCmd)
+	valuesCmd.AddCommand(valuesDecryptCmd)
+	valuesEncryptCmd.Flags().StringVarP(&valuesOutput, "output", "o", "", "Path of the output file (default is the standard output)")
+	valuesDecryptCmd.Flags().StringVarP(&valuesOutput, "output", "o", "", "Path of the output file (default is the standard output)")
+}
+
+// runValuesCrypt encrypts or decrypts a values file with the values key, exiting on error
+func runValuesCrypt(filePath string, crypt func([]byte, []byte) ([]byte, error)) {
+	key, err := helm.ValuesKey()
+	if err != nil {
+		logrus.Errorf("%v", err)
+		exit(1)
+	}
+	content, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		logrus.Errorf("Error reading values file %v: %v", filePath, err)
+		exit(1)
+	}
+	out, err := crypt(content, key)
+	if err != nil {
+		logrus.Errorf("Error processing values file %v: %v", filePath, err)
+		exit(1)
+	}
+	if valuesOutput == "" {
+		os.Stdout.Write(out)
+		return
+	}
+	if err := ioutil.WriteFile(valuesOutput, out, 0600); err != nil {
+		logrus.Errorf("Error writing %v: %v", valuesOutput, err)
+		exit(1)
+	}
+}
@@ -0,0 +1,90 @@
+package core
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// BundleIndexName is the name of the index file at the root of a bundle
+	BundleIndexName = "index.yaml"
+)
+
+// BundledChart records a chart packaged in a bundle along with its resolved values and container images
+type BundledChart struct {
+	Name         string `json:"name"`
+	ChartLocator string `json:"chartLocator" yaml:"chartLocator"`
+	// Version is the exact chart version bundled
+	Version string `json:"version"`
+	// Archive is the path of the chart archive, relative to the bundle root
+	Archive string `json:"archive"`
+	// Digest is the sha256 digest of the chart archive (sha256:<hex>)
+	Digest string `json:"digest"`
+	// Values is the path of the resolved values file, relative to the bundle root
+	Values string `json:"values"`
+	// Images are the container image references found in the rendered templates of the chart
+	Images []string `json:"images"`
+}
+
+// BundleIndex describes the contents of an air-gapped bundle
+type BundleIndex struct {
+	Manifest string         `json:"manifest"`
+	Created  time.Time      `json:"created"`
+	Charts   []BundledChart `json:"charts"`
+	// Images are the container image references of all the bundled charts
+	Images []string `json:"images"`
+	// Checksums holds the sha256 digest of every file of the bundle indexed by relative path
+	Checksums map[string]string `json:"checksums"`
+	// Dir is the directory the bundle is extracted to
+	Dir string `json:"-" yaml:"-"`
+}
+
+// LoadBundleIndex reads the index of a bundle extracted to the given directory
+func LoadBundleIndex(dir string) (*BundleIndex, error) {
+	indexPath := filepath.Join(dir, BundleIndexName)
+	indexBytes, err := ioutil.ReadFile(indexPath)
+	if err != nil {
+		return nil, err
+	}
+	bi := new(BundleIndex)
+	if err := yaml.Unmarshal(indexBytes, bi); err != nil {
+		return nil, fmt.Errorf("Error parsing bundle index %v: %v", indexPath, err)
+	}
+	bi.Dir = dir
+	return bi, nil
+}
+
+// Save writes the index at the root of the bundle directory, with the charts sorted by name
+func (bi *BundleIndex) Save() error {
+	sort.Slice(bi.Charts, func(i, j int) bool {
+		return bi.Charts[i].Name < bi.Charts[j].Name
+	})
+	indexBytes, err := yaml.Marshal(bi)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(bi.Dir, BundleIndexName), indexBytes, 0644)
+}
+
+// GetChart returns the bundled entry of the named chart, or nil if the chart is not bundled
+func (bi *BundleIndex) GetChart(name string) *BundledChart {
+	if bi == nil {
+		return nil
+	}
+	for idx := range bi.Charts {
+		if bi.Charts[idx].Name == name {
+			return &bi.Charts[idx]
+		}
+	}
+	return nil
+}
+
+// Path returns the absolute path of a file of the bundle given its relative path
+func (bi *BundleIndex) Path(relPath string) string {
+	return filepath.Join(bi.Dir, filepath.FromSlash(relPath))
+}
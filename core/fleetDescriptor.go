@@ -0,0 +1,115 @@
+package core
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// DefaultFleetConcurrency is the default number of clusters of a wave installed at the same time
+	DefaultFleetConcurrency = 1
+	// DefaultFleetFailureThreshold is the default number of failed clusters halting a rollout
+	DefaultFleetFailureThreshold = 1
+)
+
+// WaveDescriptor describes a wave of a fleet rollout: a number or a percentage of the clusters of the fleet.  A
+// wave with neither takes all the remaining clusters.
+type WaveDescriptor struct {
+	Name string `json:"name,omitempty" yaml:"name,omitempty"`
+	// Count is the number of clusters of the wave
+	Count int `json:"count,omitempty" yaml:"count,omitempty"`
+	// Percent is the percentage of the clusters of the fleet in the wave (rounded up)
+	Percent int `json:"percent,omitempty" yaml:"percent,omitempty"`
+}
+
+// FleetDescriptor describes a list of clusters the same manifest is rolled out to, in waves
+type FleetDescriptor struct {
+	Name string `json:"name"`
+	// Clusters lists the clusters of the fleet in rollout order
+	Clusters []TargetDescriptor `json:"clusters"`
+	// Waves lists the waves of the rollout (one wave with all the clusters if empty)
+	Waves []WaveDescriptor `json:"waves,omitempty" yaml:"waves,omitempty"`
+	// Concurrency is the number of clusters of a wave installed at the same time
+	Concurrency int `json:"concurrency,omitempty" yaml:"concurrency,omitempty"`
+	// FailureThreshold is the number of failed clusters halting the rollout
+	FailureThreshold int `json:"failureThreshold,omitempty" yaml:"failureThreshold,omitempty"`
+}
+
+// FleetWave is a planned wave of a fleet rollout
+type FleetWave struct {
+	Name     string
+	Clusters []TargetDescriptor
+}
+
+// LoadFleetDescriptor reads a fleet file.  The kube config paths of the clusters are relative to the fleet file.
+func LoadFleetDescriptor(filePath string) (*FleetDescriptor, error) {
+	content, err := ReadLocator(filePath)
+	if err != nil {
+		return nil, err
+	}
+	fleet := new(FleetDescriptor)
+	if err := yaml.Unmarshal(content, fleet); err != nil {
+		return nil, fmt.Errorf("Error parsing fleet file %v: %v", filePath, err)
+	}
+	if len(fleet.Clusters) == 0 {
+		return nil, fmt.Errorf("No clusters in fleet file %v", filePath)
+	}
+	names := map[string]bool{}
+	for idx := range fleet.Clusters {
+		c := &fleet.Clusters[idx]
+		if c.Name == "" || names[c.Name] {
+			return nil, fmt.Errorf("Missing or duplicate cluster name %v in fleet file %v", c.Name, filePath)
+		}
+		names[c.Name] = true
+		if c.KubeConfig != "" && !isRemoteLocator(filePath) {
+			c.KubeConfig = resolveLocator(filePath, c.KubeConfig)
+		}
+	}
+	for _, w := range fleet.Waves {
+		if w.Count < 0 || w.Percent < 0 || w.Percent > 100 || (w.Count > 0 && w.Percent > 0) {
+			return nil, fmt.Errorf("Invalid wave %v in fleet file %v, expecting either a count or a percent", w, filePath)
+		}
+	}
+	if fleet.Concurrency <= 0 {
+		fleet.Concurrency = DefaultFleetConcurrency
+	}
+	if fleet.FailureThreshold <= 0 {
+		fleet.FailureThreshold = DefaultFleetFailureThreshold
+	}
+	logrus.Infof("Loaded fleet %v with %v clusters", fleet.Name, len(fleet.Clusters))
+	return fleet, nil
+}
+
+// Plan splits the clusters of the fleet into the waves of the rollout, in order.  Percentages are of the whole
+// fleet.  The clusters left after the declared waves make up a last wave.
+func (fleet *FleetDescriptor) Plan() []FleetWave {
+	total := len(fleet.Clusters)
+	remaining := fleet.Clusters
+	waves := make([]FleetWave, 0, len(fleet.Waves)+1)
+	for idx, w := range fleet.Waves {
+		if len(remaining) == 0 {
+			break
+		}
+		size := len(remaining)
+		if w.Count > 0 {
+			size = w.Count
+		} else if w.Percent > 0 {
+			size = (w.Percent*total + 99) / 100
+		}
+		if size > len(remaining) {
+			size = len(remaining)
+		}
+		name := w.Name
+		if name == "" {
+			name = fmt.Sprintf("wave-%v", idx+1)
+		}
+		waves = append(waves, FleetWave{Name: name, Clusters: remaining[:size]})
+		remaining = remaining[size:]
+	}
+	if len(remaining) > 0 {
+		waves = append(waves, FleetWave{Name: fmt.Sprintf("wave-%v", len(waves)+1), Clusters: remaining})
+	}
+	return waves
+}
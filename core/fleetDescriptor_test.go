@@ -0,0 +1,98 @@
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const fleetFile = `name: edge-fleet
+concurrency: 2
+waves:
+  - name: canary
+    count: 1
+  - percent: 25
+clusters:
+  - name: edge-01
+    kubeconfig: kubeconfig
+  - name: edge-02
+  - name: edge-03
+  - name: edge-04
+  - name: edge-05
+  - name: edge-06
+`
+
+func Test_Fleet(t *testing.T) {
+	tmpDir, err := ioutil.TempDir(os.TempDir(), "fleet-*")
+	if err != nil {
+		panic(err.Error())
+	}
+	defer os.RemoveAll(tmpDir)
+	fleetPath := filepath.Join(tmpDir, "fleet.yaml")
+	if err := ioutil.WriteFile(fleetPath, []byte(fleetFile), 0644); err != nil {
+		panic(err.Error())
+	}
+
+	t.Run("fleet-load", func(t *testing.T) {
+		fleet, err := LoadFleetDescriptor(fleetPath)
+		if err != nil {
+			t.Fatalf("Error loading fleet [%v]", err)
+		}
+		if fleet.Name != "edge-fleet" || len(fleet.Clusters) != 6 || fleet.Concurrency != 2 || fleet.FailureThreshold != DefaultFleetFailureThreshold {
+			t.Errorf("Unexpected fleet %v", fleet)
+		}
+		if fleet.Clusters[0].KubeConfig != filepath.Join(tmpDir, "kubeconfig") {
+			t.Errorf("Expecting the kube config relative to the fleet file, got %v", fleet.Clusters[0].KubeConfig)
+		}
+	})
+	t.Run("fleet-plan", func(t *testing.T) {
+		fleet, err := LoadFleetDescriptor(fleetPath)
+		if err != nil {
+			t.Fatalf("Error loading fleet [%v]", err)
+		}
+		waves := fleet.Plan()
+		expected := []struct {
+			name     string
+			clusters []string
+		}{
+			{"canary", []string{"edge-01"}},
+			{"wave-2", []string{"edge-02", "edge-03"}},
+			{"wave-3", []string{"edge-04", "edge-05", "edge-06"}},
+		}
+		if len(waves) != len(expected) {
+			t.Fatalf("Expecting %v waves, got %v", len(expected), waves)
+		}
+		for idx, wave := range waves {
+			if wave.Name != expected[idx].name || len(wave.Clusters) != len(expected[idx].clusters) {
+				t.Errorf("Unexpected wave %v", wave)
+				continue
+			}
+			for cidx, c := range wave.Clusters {
+				if c.Name != expected[idx].clusters[cidx] {
+					t.Errorf("Unexpected cluster %v in wave %v", c.Name, wave.Name)
+				}
+			}
+		}
+		fleet.Waves = nil
+		if waves := fleet.Plan(); len(waves) != 1 || len(waves[0].Clusters) != 6 {
+			t.Errorf("Expecting a single wave without waves, got %v", waves)
+		}
+	})
+	t.Run("fleet-invalid", func(t *testing.T) {
+		invalid := map[string]string{
+			"no-clusters": "name: empty\n",
+			"duplicate":   "name: dup\nclusters:\n  - name: a\n  - name: a\n",
+			"wave":        "name: wave\nclusters:\n  - name: a\nwaves:\n  - count: 1\n    percent: 10\n",
+		}
+		for name, content := range invalid {
+			invalidPath := filepath.Join(tmpDir, name+".yaml")
+			if err := ioutil.WriteFile(invalidPath, []byte(content), 0644); err != nil {
+				panic(err.Error())
+			}
+			if _, err := LoadFleetDescriptor(invalidPath); err == nil {
+				t.Errorf("Expecting an error loading fleet %v", name)
+			}
+		}
+	})
+}
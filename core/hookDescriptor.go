@@ -0,0 +1,69 @@
+package core
+
+import "fmt"
+
+const (
+	// PreInstallPhase places a hook before any item of the manifest is installed
+	PreInstallPhase = "pre-install"
+	// PostInstallPhase places a hook after all the items of the manifest are installed
+	PostInstallPhase = "post-install"
+	// PreDeletePhase places a hook before any item of the manifest is uninstalled
+	PreDeletePhase = "pre-delete"
+)
+
+// HookDescriptor describes a step run between the items of a manifest: a kubernetes Job from an inline spec, or a
+// local command.  A hook without phase is an item of the dependency graph, which can depend on and be depended on
+// like any chart.  A hook with a phase runs before (pre-install, pre-delete) or after (post-install) all the items.
+type HookDescriptor struct {
+	Name string `json:"name"`
+	// Kind is the kind of installable item, always job
+	Kind string `json:"kind"`
+	// Phase is the placement of the hook: pre-install, post-install, pre-delete or empty (dependency graph)
+	Phase string `json:"phase,omitempty" yaml:"phase,omitempty"`
+	// Namespace is the namespace of the Job
+	Namespace string `json:"namespace,omitempty" yaml:"namespace,omitempty"`
+	// Spec is the inline spec of the Job (the spec field of a batch/v1 Job)
+	Spec map[string]interface{} `json:"spec,omitempty" yaml:"spec,omitempty"`
+	// Command is the local command (and its arguments) to run instead of a Job
+	Command []string `json:"command,omitempty" yaml:"command,omitempty"`
+	// Env holds the environment variables added to the environment of the local command
+	Env map[string]string `json:"env,omitempty" yaml:"env,omitempty"`
+	// Dir is the working directory of the local command, relative to the manifest (defaults to the manifest directory)
+	Dir string `json:"dir,omitempty" yaml:"dir,omitempty"`
+	// Timeout is the value in seconds to wait for the Job or command to complete before giving up
+	Timeout int `json:"timeout,omitempty"`
+	// Enabled indicates whether the hook takes part in install/uninstall (defaults to true)
+	Enabled *bool `json:"enabled,omitempty" yaml:"enabled,omitempty"`
+	// Condition is a template value name (or boolean) which must be true for the hook to be enabled
+	Condition string `json:"condition,omitempty" yaml:"condition,omitempty"`
+}
+
+// IsEnabled returns whether the hook takes part in install/uninstall given the template values.  If not
+// enabled, the reason is returned as well.
+func (h *HookDescriptor) IsEnabled(values map[string]string) (bool, string) {
+	return isEnabled(h.Enabled, h.Condition, values)
+}
+
+// IsCommand returns whether the hook runs a local command rather than a Job
+func (h *HookDescriptor) IsCommand() bool {
+	return len(h.Command) > 0
+}
+
+// validate checks the kind, phase and the choice of a Job spec or a command
+func (h *HookDescriptor) validate() error {
+	if h.Kind == "" {
+		h.Kind = JobType
+	}
+	if h.Kind != JobType {
+		return fmt.Errorf("Invalid kind %v of hook %v, expecting %v", h.Kind, h.Name, JobType)
+	}
+	switch h.Phase {
+	case "", PreInstallPhase, PostInstallPhase, PreDeletePhase:
+	default:
+		return fmt.Errorf("Invalid phase %v of hook %v, expecting %v, %v or %v", h.Phase, h.Name, PreInstallPhase, PostInstallPhase, PreDeletePhase)
+	}
+	if (len(h.Spec) == 0) == (len(h.Command) == 0) {
+		return fmt.Errorf("Hook %v must have either a job spec or a command", h.Name)
+	}
+	return nil
+}
@@ -1,11 +1,13 @@
 package core
 
 import (
+	"fmt"
 	"io/ioutil"
 	"latimer/kube"
 	"log"
 	"os"
 	"strings"
+	"sync"
 
 	"github.com/sirupsen/logrus"
 )
@@ -14,11 +16,65 @@ import (
 type LatimerContext struct {
 	KubeConfigPath string
 	ManifestPath   string
+	// Environment is the name of the manifest environment profile to apply (empty for none)
+	Environment    string
 	KubeClient     *kube.K8sClient
 	LatimerTempDir string
 	Values         map[string]string
+	// RepositoryConfig is the path of the latimer-managed helm repositories file (helm default if empty)
+	RepositoryConfig string
+	// RepositoryCache is the directory of the latimer-managed helm repository indexes (helm default if empty)
+	RepositoryCache string
+	// ChartCacheDir is the directory of the chart cache shared between runs (no cache if empty)
+	ChartCacheDir string
+	// Registries holds the OCI registries (oci:// repositories) declared in the manifest
+	Registries []RepositoryDescriptor
+	// Repositories holds the chart repositories declared in the manifest, their credentials are only kept in memory
+	Repositories []RepositoryDescriptor
+	// Lock holds the pinned chart versions and digests (nil if no lock file)
+	Lock *LockFile
+	// ChartValues holds the per-chart value overrides (key=value expressions) indexed by chart name
+	ChartValues map[string][]string
+	// Bundle is the air-gapped bundle charts are installed from (nil to pull charts from their repositories)
+	Bundle *BundleIndex
+	// ImageMirror is the registry container images are rewritten to at install time (no rewrite if empty)
+	ImageMirror string
+	// Verify indicates whether the provenance of every chart must be verified, regardless of the chart settings
+	Verify bool
+	// Keyring is the path of the public keyring used to verify chart provenance (helm default if empty)
+	Keyring string
+	// Targets holds the clusters declared in the manifest, charts are installed to the --kubeconfig cluster by default
+	Targets []TargetDescriptor
+	// KubeContext is the context of the kube config file (its current context if empty)
+	KubeContext string
+	// Impersonate is the user the kubernetes and helm clients impersonate (no impersonation if empty)
+	Impersonate string
+	// ImpersonateGroups are the groups the kubernetes and helm clients impersonate
+	ImpersonateGroups []string
+	// KubeQPS is the maximum queries per second to the API servers (client-go default if 0)
+	KubeQPS float32
+	// KubeBurst is the maximum burst of queries to the API servers (client-go default if 0)
+	KubeBurst int
+	// StateNamespace is the namespace of the records latimer keeps in the cluster (eg the releases of a manifest)
+	StateNamespace string
+	// PurgeData indicates whether delete removes the persistent volume claims of every chart, regardless of its
+	// onDelete policy
+	PurgeData bool
+	// RetainedVolumes holds the persistent volume claims kept on delete, indexed by chart name
+	RetainedVolumes map[string][]kube.VolumeClaim
+	// targetClients holds the kubernetes clients of the targets, indexed by kube config path and context
+	targetClients map[string]*kube.K8sClient
+	// targetLock guards the target clients, clusters can be installed concurrently
+	targetLock sync.Mutex
 }
 
+const (
+	// ChartSetEnvVar is the environment variable holding per-chart value overrides separated by ';'
+	ChartSetEnvVar = "LATIMER_CHART_SET"
+	// DefaultStateNamespace is the default namespace of the records latimer keeps in the cluster
+	DefaultStateNamespace = "default"
+)
+
 var lc *LatimerContext = nil
 
 // GetLatimerContext creates a LatimerContext object
@@ -27,6 +83,7 @@ func GetLatimerContext() *LatimerContext {
 		logrus.Debugf("Creating LatimerContext\n")
 		lc = new(LatimerContext)
 		lc.Values = map[string]string{}
+		lc.ChartValues = map[string][]string{}
 	}
 	return lc
 }
@@ -47,7 +104,7 @@ func (latimerContext *LatimerContext) InitLatimer(kubeConfigPath string, manifes
 		}
 	}
 	logrus.Infof("LATIMER VALUES=[%v]", latimerContext.Values)
-	latimerContext.KubeClient, err = kube.NewK8sClient(kubeConfigPath)
+	latimerContext.KubeClient, err = kube.NewK8sClientFromFactory(latimerContext.newClientFactory(kubeConfigPath, latimerContext.KubeContext))
 	if err != nil {
 		panic(err.Error())
 	}
@@ -57,5 +114,84 @@ func (latimerContext *LatimerContext) InitLatimer(kubeConfigPath string, manifes
 		log.Fatal(err)
 	}
 	latimerContext.LatimerTempDir = tmpDir
-	logrus.Infof("INITIALIZED LATIMER CONTEXT kubeConfig=%v", latimerContext.KubeConfigPath)
+	logrus.Infof("INITIALIZED LATIMER CONTEXT kubeConfig=%v context=%v", latimerContext.KubeConfigPath, latimerContext.KubeContext)
+}
+
+// newClientFactory returns the factory of the clients of the given kube config context, with the impersonation
+// and rate limits of the context
+func (latimerContext *LatimerContext) newClientFactory(kubeConfigPath string, kubeContext string) *kube.ClientFactory {
+	return &kube.ClientFactory{
+		KubeConfig:        kubeConfigPath,
+		Context:           kubeContext,
+		Impersonate:       latimerContext.Impersonate,
+		ImpersonateGroups: latimerContext.ImpersonateGroups,
+		QPS:               latimerContext.KubeQPS,
+		Burst:             latimerContext.KubeBurst,
+	}
+}
+
+// InitChartValues loads the per-chart value overrides from the environment and the given command line entries.
+// Each entry has the form <chart>:<key1>=<val1>,<key2>=<val2>.  Command line entries take precedence over
+// the ones in the environment since they are applied last.
+func (latimerContext *LatimerContext) InitChartValues(chartSets []string) error {
+	entries := make([]string, 0)
+	if envSets, found := os.LookupEnv(ChartSetEnvVar); found {
+		for _, envItem := range strings.Split(envSets, ";") {
+			if strings.TrimSpace(envItem) != "" {
+				entries = append(entries, envItem)
+			}
+		}
+	}
+	entries = append(entries, chartSets...)
+	for _, entry := range entries {
+		chartVal := strings.SplitN(entry, ":", 2)
+		if len(chartVal) != 2 || strings.TrimSpace(chartVal[0]) == "" || strings.TrimSpace(chartVal[1]) == "" {
+			return fmt.Errorf("Invalid chart value override [%v], expecting <chart>:<key>=<value>", entry)
+		}
+		chartName := strings.TrimSpace(chartVal[0])
+		latimerContext.ChartValues[chartName] = append(latimerContext.ChartValues[chartName], strings.TrimSpace(chartVal[1]))
+	}
+	logrus.Infof("LATIMER CHART VALUES=[%v]", latimerContext.ChartValues)
+	return nil
+}
+
+// GetTarget returns the target by the given name, or nil if not found
+func (latimerContext *LatimerContext) GetTarget(name string) *TargetDescriptor {
+	for idx := range latimerContext.Targets {
+		if latimerContext.Targets[idx].Name == name {
+			return &latimerContext.Targets[idx]
+		}
+	}
+	return nil
+}
+
+// AddRetainedVolumes records the persistent volume claims of a chart kept on delete
+func (latimerContext *LatimerContext) AddRetainedVolumes(chartName string, claims []kube.VolumeClaim) {
+	if latimerContext.RetainedVolumes == nil {
+		latimerContext.RetainedVolumes = map[string][]kube.VolumeClaim{}
+	}
+	latimerContext.RetainedVolumes[chartName] = append(latimerContext.RetainedVolumes[chartName], claims...)
+}
+
+// targetKubeClient returns the kubernetes client of the target, created on first use
+func (latimerContext *LatimerContext) targetKubeClient(target *TargetDescriptor) (*kube.K8sClient, error) {
+	latimerContext.targetLock.Lock()
+	defer latimerContext.targetLock.Unlock()
+	kubeConfigPath := target.KubeConfig
+	if kubeConfigPath == "" {
+		kubeConfigPath = latimerContext.KubeConfigPath
+	}
+	key := kubeConfigPath + "#" + target.Context
+	if kubeClient, found := latimerContext.targetClients[key]; found {
+		return kubeClient, nil
+	}
+	kubeClient, err := kube.NewK8sClientFromFactory(latimerContext.newClientFactory(kubeConfigPath, target.Context))
+	if err != nil {
+		return nil, fmt.Errorf("Cannot connect to target %v: %v", target.Name, err)
+	}
+	if latimerContext.targetClients == nil {
+		latimerContext.targetClients = map[string]*kube.K8sClient{}
+	}
+	latimerContext.targetClients[key] = kubeClient
+	return kubeClient, nil
 }
@@ -0,0 +1,109 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"latimer/kube"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// FileScheme is the scheme of the locators of local files (file:///abs/path)
+	FileScheme = "file://"
+	// ConfigMapScheme is the scheme of the locators of config map keys (configmap://namespace/name/key)
+	ConfigMapScheme = "configmap://"
+	// SecretScheme is the scheme of the locators of secret keys (secret://namespace/name/key)
+	SecretScheme = "secret://"
+	// Timeout to fetch a remote locator
+	remoteLocatorTimeout = 60 * time.Second
+)
+
+// hasScheme returns whether the locator is a URL with a scheme (eg http://, file://, configmap://)
+func hasScheme(locator string) bool {
+	idx := strings.Index(locator, "://")
+	return idx > 0 && !strings.ContainsAny(locator[:idx], "/\\.")
+}
+
+// ReadLocator returns the contents a locator points to: a http(s) URL, a file:// URL, a configmap://ns/name/key or
+// secret://ns/name/key in the cluster of the latimer context, or a local path
+func ReadLocator(locator string) ([]byte, error) {
+	var kubeClient *kube.K8sClient
+	if lc != nil {
+		kubeClient = lc.KubeClient
+	}
+	return readLocator(locator, kubeClient)
+}
+
+// ReadLocator returns the contents a locator points to, like core.ReadLocator, reading the config map and secret
+// locators in the cluster of the system context
+func (sc *SystemContext) ReadLocator(locator string) ([]byte, error) {
+	return readLocator(locator, sc.GetKubeClient())
+}
+
+// IsClusterLocator returns whether the locator points to a config map or secret key of a cluster
+func IsClusterLocator(locator string) bool {
+	return strings.HasPrefix(locator, ConfigMapScheme) || strings.HasPrefix(locator, SecretScheme)
+}
+
+// readLocator returns the contents a locator points to, reading the cluster locators with the kubernetes client
+func readLocator(locator string, kubeClient *kube.K8sClient) ([]byte, error) {
+	switch {
+	case isRemoteLocator(locator):
+		return readRemoteLocator(locator)
+	case strings.HasPrefix(locator, FileScheme):
+		fileURL, err := url.Parse(locator)
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.ReadFile(fileURL.Path)
+	case strings.HasPrefix(locator, ConfigMapScheme):
+		namespace, name, key, err := parseObjectLocator(locator, ConfigMapScheme)
+		if err != nil {
+			return nil, err
+		}
+		if kubeClient == nil {
+			return nil, errors.New("No kubernetes client to read " + locator)
+		}
+		value, err := kubeClient.GetConfigMapValue(namespace, name, key)
+		return []byte(value), err
+	case strings.HasPrefix(locator, SecretScheme):
+		namespace, name, key, err := parseObjectLocator(locator, SecretScheme)
+		if err != nil {
+			return nil, err
+		}
+		if kubeClient == nil {
+			return nil, errors.New("No kubernetes client to read " + locator)
+		}
+		return kubeClient.GetSecretValue(namespace, name, key)
+	case hasScheme(locator):
+		return nil, fmt.Errorf("Unsupported locator %v", locator)
+	}
+	return ioutil.ReadFile(locator)
+}
+
+// readRemoteLocator fetches the contents of a http(s) URL
+func readRemoteLocator(locator string) ([]byte, error) {
+	client := http.Client{Timeout: remoteLocatorTimeout}
+	resp, err := client.Get(locator)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Error fetching %v: %v", locator, resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// parseObjectLocator splits a <scheme>namespace/name/key locator of a key of a kubernetes object
+func parseObjectLocator(locator string, scheme string) (string, string, string, error) {
+	parts := strings.Split(strings.TrimPrefix(locator, scheme), "/")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return "", "", "", fmt.Errorf("Invalid locator %v, expected %vnamespace/name/key", locator, scheme)
+	}
+	return parts[0], parts[1], parts[2], nil
+}
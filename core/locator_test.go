@@ -0,0 +1,72 @@
+package core
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_Locator(t *testing.T) {
+	tmpDir, err := ioutil.TempDir(os.TempDir(), "locator-*")
+	if err != nil {
+		panic(err.Error())
+	}
+	defer os.RemoveAll(tmpDir)
+	valuesPath := filepath.Join(tmpDir, "values.yaml")
+	if err := ioutil.WriteFile(valuesPath, []byte("replicaCount: 1\n"), 0644); err != nil {
+		panic(err.Error())
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/config/values.yaml" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("replicaCount: 2\n"))
+	}))
+	defer server.Close()
+
+	t.Run("locator-resolve", func(t *testing.T) {
+		manifestPath := filepath.Join(tmpDir, "install-manifest.yaml")
+		locators := map[string]string{
+			"values.yaml":                           valuesPath,
+			"file://" + valuesPath:                  "file://" + valuesPath,
+			server.URL + "/config/values.yaml":      server.URL + "/config/values.yaml",
+			"configmap://paas/redis/values.yaml":    "configmap://paas/redis/values.yaml",
+			"secret://paas/redis-creds/values.yaml": "secret://paas/redis-creds/values.yaml",
+		}
+		for locator, expected := range locators {
+			if resolved := resolveLocator(manifestPath, locator); resolved != expected {
+				t.Errorf("Locator %v resolved to %v, expected %v", locator, resolved, expected)
+			}
+		}
+		if resolved := resolveLocator(server.URL+"/manifests/install-manifest.yaml", "../config/values.yaml"); resolved != server.URL+"/config/values.yaml" {
+			t.Errorf("Relative locator of a remote manifest resolved to %v", resolved)
+		}
+	})
+
+	t.Run("locator-read", func(t *testing.T) {
+		contents := map[string]string{
+			valuesPath:                         "replicaCount: 1\n",
+			"file://" + valuesPath:             "replicaCount: 1\n",
+			server.URL + "/config/values.yaml": "replicaCount: 2\n",
+		}
+		for locator, expected := range contents {
+			content, err := ReadLocator(locator)
+			if err != nil || string(content) != expected {
+				t.Errorf("Unexpected contents of %v: %v [%v]", locator, string(content), err)
+			}
+		}
+		for _, locator := range []string{server.URL + "/missing.yaml", "configmap://paas/redis", "git://example.com/values.yaml"} {
+			if _, err := ReadLocator(locator); err == nil {
+				t.Errorf("Expecting an error reading %v", locator)
+			}
+		}
+		if _, err := ReadLocator("secret://paas/redis-creds/values.yaml"); err == nil || !strings.Contains(err.Error(), "kubernetes client") {
+			t.Errorf("Expecting an error reading a secret without kubernetes client [%v]", err)
+		}
+	})
+}
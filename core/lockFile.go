@@ -0,0 +1,116 @@
+package core
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// LockFileName is the default name of the lock file, located next to the manifest
+	LockFileName = "latimer.lock"
+)
+
+// LockedChart records the exact version and digest a chart resolved to
+type LockedChart struct {
+	Name         string `json:"name"`
+	ChartLocator string `json:"chartLocator" yaml:"chartLocator"`
+	// Constraint is the version constraint of the chart at the time of locking
+	Constraint string `json:"constraint,omitempty" yaml:"constraint,omitempty"`
+	// Version is the exact chart version resolved
+	Version string `json:"version"`
+	// Digest is the sha256 digest of the chart archive (sha256:<hex>)
+	Digest string `json:"digest"`
+}
+
+// LockFile pins the exact chart versions and digests of a manifest
+type LockFile struct {
+	Manifest  string        `json:"manifest"`
+	Generated time.Time     `json:"generated"`
+	Charts    []LockedChart `json:"charts"`
+}
+
+// DefaultLockFilePath returns the path of the lock file for the given manifest path
+func DefaultLockFilePath(manifestPath string) string {
+	return filepath.Join(filepath.Dir(manifestPath), LockFileName)
+}
+
+// LoadLockFile reads a lock file.  Returns nil (and no error) if the lock file does not exist.
+func LoadLockFile(filePath string) (*LockFile, error) {
+	lockBytes, err := ioutil.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	lf := new(LockFile)
+	if err := yaml.Unmarshal(lockBytes, lf); err != nil {
+		return nil, fmt.Errorf("Error parsing lock file %v: %v", filePath, err)
+	}
+	logrus.Infof("Loaded lock file %v with %v charts", filePath, len(lf.Charts))
+	return lf, nil
+}
+
+// Save writes the lock file to the given path, with the charts sorted by name
+func (lf *LockFile) Save(filePath string) error {
+	sort.Slice(lf.Charts, func(i, j int) bool {
+		return lf.Charts[i].Name < lf.Charts[j].Name
+	})
+	lockBytes, err := yaml.Marshal(lf)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filePath, lockBytes, 0644)
+}
+
+// GetChart returns the locked entry of the named chart, or nil if the chart is not locked
+func (lf *LockFile) GetChart(name string) *LockedChart {
+	if lf == nil {
+		return nil
+	}
+	for idx := range lf.Charts {
+		if lf.Charts[idx].Name == name {
+			return &lf.Charts[idx]
+		}
+	}
+	return nil
+}
+
+// Check verifies the locked entry is still valid for the chart descriptor: same chart locator and a locked
+// version satisfying the current version constraint.
+func (lc *LockedChart) Check(c *ChartDescriptor) error {
+	if lc.ChartLocator != c.ChartLocator {
+		return fmt.Errorf("Lock file is out of date for chart %v: locked %v, manifest has %v", c.Name, lc.ChartLocator, c.ChartLocator)
+	}
+	ok, err := SatisfiesConstraint(lc.Version, c.Version)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("Lock file is out of date for chart %v: locked version %v does not satisfy %v", c.Name, lc.Version, c.Version)
+	}
+	return nil
+}
+
+// SatisfiesConstraint returns whether the version satisfies the semver constraint (any version if empty)
+func SatisfiesConstraint(version string, constraint string) (bool, error) {
+	if constraint == "" {
+		return true, nil
+	}
+	c, err := semver.NewConstraint(constraint)
+	if err != nil {
+		return false, fmt.Errorf("Invalid version constraint %v: %v", constraint, err)
+	}
+	v, err := semver.NewVersion(version)
+	if err != nil {
+		return false, fmt.Errorf("Invalid chart version %v: %v", version, err)
+	}
+	return c.Check(v), nil
+}
@@ -0,0 +1,65 @@
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_LockFile(t *testing.T) {
+	t.Run("lock-file-save-load", func(t *testing.T) {
+		tmpDir, err := ioutil.TempDir(os.TempDir(), "lock-*")
+		if err != nil {
+			panic(err.Error())
+		}
+		defer os.RemoveAll(tmpDir)
+
+		lockPath := DefaultLockFilePath(filepath.Join(tmpDir, "install-manifest.yaml"))
+		lf, err := LoadLockFile(lockPath)
+		if lf != nil || err != nil {
+			t.Errorf("Expecting no lock file at %v: %v [%v]", lockPath, lf, err)
+		}
+		lf = &LockFile{
+			Manifest: "install-manifest",
+			Charts: []LockedChart{
+				{Name: "redis", ChartLocator: "bitnami/redis", Constraint: "~10.7", Version: "10.7.9", Digest: "sha256:abcd"},
+				{Name: "mysql", ChartLocator: "stable/mysql", Version: "1.6.6", Digest: "sha256:ef01"},
+			},
+		}
+		if err := lf.Save(lockPath); err != nil {
+			t.Errorf("Error saving lock file %v [%v]", lockPath, err)
+		}
+		loaded, err := LoadLockFile(lockPath)
+		if err != nil {
+			t.Errorf("Error loading lock file %v [%v]", lockPath, err)
+		}
+		if loaded.Manifest != lf.Manifest || len(loaded.Charts) != 2 || loaded.Charts[0].Name != "mysql" {
+			t.Errorf("Unexpected lock file contents: %v", loaded)
+		}
+		if redis := loaded.GetChart("redis"); redis == nil || redis.Digest != "sha256:abcd" {
+			t.Errorf("Expecting locked redis chart: %v", redis)
+		}
+	})
+
+	t.Run("lock-file-check", func(t *testing.T) {
+		locked := LockedChart{Name: "redis", ChartLocator: "bitnami/redis", Version: "10.7.9"}
+		tests := []struct {
+			chartLocator string
+			constraint   string
+			valid        bool
+		}{
+			{"bitnami/redis", "", true},
+			{"bitnami/redis", "~10.7", true},
+			{"bitnami/redis", ">=11.0.0", false},
+			{"stable/redis", "", false},
+		}
+		for _, test := range tests {
+			c := ChartDescriptor{Name: "redis", ChartLocator: test.chartLocator, Version: test.constraint}
+			err := locked.Check(&c)
+			if (err == nil) != test.valid {
+				t.Errorf("Unexpected lock check result for %v %v: %v", test.chartLocator, test.constraint, err)
+			}
+		}
+	})
+}
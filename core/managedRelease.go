@@ -0,0 +1,63 @@
+package core
+
+import "fmt"
+
+// ManagedRelease identifies a helm release installed by latimer for a manifest
+type ManagedRelease struct {
+	// Chart is the name of the chart the release was installed from
+	Chart       string `json:"chart"`
+	ReleaseName string `json:"releaseName"`
+	Namespace   string `json:"namespace"`
+	// Target is the name of the target cluster of the release (empty for the cluster of the manifest)
+	Target string `json:"target,omitempty"`
+}
+
+// NewManagedRelease returns the managed release of a chart
+func NewManagedRelease(c *ChartDescriptor) ManagedRelease {
+	return ManagedRelease{Chart: c.Name, ReleaseName: c.ReleaseName, Namespace: c.Namespace, Target: c.Target}
+}
+
+// Key identifies the release regardless of the name of its chart (eg a renamed chart keeps its release)
+func (r ManagedRelease) Key() string {
+	return r.Target + "/" + r.Namespace + "/" + r.ReleaseName
+}
+
+// String returns the description of the release for user messages
+func (r ManagedRelease) String() string {
+	if r.Target == "" {
+		return fmt.Sprintf("%v (chart %v, namespace %v)", r.ReleaseName, r.Chart, r.Namespace)
+	}
+	return fmt.Sprintf("%v (chart %v, namespace %v, target %v)", r.ReleaseName, r.Chart, r.Namespace, r.Target)
+}
+
+// MergeManagedReleases adds the releases installed by a run to the previously managed releases.  The previous
+// releases keep their order, the new ones follow in install order.
+func MergeManagedReleases(previous []ManagedRelease, installed []ManagedRelease) []ManagedRelease {
+	merged := make([]ManagedRelease, 0, len(previous)+len(installed))
+	indexes := map[string]int{}
+	for _, r := range append(append([]ManagedRelease{}, previous...), installed...) {
+		if idx, found := indexes[r.Key()]; found {
+			// The chart of a release may have been renamed
+			merged[idx] = r
+			continue
+		}
+		indexes[r.Key()] = len(merged)
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+// RemoveManagedReleases returns the managed releases without the given ones
+func RemoveManagedReleases(releases []ManagedRelease, removed []ManagedRelease) []ManagedRelease {
+	removedKeys := map[string]bool{}
+	for _, r := range removed {
+		removedKeys[r.Key()] = true
+	}
+	kept := make([]ManagedRelease, 0, len(releases))
+	for _, r := range releases {
+		if !removedKeys[r.Key()] {
+			kept = append(kept, r)
+		}
+	}
+	return kept
+}
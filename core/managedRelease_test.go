@@ -0,0 +1,31 @@
+package core
+
+import "testing"
+
+func Test_ManagedReleases(t *testing.T) {
+	redis := ManagedRelease{Chart: "redis", ReleaseName: "test-redis", Namespace: "paas"}
+	mysql := ManagedRelease{Chart: "mysql", ReleaseName: "test-mysql", Namespace: "paas"}
+	agent := ManagedRelease{Chart: "agent", ReleaseName: "agent", Namespace: "paas", Target: "edge"}
+
+	t.Run("managed-releases-merge", func(t *testing.T) {
+		renamed := redis
+		renamed.Chart = "cache"
+		merged := MergeManagedReleases([]ManagedRelease{redis, mysql}, []ManagedRelease{agent, renamed})
+		if len(merged) != 3 || merged[0].Chart != "cache" || merged[1] != mysql || merged[2] != agent {
+			t.Errorf("Unexpected merged releases %v", merged)
+		}
+	})
+	t.Run("managed-releases-remove", func(t *testing.T) {
+		kept := RemoveManagedReleases([]ManagedRelease{redis, mysql, agent}, []ManagedRelease{mysql, agent})
+		if len(kept) != 1 || kept[0] != redis {
+			t.Errorf("Unexpected kept releases %v", kept)
+		}
+	})
+	t.Run("managed-releases-key", func(t *testing.T) {
+		other := agent
+		other.Target = ""
+		if agent.Key() == other.Key() {
+			t.Errorf("Expecting releases of different targets to differ")
+		}
+	})
+}
@@ -3,9 +3,12 @@ package core
 import (
 	"bufio"
 	"bytes"
+	"fmt"
 	"html/template"
 	"log"
-	"path/filepath"
+	"path"
+	"strconv"
+	"strings"
 
 	"github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v2"
@@ -18,48 +21,388 @@ const (
 	PackageType = "package"
 	// ManifestType is constant denoting an installable item of type manifest
 	ManifestType = "manifest"
+	// ManifestsType is constant denoting an installable item of type manifests (directory of yaml manifests)
+	ManifestsType = "manifests"
+	// KustomizeType is constant denoting an installable item of type kustomize (kustomize overlay)
+	KustomizeType = "kustomize"
+	// JobType is constant denoting an installable item of type job (hook running a Job or a local command)
+	JobType = "job"
 	// Default timeout for a chart is 5 minutes
 	DefaultChartTimeoutSeconds = 300
+
+	// DropDisabledDependencies silently drops the dependencies on disabled items (default policy)
+	DropDisabledDependencies = "drop"
+	// FailDisabledDependencies flags dependencies on disabled items as errors
+	FailDisabledDependencies = "error"
+
+	// RetainVolumes keeps the persistent volume claims of a chart on delete (default policy)
+	RetainVolumes = "retain"
+	// DeleteVolumes deletes the persistent volume claims of a chart on delete
+	DeleteVolumes = "delete"
 )
 
+// ValuesDescriptor describes a values file for a chart
+type ValuesDescriptor struct {
+	// URL is the locator for the values yaml file: a path relative to the manifest, a file:// or http(s) URL, or a
+	// configmap://namespace/name/key or secret://namespace/name/key in the cluster
+	URL string `json:"url"`
+	// Template indicates whether the values file is a template rendered with the latimer values (eg
+	// {{ .Env }}), a missing value is an error.  Other values files are used as is.
+	Template bool `json:"template,omitempty" yaml:"template,omitempty"`
+}
+
 // ChartDescriptor describes a chart
 type ChartDescriptor struct {
 	Name         string `json:"name"`
 	ChartName    string `json:"chartName" yaml:"chartName"`
 	Namespace    string `json:"namespace"`
 	ChartLocator string `json:"chartLocator" yaml:"chartLocator"`
-	ReleaseName  string `json:"releaseName" yaml:"releaseName"`
+	// Version is the chart version constraint (eg 10.7.9, ~10.7, >=10.0.0 <11.0.0), latest if empty
+	Version     string `json:"version,omitempty" yaml:"version,omitempty"`
+	ReleaseName string `json:"releaseName" yaml:"releaseName"`
 	// Timeout is the value in seconds to wait for chart to come up before giving up
 	Timeout int `json:"timeout,omitempty"`
-	Values  []struct {
-		// URL is the locator for the values yaml file
-		URL string `json:"url"`
-	} `json:"values,omitempty"`
+	// DeleteTimeout is the value in seconds to wait for the objects of the chart to be gone on uninstall
+	DeleteTimeout int `json:"deleteTimeout,omitempty" yaml:"deleteTimeout,omitempty"`
+	// WaitForVolumes indicates whether uninstall also waits for the volume claims of the chart being deleted
+	WaitForVolumes bool `json:"waitForVolumes,omitempty" yaml:"waitForVolumes,omitempty"`
+	// OnDelete is the policy for the data of the chart on uninstall
+	OnDelete DeletePolicy `json:"onDelete,omitempty" yaml:"onDelete,omitempty"`
+	// Enabled indicates whether the chart takes part in install/uninstall (defaults to true)
+	Enabled *bool `json:"enabled,omitempty" yaml:"enabled,omitempty"`
+	// Condition is a template value name (or boolean) which must be true for the chart to be enabled
+	Condition string             `json:"condition,omitempty" yaml:"condition,omitempty"`
+	Values    []ValuesDescriptor `json:"values,omitempty"`
+	// Verify indicates whether the chart provenance file must be verified against the keyring before install
+	Verify bool `json:"verify,omitempty" yaml:"verify,omitempty"`
+	// Target is the name of the target cluster the chart is installed to (the --kubeconfig cluster if empty)
+	Target string `json:"target,omitempty" yaml:"target,omitempty"`
+	// CreateNamespace indicates whether the namespace is created if missing (defaults to the manifest setting)
+	CreateNamespace *bool `json:"createNamespace,omitempty" yaml:"createNamespace,omitempty"`
+	// DeleteNamespace indicates whether the namespace is deleted on uninstall, if latimer created it and it is
+	// empty (defaults to the manifest setting)
+	DeleteNamespace *bool `json:"deleteNamespace,omitempty" yaml:"deleteNamespace,omitempty"`
+	// NamespaceMetadata holds the labels and annotations declared in the manifest for the namespace
+	NamespaceMetadata *NamespaceDescriptor `json:"-" yaml:"-"`
+}
+
+// CreatesNamespace returns whether the namespace of the chart is created if missing
+func (c *ChartDescriptor) CreatesNamespace() bool {
+	return c.CreateNamespace != nil && *c.CreateNamespace
+}
+
+// DeletesNamespace returns whether the namespace of the chart is deleted on uninstall
+func (c *ChartDescriptor) DeletesNamespace() bool {
+	return c.DeleteNamespace != nil && *c.DeleteNamespace
+}
+
+// DeletePolicy describes what happens to the data of a chart on uninstall
+type DeletePolicy struct {
+	// PVCs is the policy for the persistent volume claims of the chart: retain (default) or delete
+	PVCs string `json:"pvcs,omitempty" yaml:"pvcs,omitempty"`
+}
+
+// IsEnabled returns whether the chart takes part in install/uninstall given the template values.  If not
+// enabled, the reason is returned as well.
+func (c *ChartDescriptor) IsEnabled(values map[string]string) (bool, string) {
+	return isEnabled(c.Enabled, c.Condition, values)
+}
+
+// ChartOverride describes the chart fields an environment can override.  Empty fields are left untouched.
+type ChartOverride struct {
+	Name      string             `json:"name"`
+	Namespace string             `json:"namespace,omitempty" yaml:"namespace,omitempty"`
+	Version   string             `json:"version,omitempty" yaml:"version,omitempty"`
+	Timeout   int                `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+	Enabled   *bool              `json:"enabled,omitempty" yaml:"enabled,omitempty"`
+	Values    []ValuesDescriptor `json:"values,omitempty" yaml:"values,omitempty"`
+}
+
+// EnvironmentDescriptor describes a named profile (eg dev, stage, prod) of a manifest
+type EnvironmentDescriptor struct {
+	Name string `json:"name"`
+	// Values are the template values of the environment.  Values given on the command line take precedence.
+	Values map[string]string `json:"values,omitempty" yaml:"values,omitempty"`
+	// Charts holds the chart field overrides of the environment
+	Charts []ChartOverride `json:"charts,omitempty" yaml:"charts,omitempty"`
 }
 
 // PackageDescriptor groups a collection of chart descriptors
 type PackageDescriptor struct {
 	Name   string            `json:"name"`
 	Charts []InstallableItem `json:"charts"`
+	// Enabled indicates whether the package takes part in install/uninstall (defaults to true)
+	Enabled *bool `json:"enabled,omitempty" yaml:"enabled,omitempty"`
+	// Condition is a template value name (or boolean) which must be true for the package to be enabled
+	Condition string `json:"condition,omitempty" yaml:"condition,omitempty"`
+}
+
+// IsEnabled returns whether the package takes part in install/uninstall given the template values.  If not
+// enabled, the reason is returned as well.
+func (p *PackageDescriptor) IsEnabled(values map[string]string) (bool, string) {
+	return isEnabled(p.Enabled, p.Condition, values)
+}
+
+// isEnabled evaluates the enabled flag and condition of an item.  The condition is either a boolean literal
+// (eg the result of templating the manifest) or the name of a template value, optionally negated with '!'.
+func isEnabled(enabled *bool, condition string, values map[string]string) (bool, string) {
+	if enabled != nil && !*enabled {
+		return false, "enabled: false"
+	}
+	condition = strings.TrimSpace(condition)
+	if condition == "" {
+		return true, ""
+	}
+	negate := strings.HasPrefix(condition, "!")
+	name := strings.TrimSpace(strings.TrimPrefix(condition, "!"))
+	result, err := strconv.ParseBool(name)
+	if err != nil {
+		value, found := values[name]
+		if !found {
+			return false, fmt.Sprintf("condition %v: value %v not set", condition, name)
+		}
+		result, err = strconv.ParseBool(strings.TrimSpace(value))
+		if err != nil {
+			return false, fmt.Sprintf("condition %v: value %v=%v is not a boolean", condition, name, value)
+		}
+	}
+	if result == negate {
+		return false, fmt.Sprintf("condition %v is false", condition)
+	}
+	return true, ""
 }
 
 // ManifestDescriptor describes collection of packages and charts to be installed
 type ManifestDescriptor struct {
-	Metadata        InstallableItem     `json:"metadata"`
-	Charts          []ChartDescriptor   `json:"charts"`
-	Packages        []PackageDescriptor `json:"packages,omitempty"`
+	Metadata InstallableItem     `json:"metadata"`
+	Charts   []ChartDescriptor   `json:"charts"`
+	Packages []PackageDescriptor `json:"packages,omitempty"`
+	// Resources lists the plain kubernetes objects (yaml manifests or kustomize overlays) installed without helm
+	Resources []ResourceDescriptor `json:"resources,omitempty" yaml:"resources,omitempty"`
+	// Hooks lists the Jobs and local commands run between the items of the manifest
+	Hooks           []HookDescriptor `json:"hooks,omitempty" yaml:"hooks,omitempty"`
 	DependencyItems []struct {
 		Name     string            `json:"name"`
 		Requires []InstallableItem `json:"requires"`
 	} `json:"dependencies" yaml:"dependencies"`
+	Environments []EnvironmentDescriptor `json:"environments,omitempty" yaml:"environments,omitempty"`
+	// Repositories lists the helm chart repositories the charts are pulled from
+	Repositories []RepositoryDescriptor `json:"repositories,omitempty" yaml:"repositories,omitempty"`
+	// Targets lists the clusters the charts can be installed to
+	Targets []TargetDescriptor `json:"targets,omitempty" yaml:"targets,omitempty"`
+	// Namespaces lists the labels and annotations of the namespaces of the charts
+	Namespaces []NamespaceDescriptor `json:"namespaces,omitempty" yaml:"namespaces,omitempty"`
+	// CreateNamespace is whether the namespaces of the charts are created if missing (charts can override it)
+	CreateNamespace bool `json:"createNamespace,omitempty" yaml:"createNamespace,omitempty"`
+	// DeleteNamespace is whether the namespaces latimer created are deleted on uninstall once empty (charts can
+	// override it)
+	DeleteNamespace bool `json:"deleteNamespace,omitempty" yaml:"deleteNamespace,omitempty"`
+	// Includes lists the manifests whose items are merged (namespaced by the include name) into this one
+	Includes []IncludeDescriptor `json:"includes,omitempty" yaml:"includes,omitempty"`
+	// Included lists the items contributed by each included manifest once merged
+	Included []IncludedManifest `json:"-" yaml:"-"`
+	// DisabledDependencies is the policy for dependencies on disabled items: drop (default) or error
+	DisabledDependencies string `json:"disabledDependencies,omitempty" yaml:"disabledDependencies,omitempty"`
+
+	// TemplateValues are the values used to template the manifest (and its values files)
+	TemplateValues map[string]string `json:"-" yaml:"-"`
+	// Scopes holds the template values and policies of the items merged from included manifests, by item name
+	Scopes map[string]ItemScope `json:"-" yaml:"-"`
+}
+
+// ItemScope holds the template values and the disabled dependencies policy of the manifest an item was declared
+// in
+type ItemScope struct {
+	TemplateValues       map[string]string
+	DisabledDependencies string
 }
 
-// LoadManifestDescriptor creates a new manifest descriptor object from file contents
-func LoadManifestDescriptor(filePath string, values map[string]string) (*ManifestDescriptor, error) {
+// ScopeOf returns the scope of the named item: the scope of the included manifest it was declared in, or the one
+// of the manifest.  Included manifests without a disabled dependencies policy inherit the one of the manifest.
+func (m *ManifestDescriptor) ScopeOf(name string) ItemScope {
+	scope, found := m.Scopes[name]
+	if !found {
+		scope = ItemScope{TemplateValues: m.TemplateValues}
+	}
+	if scope.DisabledDependencies == "" {
+		scope.DisabledDependencies = m.DisabledDependencies
+	}
+	return scope
+}
+
+// LoadManifestDescriptor creates a new manifest descriptor object from file contents.  If an environment name
+// is given, its template values and chart overrides are applied to the descriptor.  Included manifests are
+// loaded and merged into the descriptor.
+func LoadManifestDescriptor(filePath string, values map[string]string, environment string) (*ManifestDescriptor, error) {
+	return loadManifestDescriptor(filePath, values, environment, true, []string{})
+}
+
+// loadManifestDescriptor loads the manifest at the given locator.  The includeStack holds the locators of the
+// manifests including this one, to detect include cycles.
+func loadManifestDescriptor(filePath string, values map[string]string, environment string, requireEnv bool, includeStack []string) (*ManifestDescriptor, error) {
+	m, err := parseManifestDescriptor(filePath, values)
+	if err != nil {
+		return nil, err
+	}
+	if environment != "" && (requireEnv || m.GetEnvironment(environment) != nil) {
+		env := m.GetEnvironment(environment)
+		if env == nil {
+			return nil, fmt.Errorf("Environment %v not found in manifest %v", environment, filePath)
+		}
+		if len(env.Values) > 0 {
+			// Re-template the manifest with the environment values underneath the given ones
+			envValues := map[string]string{}
+			for k, v := range env.Values {
+				envValues[k] = v
+			}
+			for k, v := range values {
+				envValues[k] = v
+			}
+			values = envValues
+			m, err = parseManifestDescriptor(filePath, values)
+			if err != nil {
+				return nil, err
+			}
+			env = m.GetEnvironment(environment)
+		}
+		logrus.Infof("Applying environment %v to manifest %v", environment, filePath)
+		m.applyEnvironment(env)
+	}
+	m.TemplateValues = values
+	switch m.DisabledDependencies {
+	case "":
+		// An included manifest inherits the policy of the including one
+		if len(includeStack) == 0 {
+			m.DisabledDependencies = DropDisabledDependencies
+		}
+	case DropDisabledDependencies, FailDisabledDependencies:
+	default:
+		return nil, fmt.Errorf("Invalid disabledDependencies policy %v in manifest %v", m.DisabledDependencies, filePath)
+	}
+
+	for cIdx := range m.Charts {
+		chart := &m.Charts[cIdx]
+		if len(chart.Values) > 0 {
+			for idx, path := range chart.Values {
+				chart.Values[idx].URL = resolveLocator(filePath, path.URL)
+			}
+		}
+		if chart.Timeout <= 0 {
+			chart.Timeout = DefaultChartTimeoutSeconds
+		}
+		if chart.DeleteTimeout <= 0 {
+			chart.DeleteTimeout = DefaultChartTimeoutSeconds
+		}
+		switch chart.OnDelete.PVCs {
+		case "":
+			chart.OnDelete.PVCs = RetainVolumes
+		case RetainVolumes, DeleteVolumes:
+		default:
+			return nil, fmt.Errorf("Invalid onDelete pvcs policy %v of chart %v in manifest %v, expecting %v or %v", chart.OnDelete.PVCs, chart.Name, filePath, RetainVolumes, DeleteVolumes)
+		}
+	}
+	for idx := range m.Resources {
+		r := &m.Resources[idx]
+		if r.Kind != ManifestsType && r.Kind != KustomizeType {
+			return nil, fmt.Errorf("Invalid kind %v of resource %v in manifest %v, expecting %v or %v", r.Kind, r.Name, filePath, ManifestsType, KustomizeType)
+		}
+		if isRemoteLocator(r.Path) {
+			return nil, fmt.Errorf("Resource %v in manifest %v must be a local directory", r.Name, filePath)
+		}
+		r.Path = resolveLocator(filePath, r.Path)
+		if r.Timeout <= 0 {
+			r.Timeout = DefaultChartTimeoutSeconds
+		}
+	}
+	for idx := range m.Hooks {
+		h := &m.Hooks[idx]
+		if err := h.validate(); err != nil {
+			return nil, fmt.Errorf("%v in manifest %v", err, filePath)
+		}
+		if h.IsCommand() && !isRemoteLocator(filePath) {
+			h.Dir = resolveLocator(filePath, h.Dir)
+		}
+		if h.Timeout <= 0 {
+			h.Timeout = DefaultChartTimeoutSeconds
+		}
+	}
+	for idx := range m.Targets {
+		t := &m.Targets[idx]
+		if t.KubeConfig != "" && !isRemoteLocator(filePath) {
+			t.KubeConfig = resolveLocator(filePath, t.KubeConfig)
+		}
+	}
+	for idx := range m.Repositories {
+		r := &m.Repositories[idx]
+		for _, fileRef := range []*string{&r.CredentialsFile, &r.CAFile, &r.CertFile, &r.KeyFile} {
+			if *fileRef != "" {
+				*fileRef = resolveLocator(filePath, *fileRef)
+			}
+		}
+	}
+	if err := m.mergeIncludes(filePath, environment, includeStack); err != nil {
+		return nil, err
+	}
+	if err := m.checkTargets(); err != nil {
+		return nil, fmt.Errorf("%v in manifest %v", err, filePath)
+	}
+	m.resolveNamespaces()
+	return m, nil
+}
+
+// GetEnvironment returns the environment descriptor by the given name, or nil if not found
+func (m *ManifestDescriptor) GetEnvironment(name string) *EnvironmentDescriptor {
+	for idx := range m.Environments {
+		if m.Environments[idx].Name == name {
+			return &m.Environments[idx]
+		}
+	}
+	return nil
+}
+
+// applyEnvironment overrides the chart fields with the ones set in the environment
+func (m *ManifestDescriptor) applyEnvironment(env *EnvironmentDescriptor) {
+	for _, override := range env.Charts {
+		found := false
+		for idx := range m.Charts {
+			chart := &m.Charts[idx]
+			if chart.Name != override.Name {
+				continue
+			}
+			found = true
+			if override.Namespace != "" {
+				chart.Namespace = override.Namespace
+			}
+			if override.Version != "" {
+				chart.Version = override.Version
+			}
+			if override.Timeout > 0 {
+				chart.Timeout = override.Timeout
+			}
+			if override.Enabled != nil {
+				enabled := *override.Enabled
+				chart.Enabled = &enabled
+			}
+			if len(override.Values) > 0 {
+				chart.Values = append([]ValuesDescriptor{}, override.Values...)
+			}
+		}
+		if !found {
+			logrus.Warningf("Environment %v overrides unknown chart %v", env.Name, override.Name)
+		}
+	}
+}
+
+// parseManifestDescriptor templates the manifest file with the given values and parses the result
+func parseManifestDescriptor(filePath string, values map[string]string) (*ManifestDescriptor, error) {
 	m := new(ManifestDescriptor)
 
+	content, err := ReadLocator(filePath)
+	if err != nil {
+		return nil, err
+	}
 	logrus.Infof("Templating manifest file with args: [%v]", values)
-	tpl, err := template.ParseFiles(filePath)
+	tpl, err := template.New(path.Base(filePath)).Parse(string(content))
 	if err != nil {
 		log.Fatalln(err)
 	}
@@ -74,16 +417,5 @@ func LoadManifestDescriptor(filePath string, values map[string]string) (*Manifes
 		log.Fatalf("Unmarshal: %v", err)
 		return nil, err
 	}
-	dirname := filepath.Dir(filePath)
-	for _, chart := range m.Charts {
-		if len(chart.Values) > 0 {
-			for idx, path := range chart.Values {
-				chart.Values[idx].URL = filepath.Join(dirname, path.URL)
-			}
-		}
-		if chart.Timeout <= 0 {
-			chart.Timeout = DefaultChartTimeoutSeconds
-		}
-	}
 	return m, nil
 }
@@ -0,0 +1,82 @@
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const deletePolicyManifest = `metadata:
+  name: delete-policy-manifest
+  kind: manifest
+charts:
+  - name: "mysql"
+    chartLocator: "bitnami/mysql"
+    namespace: "db-paas"
+    releaseName: "mysql"
+    onDelete:
+      pvcs: "{{.Policy}}"
+  - name: "redis"
+    chartLocator: "bitnami/redis"
+    namespace: "paas"
+    releaseName: "redis"
+    deleteTimeout: 60
+`
+
+func Test_DeletePolicy(t *testing.T) {
+	tmpDir, err := ioutil.TempDir(os.TempDir(), "delete-policy-*")
+	if err != nil {
+		panic(err.Error())
+	}
+	defer os.RemoveAll(tmpDir)
+	manifestPath := filepath.Join(tmpDir, "install-manifest.yaml")
+	if err := ioutil.WriteFile(manifestPath, []byte(deletePolicyManifest), 0644); err != nil {
+		panic(err.Error())
+	}
+
+	t.Run("delete-policy-defaults", func(t *testing.T) {
+		m, err := LoadManifestDescriptor(manifestPath, map[string]string{"Policy": "delete"}, "")
+		if err != nil {
+			t.Fatalf("Error loading manifest [%v]", err)
+		}
+		mysql, redis := m.Charts[0], m.Charts[1]
+		if mysql.OnDelete.PVCs != DeleteVolumes || mysql.DeleteTimeout != DefaultChartTimeoutSeconds {
+			t.Errorf("Unexpected delete settings of chart mysql %v %v", mysql.OnDelete, mysql.DeleteTimeout)
+		}
+		if redis.OnDelete.PVCs != RetainVolumes || redis.DeleteTimeout != 60 {
+			t.Errorf("Unexpected delete settings of chart redis %v %v", redis.OnDelete, redis.DeleteTimeout)
+		}
+	})
+	t.Run("delete-policy-invalid", func(t *testing.T) {
+		_, err := LoadManifestDescriptor(manifestPath, map[string]string{"Policy": "archive"}, "")
+		if err == nil || !strings.Contains(err.Error(), "Invalid onDelete pvcs policy archive") {
+			t.Errorf("Expecting an invalid policy error, got [%v]", err)
+		}
+	})
+}
+
+func Test_UndeclaredRepositories(t *testing.T) {
+	t.Run("undeclared-repositories", func(t *testing.T) {
+		m := &ManifestDescriptor{
+			Charts: []ChartDescriptor{
+				{Name: "redis", ChartLocator: "bitnami/redis"},
+				{Name: "mysql", ChartLocator: "stable/mysql"},
+				{Name: "nginx", ChartLocator: "registry/nginx"},
+				{Name: "keycloak", ChartLocator: "stable/keycloak"},
+				{Name: "local", ChartLocator: "./charts/local"},
+				{Name: "archive", ChartLocator: "charts/sample-0.1.0.tgz"},
+				{Name: "oci", ChartLocator: "oci://registry.example.com/charts/sample"},
+				{Name: "file", ChartLocator: "file:///charts/sample"},
+			},
+			Repositories: []RepositoryDescriptor{
+				{Name: "bitnami", URL: "https://charts.bitnami.com/bitnami"},
+				{Name: "registry", URL: "oci://registry.example.com"},
+			},
+		}
+		if undeclared := m.UndeclaredRepositories(); strings.Join(undeclared, ",") != "registry,stable" {
+			t.Errorf("Expecting the registry and stable repositories undeclared, got %v", undeclared)
+		}
+	})
+}
@@ -0,0 +1,176 @@
+package core
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// IncludeSeparator separates the include name from the item name of an included manifest item
+	IncludeSeparator = "/"
+)
+
+// IncludeDescriptor describes a manifest included into another one
+type IncludeDescriptor struct {
+	// Name is the name of the included manifest, used to namespace its items (eg infra/redis)
+	Name string `json:"name"`
+	// URL is the locator of the manifest: a path relative to the including manifest or a http(s) URL
+	URL string `json:"url"`
+	// Values are the template values of the included manifest, on top of the ones of the including manifest
+	Values map[string]string `json:"values,omitempty" yaml:"values,omitempty"`
+}
+
+// IncludedManifest lists the items an included manifest contributes to the including one
+type IncludedManifest struct {
+	Name  string
+	Items []InstallableItem
+}
+
+// mergeIncludes loads the included manifests and merges their (namespaced) items into the manifest
+func (m *ManifestDescriptor) mergeIncludes(filePath string, environment string, includeStack []string) error {
+	if len(m.Includes) == 0 {
+		return nil
+	}
+	includeStack = append(includeStack, filePath)
+	for _, include := range m.Includes {
+		if include.Name == "" || include.URL == "" {
+			return fmt.Errorf("Include in manifest %v requires both a name and a url", filePath)
+		}
+		locator := resolveLocator(filePath, include.URL)
+		for _, parent := range includeStack {
+			if parent == locator {
+				return fmt.Errorf("Include cycle detected: %v -> %v", strings.Join(includeStack, " -> "), locator)
+			}
+		}
+		values := map[string]string{}
+		for k, v := range m.TemplateValues {
+			values[k] = v
+		}
+		for k, v := range include.Values {
+			values[k] = v
+		}
+		logrus.Infof("Including manifest %v as %v", locator, include.Name)
+		sub, err := loadManifestDescriptor(locator, values, environment, false, includeStack)
+		if err != nil {
+			return fmt.Errorf("Error including manifest %v: %v", locator, err)
+		}
+		if err := m.mergeRepositories(sub.Repositories); err != nil {
+			return fmt.Errorf("Error including manifest %v: %v", locator, err)
+		}
+		if err := m.mergeTargets(sub.Targets); err != nil {
+			return fmt.Errorf("Error including manifest %v: %v", locator, err)
+		}
+		if err := m.mergeNamespaces(sub.Namespaces); err != nil {
+			return fmt.Errorf("Error including manifest %v: %v", locator, err)
+		}
+		m.merge(include.Name, sub)
+	}
+	return nil
+}
+
+// mergeRepositories adds the repositories of an included manifest.  Repositories are not namespaced since
+// chart locators refer to them by name, so the same name must have the same URL across manifests.
+func (m *ManifestDescriptor) mergeRepositories(repositories []RepositoryDescriptor) error {
+	for _, r := range repositories {
+		existing := m.GetRepository(r.Name)
+		if existing == nil {
+			m.Repositories = append(m.Repositories, r)
+		} else if existing.URL != r.URL {
+			return fmt.Errorf("Repository %v is declared with different URLs: %v and %v", r.Name, existing.URL, r.URL)
+		}
+	}
+	return nil
+}
+
+// merge adds the items of an included manifest namespaced by the given include name.  The items keep the template
+// values and policies of the included manifest.
+func (m *ManifestDescriptor) merge(name string, sub *ManifestDescriptor) {
+	prefix := name + IncludeSeparator
+	if m.Scopes == nil {
+		m.Scopes = map[string]ItemScope{}
+	}
+	scope := func(itemName string) {
+		m.Scopes[prefix+itemName] = sub.ScopeOf(itemName)
+	}
+	items := make([]InstallableItem, 0)
+	for _, c := range sub.Charts {
+		scope(c.Name)
+		c.Name = prefix + c.Name
+		m.Charts = append(m.Charts, c)
+		items = append(items, InstallableItem{Name: c.Name, Kind: ChartType})
+	}
+	for _, p := range sub.Packages {
+		scope(p.Name)
+		p.Name = prefix + p.Name
+		p.Charts = prefixItems(prefix, p.Charts)
+		m.Packages = append(m.Packages, p)
+		items = append(items, InstallableItem{Name: p.Name, Kind: PackageType})
+	}
+	for _, r := range sub.Resources {
+		scope(r.Name)
+		r.Name = prefix + r.Name
+		m.Resources = append(m.Resources, r)
+		items = append(items, InstallableItem{Name: r.Name, Kind: r.Kind})
+	}
+	for _, h := range sub.Hooks {
+		scope(h.Name)
+		h.Name = prefix + h.Name
+		m.Hooks = append(m.Hooks, h)
+		// Hooks with a phase run around the whole (including) manifest rather than in the dependency graph
+		if h.Phase == "" {
+			items = append(items, InstallableItem{Name: h.Name, Kind: JobType})
+		}
+	}
+	for _, d := range sub.DependencyItems {
+		scope(d.Name)
+		d.Name = prefix + d.Name
+		d.Requires = prefixItems(prefix, d.Requires)
+		m.DependencyItems = append(m.DependencyItems, d)
+	}
+	// Nested includes are namespaced as well (eg platform/infra)
+	for _, included := range sub.Included {
+		items = append(items, InstallableItem{Name: prefix + included.Name, Kind: ManifestType})
+		m.Included = append(m.Included, IncludedManifest{
+			Name:  prefix + included.Name,
+			Items: prefixItems(prefix, included.Items),
+		})
+	}
+	m.Included = append(m.Included, IncludedManifest{Name: name, Items: items})
+}
+
+// prefixItems returns a copy of the items with their names prefixed
+func prefixItems(prefix string, items []InstallableItem) []InstallableItem {
+	prefixed := make([]InstallableItem, 0, len(items))
+	for _, item := range items {
+		prefixed = append(prefixed, InstallableItem{Name: prefix + item.Name, Kind: item.Kind})
+	}
+	return prefixed
+}
+
+// isRemoteLocator returns whether the locator is a http(s) URL
+func isRemoteLocator(locator string) bool {
+	return strings.HasPrefix(locator, "http://") || strings.HasPrefix(locator, "https://")
+}
+
+// resolveLocator resolves a locator relative to the manifest it was found in
+func resolveLocator(manifestLocator string, locator string) string {
+	if hasScheme(locator) || filepath.IsAbs(locator) {
+		return locator
+	}
+	if isRemoteLocator(manifestLocator) {
+		base, err := url.Parse(manifestLocator)
+		if err != nil {
+			return locator
+		}
+		ref, err := url.Parse(locator)
+		if err != nil {
+			return locator
+		}
+		return base.ResolveReference(ref).String()
+	}
+	return filepath.Join(filepath.Dir(manifestLocator), locator)
+}
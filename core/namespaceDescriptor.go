@@ -0,0 +1,75 @@
+package core
+
+import "fmt"
+
+// NamespaceDescriptor describes the metadata of a namespace the charts are installed to
+type NamespaceDescriptor struct {
+	Name        string            `json:"name"`
+	Labels      map[string]string `json:"labels,omitempty" yaml:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty" yaml:"annotations,omitempty"`
+}
+
+// GetNamespace returns the namespace descriptor by the given name, or nil if not declared
+func (m *ManifestDescriptor) GetNamespace(name string) *NamespaceDescriptor {
+	for idx := range m.Namespaces {
+		if m.Namespaces[idx].Name == name {
+			return &m.Namespaces[idx]
+		}
+	}
+	return nil
+}
+
+// mergeNamespaces adds the namespaces of an included manifest.  Namespaces are cluster wide, so the labels and
+// annotations declared for the same namespace across manifests are merged and must not conflict.
+func (m *ManifestDescriptor) mergeNamespaces(namespaces []NamespaceDescriptor) error {
+	for _, ns := range namespaces {
+		existing := m.GetNamespace(ns.Name)
+		if existing == nil {
+			m.Namespaces = append(m.Namespaces, ns)
+			continue
+		}
+		labels, err := mergeMetadata(existing.Labels, ns.Labels)
+		if err != nil {
+			return fmt.Errorf("Namespace %v is declared with conflicting labels: %v", ns.Name, err)
+		}
+		annotations, err := mergeMetadata(existing.Annotations, ns.Annotations)
+		if err != nil {
+			return fmt.Errorf("Namespace %v is declared with conflicting annotations: %v", ns.Name, err)
+		}
+		existing.Labels = labels
+		existing.Annotations = annotations
+	}
+	return nil
+}
+
+// resolveNamespaces applies the createNamespace/deleteNamespace defaults of the manifest to the charts not
+// setting them, and attaches the declared metadata of their namespace
+func (m *ManifestDescriptor) resolveNamespaces() {
+	for idx := range m.Charts {
+		chart := &m.Charts[idx]
+		if chart.CreateNamespace == nil {
+			create := m.CreateNamespace
+			chart.CreateNamespace = &create
+		}
+		if chart.DeleteNamespace == nil {
+			remove := m.DeleteNamespace
+			chart.DeleteNamespace = &remove
+		}
+		chart.NamespaceMetadata = m.GetNamespace(chart.Namespace)
+	}
+}
+
+// mergeMetadata returns the union of two label (or annotation) maps, failing on keys with different values
+func mergeMetadata(current map[string]string, added map[string]string) (map[string]string, error) {
+	merged := map[string]string{}
+	for k, v := range current {
+		merged[k] = v
+	}
+	for k, v := range added {
+		if existing, found := merged[k]; found && existing != v {
+			return nil, fmt.Errorf("%v=%v and %v=%v", k, existing, k, v)
+		}
+		merged[k] = v
+	}
+	return merged, nil
+}
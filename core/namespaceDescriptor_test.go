@@ -0,0 +1,107 @@
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const namespacesManifest = `metadata:
+  name: namespaces-manifest
+  kind: manifest
+createNamespace: true
+namespaces:
+  - name: "paas"
+    labels:
+      istio-injection: "enabled"
+charts:
+  - name: "redis"
+    chartLocator: "bitnami/redis"
+    namespace: "paas"
+    releaseName: "redis"
+    deleteNamespace: true
+  - name: "mysql"
+    chartLocator: "bitnami/mysql"
+    namespace: "db-paas"
+    releaseName: "mysql"
+    createNamespace: false
+includes:
+  - name: "infra"
+    url: "{{.Include}}"
+`
+
+const namespacesInclude = `metadata:
+  name: infra
+  kind: manifest
+namespaces:
+  - name: "paas"
+    labels:
+      team: "{{.Team}}"
+    annotations:
+      owner: "platform"
+charts:
+  - name: "traefik"
+    chartLocator: "stable/traefik"
+    namespace: "paas"
+    releaseName: "traefik"
+`
+
+func Test_Namespaces(t *testing.T) {
+	tmpDir, err := ioutil.TempDir(os.TempDir(), "namespaces-*")
+	if err != nil {
+		panic(err.Error())
+	}
+	defer os.RemoveAll(tmpDir)
+	manifestPath := filepath.Join(tmpDir, "install-manifest.yaml")
+	if err := ioutil.WriteFile(manifestPath, []byte(namespacesManifest), 0644); err != nil {
+		panic(err.Error())
+	}
+	includePath := filepath.Join(tmpDir, "infra.yaml")
+	if err := ioutil.WriteFile(includePath, []byte(namespacesInclude), 0644); err != nil {
+		panic(err.Error())
+	}
+
+	t.Run("namespaces-defaults", func(t *testing.T) {
+		m, err := LoadManifestDescriptor(manifestPath, map[string]string{"Include": "infra.yaml", "Team": "platform"}, "")
+		if err != nil {
+			t.Fatalf("Error loading manifest [%v]", err)
+		}
+		expected := map[string][]bool{"redis": {true, true}, "mysql": {false, false}, "infra/traefik": {false, false}}
+		for _, c := range m.Charts {
+			if c.CreatesNamespace() != expected[c.Name][0] || c.DeletesNamespace() != expected[c.Name][1] {
+				t.Errorf("Unexpected namespace settings of chart %v: create=%v delete=%v", c.Name, c.CreatesNamespace(), c.DeletesNamespace())
+			}
+		}
+	})
+	t.Run("namespaces-metadata", func(t *testing.T) {
+		m, err := LoadManifestDescriptor(manifestPath, map[string]string{"Include": "infra.yaml", "Team": "platform"}, "")
+		if err != nil {
+			t.Fatalf("Error loading manifest [%v]", err)
+		}
+		for _, c := range m.Charts {
+			md := c.NamespaceMetadata
+			if c.Namespace == "db-paas" {
+				if md != nil {
+					t.Errorf("Expecting no metadata for namespace db-paas, got %v", md)
+				}
+				continue
+			}
+			if md == nil || md.Labels["istio-injection"] != "enabled" || md.Labels["team"] != "platform" || md.Annotations["owner"] != "platform" {
+				t.Errorf("Unexpected metadata of namespace paas for chart %v: %v", c.Name, md)
+			}
+		}
+	})
+	t.Run("namespaces-conflict", func(t *testing.T) {
+		conflictPath := filepath.Join(tmpDir, "conflict.yaml")
+		conflict := strings.Replace(namespacesInclude, "team:", "istio-injection:", 1)
+		if err := ioutil.WriteFile(conflictPath, []byte(conflict), 0644); err != nil {
+			panic(err.Error())
+		}
+		_, err := LoadManifestDescriptor(manifestPath, map[string]string{"Include": "conflict.yaml", "Team": "disabled"}, "")
+		if err == nil || !strings.Contains(err.Error(), "conflicting labels") {
+			t.Errorf("Expecting a conflicting labels error, got [%v]", err)
+		}
+	})
+}
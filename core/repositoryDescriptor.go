@@ -0,0 +1,116 @@
+package core
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// RepositoryDescriptor describes a helm chart repository, or an OCI registry when the URL is oci://<host>
+type RepositoryDescriptor struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	// UsernameEnv is the environment variable holding the repository user name
+	UsernameEnv string `json:"usernameEnv,omitempty" yaml:"usernameEnv,omitempty"`
+	// PasswordEnv is the environment variable holding the repository password
+	PasswordEnv string `json:"passwordEnv,omitempty" yaml:"passwordEnv,omitempty"`
+	// CredentialsFile is a yaml secret file with 'username' and 'password' keys
+	CredentialsFile string `json:"credentialsFile,omitempty" yaml:"credentialsFile,omitempty"`
+	// CAFile is the CA bundle used to verify the repository server certificate
+	CAFile string `json:"caFile,omitempty" yaml:"caFile,omitempty"`
+	// CertFile and KeyFile identify the client with a certificate
+	CertFile              string `json:"certFile,omitempty" yaml:"certFile,omitempty"`
+	KeyFile               string `json:"keyFile,omitempty" yaml:"keyFile,omitempty"`
+	InsecureSkipTLSVerify bool   `json:"insecureSkipTLSVerify,omitempty" yaml:"insecureSkipTLSVerify,omitempty"`
+	// PlainHTTP accesses an OCI registry over http instead of https
+	PlainHTTP bool `json:"plainHTTP,omitempty" yaml:"plainHTTP,omitempty"`
+	// TokenRealmHosts are the hosts, other than the registry one, trusted with the credentials when the registry
+	// names them as the realm of its bearer token
+	TokenRealmHosts []string `json:"tokenRealmHosts,omitempty" yaml:"tokenRealmHosts,omitempty"`
+}
+
+// IsRegistry returns whether the repository is an OCI registry
+func (r *RepositoryDescriptor) IsRegistry() bool {
+	return strings.HasPrefix(r.URL, "oci://")
+}
+
+// Credentials returns the user name and password of the repository.  The credentials file is read first and
+// the environment variables (if set) take precedence.
+func (r *RepositoryDescriptor) Credentials() (string, string, error) {
+	username, password := "", ""
+	if r.CredentialsFile != "" {
+		credBytes, err := ioutil.ReadFile(r.CredentialsFile)
+		if err != nil {
+			return "", "", fmt.Errorf("Error reading credentials file of repository %v: %v", r.Name, err)
+		}
+		creds := struct {
+			Username string `yaml:"username"`
+			Password string `yaml:"password"`
+		}{}
+		if err := yaml.Unmarshal(credBytes, &creds); err != nil {
+			return "", "", fmt.Errorf("Error parsing credentials file of repository %v: %v", r.Name, err)
+		}
+		username, password = creds.Username, creds.Password
+	}
+	if r.UsernameEnv != "" {
+		if value, found := os.LookupEnv(r.UsernameEnv); found {
+			username = value
+		}
+	}
+	if r.PasswordEnv != "" {
+		if value, found := os.LookupEnv(r.PasswordEnv); found {
+			password = value
+		}
+	}
+	return username, password, nil
+}
+
+// GetRepository returns the repository descriptor by the given name, or nil if not found
+func (m *ManifestDescriptor) GetRepository(name string) *RepositoryDescriptor {
+	for idx := range m.Repositories {
+		if m.Repositories[idx].Name == name {
+			return &m.Repositories[idx]
+		}
+	}
+	return nil
+}
+
+// UndeclaredRepositories returns the sorted names of the repositories the charts of the manifest are pulled from
+// (repo/chart locators) which the manifest does not declare.  Charts given by URL, OCI reference or local path
+// are not pulled from a repository.
+func (m *ManifestDescriptor) UndeclaredRepositories() []string {
+	names := make([]string, 0)
+	for _, c := range m.Charts {
+		locator := c.ChartLocator
+		if hasScheme(locator) || filepath.IsAbs(locator) || strings.HasPrefix(locator, ".") || strings.HasSuffix(locator, ".tgz") {
+			continue
+		}
+		parts := strings.SplitN(locator, "/", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		if _, err := os.Stat(locator); err == nil {
+			continue
+		}
+		if r := m.GetRepository(parts[0]); (r == nil || r.IsRegistry()) && !containsName(names, parts[0]) {
+			names = append(names, parts[0])
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// containsName returns whether the names hold the given name
+func containsName(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,27 @@
+package core
+
+// ResourceDescriptor describes a set of plain kubernetes objects installed without helm: a directory of yaml
+// manifests (kind manifests) or a kustomize overlay (kind kustomize)
+type ResourceDescriptor struct {
+	Name string `json:"name"`
+	// Kind is the kind of resource, manifests or kustomize
+	Kind string `json:"kind"`
+	// Namespace is the namespace of the namespaced objects which do not set one
+	Namespace string `json:"namespace"`
+	// Path is the directory of the yaml manifests, or of the kustomization, relative to the manifest
+	Path string `json:"path"`
+	// Timeout is the value in seconds to wait for the objects to come up before giving up
+	Timeout int `json:"timeout,omitempty"`
+	// Enabled indicates whether the resource takes part in install/uninstall (defaults to true)
+	Enabled *bool `json:"enabled,omitempty" yaml:"enabled,omitempty"`
+	// Condition is a template value name (or boolean) which must be true for the resource to be enabled
+	Condition string `json:"condition,omitempty" yaml:"condition,omitempty"`
+	// Adopt indicates whether existing objects not managed by latimer are taken over (refused by default)
+	Adopt bool `json:"adopt,omitempty" yaml:"adopt,omitempty"`
+}
+
+// IsEnabled returns whether the resource takes part in install/uninstall given the template values.  If not
+// enabled, the reason is returned as well.
+func (r *ResourceDescriptor) IsEnabled(values map[string]string) (bool, string) {
+	return isEnabled(r.Enabled, r.Condition, values)
+}
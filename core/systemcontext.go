@@ -1,6 +1,8 @@
 package core
 
 import (
+	"fmt"
+	"latimer/kube"
 	"net/url"
 )
 
@@ -17,4 +19,46 @@ type SystemContext struct {
 
 	// Context is the global context info
 	Context *LatimerContext
+
+	// Target is the name of the target cluster of the system (empty for the --kubeconfig cluster)
+	Target string `json:"target,omitempty"`
+
+	// KubeClient is the kubernetes client of the target cluster (the client of the global context if nil)
+	KubeClient *kube.K8sClient `json:"-"`
+}
+
+// ForTarget returns a copy of the system context addressing the named target cluster.  The system context is
+// returned as is if the name is empty.
+func (sc *SystemContext) ForTarget(name string) (*SystemContext, error) {
+	if name == "" || name == sc.Target {
+		return sc, nil
+	}
+	if sc.Context == nil || sc.Context.GetTarget(name) == nil {
+		return nil, fmt.Errorf("Target %v not found", name)
+	}
+	return sc.ForCluster(sc.Context.GetTarget(name))
+}
+
+// ForCluster returns a copy of the system context addressing the given cluster (eg a manifest target or a
+// cluster of a fleet)
+func (sc *SystemContext) ForCluster(target *TargetDescriptor) (*SystemContext, error) {
+	kubeClient, err := sc.Context.targetKubeClient(target)
+	if err != nil {
+		return nil, err
+	}
+	targetSC := *sc
+	targetSC.Target = target.Name
+	targetSC.KubeClient = kubeClient
+	if address, err := url.Parse(kubeClient.Host()); err == nil {
+		targetSC.URL = *address
+	}
+	return &targetSC, nil
+}
+
+// GetKubeClient returns the kubernetes client of the cluster of the system
+func (sc *SystemContext) GetKubeClient() *kube.K8sClient {
+	if sc.KubeClient != nil || sc.Context == nil {
+		return sc.KubeClient
+	}
+	return sc.Context.KubeClient
 }
@@ -0,0 +1,46 @@
+package core
+
+import "fmt"
+
+// TargetDescriptor describes a cluster the items of a manifest can be installed to
+type TargetDescriptor struct {
+	Name string `json:"name"`
+	// KubeConfig is the path of the kube config file of the cluster (the --kubeconfig file if empty)
+	KubeConfig string `json:"kubeconfig,omitempty" yaml:"kubeconfig,omitempty"`
+	// Context is the context of the kube config file to use (its current context if empty)
+	Context string `json:"context,omitempty" yaml:"context,omitempty"`
+}
+
+// GetTarget returns the target descriptor by the given name, or nil if not found
+func (m *ManifestDescriptor) GetTarget(name string) *TargetDescriptor {
+	for idx := range m.Targets {
+		if m.Targets[idx].Name == name {
+			return &m.Targets[idx]
+		}
+	}
+	return nil
+}
+
+// mergeTargets adds the targets of an included manifest.  Targets are not namespaced since charts refer to them
+// by name, so the same name must designate the same cluster across manifests.
+func (m *ManifestDescriptor) mergeTargets(targets []TargetDescriptor) error {
+	for _, t := range targets {
+		existing := m.GetTarget(t.Name)
+		if existing == nil {
+			m.Targets = append(m.Targets, t)
+		} else if *existing != t {
+			return fmt.Errorf("Target %v is declared with different clusters: %v and %v", t.Name, *existing, t)
+		}
+	}
+	return nil
+}
+
+// checkTargets verifies that the charts refer to declared targets
+func (m *ManifestDescriptor) checkTargets() error {
+	for _, c := range m.Charts {
+		if c.Target != "" && m.GetTarget(c.Target) == nil {
+			return fmt.Errorf("Chart %v refers to undeclared target %v", c.Name, c.Target)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const targetsKubeConfig = `apiVersion: v1
+kind: Config
+clusters:
+  - name: management
+    cluster:
+      server: https://management.example.com:6443
+  - name: workload
+    cluster:
+      server: https://workload.example.com:6443
+users:
+  - name: admin
+    user:
+      token: secret
+contexts:
+  - name: management
+    context:
+      cluster: management
+      user: admin
+  - name: workload
+    context:
+      cluster: workload
+      user: admin
+current-context: management
+`
+
+const targetsManifest = `metadata:
+  name: targets-manifest
+  kind: manifest
+targets:
+  - name: "management"
+    kubeconfig: "kubeconfig"
+    context: "management"
+  - name: "edge"
+    kubeconfig: "kubeconfig"
+    context: "workload"
+charts:
+  - name: "control-plane"
+    chartLocator: "stable/traefik"
+    namespace: "paas"
+    releaseName: "control-plane"
+    target: "management"
+  - name: "agent"
+    chartLocator: "stable/traefik"
+    namespace: "paas"
+    releaseName: "agent"
+    target: "{{.AgentTarget}}"
+`
+
+func Test_Targets(t *testing.T) {
+	tmpDir, err := ioutil.TempDir(os.TempDir(), "targets-*")
+	if err != nil {
+		panic(err.Error())
+	}
+	defer os.RemoveAll(tmpDir)
+	kubeConfigPath := filepath.Join(tmpDir, "kubeconfig")
+	if err := ioutil.WriteFile(kubeConfigPath, []byte(targetsKubeConfig), 0600); err != nil {
+		panic(err.Error())
+	}
+	manifestPath := filepath.Join(tmpDir, "install-manifest.yaml")
+	if err := ioutil.WriteFile(manifestPath, []byte(targetsManifest), 0644); err != nil {
+		panic(err.Error())
+	}
+
+	t.Run("targets-load", func(t *testing.T) {
+		m, err := LoadManifestDescriptor(manifestPath, map[string]string{"AgentTarget": "edge"}, "")
+		if err != nil {
+			t.Fatalf("Error loading manifest [%v]", err)
+		}
+		if edge := m.GetTarget("edge"); edge == nil || edge.KubeConfig != kubeConfigPath || edge.Context != "workload" {
+			t.Errorf("Unexpected target edge %v", edge)
+		}
+		_, err = LoadManifestDescriptor(manifestPath, map[string]string{"AgentTarget": "missing"}, "")
+		if err == nil || !strings.Contains(err.Error(), "undeclared target missing") {
+			t.Errorf("Expecting an undeclared target error, got [%v]", err)
+		}
+	})
+	t.Run("targets-system-context", func(t *testing.T) {
+		m, err := LoadManifestDescriptor(manifestPath, map[string]string{"AgentTarget": "edge"}, "")
+		if err != nil {
+			t.Fatalf("Error loading manifest [%v]", err)
+		}
+		sc := &SystemContext{Name: m.Metadata.Name, Context: &LatimerContext{KubeConfigPath: kubeConfigPath, Targets: m.Targets}}
+		if same, err := sc.ForTarget(""); err != nil || same != sc {
+			t.Errorf("Expecting the system context itself without target [%v]", err)
+		}
+		edgeSC, err := sc.ForTarget("edge")
+		if err != nil {
+			t.Fatalf("Error addressing target edge [%v]", err)
+		}
+		if edgeSC.Target != "edge" || edgeSC.KubeClient.Factory().Context != "workload" || edgeSC.URL.Host != "workload.example.com:6443" {
+			t.Errorf("Unexpected system context of target edge %v", edgeSC)
+		}
+		if sc.Target != "" || sc.KubeClient != nil {
+			t.Errorf("Expecting the system context to be left unchanged %v", sc)
+		}
+		again, _ := sc.ForTarget("edge")
+		if again.GetKubeClient() != edgeSC.GetKubeClient() {
+			t.Errorf("Expecting the kubernetes client of a target to be reused")
+		}
+		if _, err := sc.ForTarget("missing"); err == nil {
+			t.Errorf("Expecting an error addressing an unknown target")
+		}
+	})
+}
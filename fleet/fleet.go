@@ -0,0 +1,158 @@
+package fleet
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"latimer/core"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// Succeeded is the status of a cluster the manifest was installed to
+	Succeeded = "succeeded"
+	// Failed is the status of a cluster the manifest failed to install to
+	Failed = "failed"
+	// Skipped is the status of a cluster left out because the rollout was halted
+	Skipped = "skipped"
+)
+
+// InstallFunc installs the manifest to the cluster of the system context
+type InstallFunc func(sc *core.SystemContext) error
+
+// ClusterResult is the outcome of the rollout to a cluster
+type ClusterResult struct {
+	Cluster string `json:"cluster"`
+	Wave    string `json:"wave"`
+	// Address is the address of the API server of the cluster
+	Address  string        `json:"address,omitempty"`
+	Status   string        `json:"status"`
+	Error    string        `json:"error,omitempty"`
+	Duration time.Duration `json:"duration"`
+}
+
+// MarshalJSON writes the duration of the result as a duration string (eg 1m30s) rather than nanoseconds
+func (r ClusterResult) MarshalJSON() ([]byte, error) {
+	type result ClusterResult
+	return json.Marshal(struct {
+		result
+		Duration string `json:"duration"`
+	}{result(r), r.Duration.String()})
+}
+
+// Report is the consolidated per-cluster report of a fleet rollout
+type Report struct {
+	Fleet    string          `json:"fleet"`
+	Manifest string          `json:"manifest"`
+	Halted   bool            `json:"halted"`
+	Results  []ClusterResult `json:"results"`
+}
+
+// Rollout installs the manifest to the clusters of the fleet, wave after wave.  The clusters of a wave are
+// installed with the fleet concurrency.  Once the failure threshold is hit, the clusters in progress complete but
+// no other cluster is started and the remaining clusters are reported as skipped.
+func Rollout(fleet *core.FleetDescriptor, sc *core.SystemContext, install InstallFunc) *Report {
+	report := &Report{Fleet: fleet.Name, Manifest: sc.Name, Results: make([]ClusterResult, 0, len(fleet.Clusters))}
+	failures := 0
+	for _, wave := range fleet.Plan() {
+		if report.Halted {
+			for _, cluster := range wave.Clusters {
+				report.Results = append(report.Results, ClusterResult{Cluster: cluster.Name, Wave: wave.Name, Status: Skipped})
+			}
+			continue
+		}
+		fmt.Printf("Rolling out %v to wave %v: %v clusters\n", sc.Name, wave.Name, len(wave.Clusters))
+		results := make([]ClusterResult, len(wave.Clusters))
+		var lock sync.Mutex
+		var wg sync.WaitGroup
+		slots := make(chan bool, fleet.Concurrency)
+		for idx := range wave.Clusters {
+			cluster := wave.Clusters[idx]
+			slots <- true
+			lock.Lock()
+			halted := failures >= fleet.FailureThreshold
+			lock.Unlock()
+			if halted {
+				<-slots
+				results[idx] = ClusterResult{Cluster: cluster.Name, Wave: wave.Name, Status: Skipped}
+				continue
+			}
+			wg.Add(1)
+			go func(idx int) {
+				defer wg.Done()
+				defer func() { <-slots }()
+				result := installCluster(sc, &cluster, wave.Name, install)
+				lock.Lock()
+				defer lock.Unlock()
+				if result.Status == Failed {
+					failures++
+				}
+				results[idx] = result
+			}(idx)
+		}
+		wg.Wait()
+		report.Results = append(report.Results, results...)
+		if failures >= fleet.FailureThreshold {
+			logrus.Errorf("Halting rollout of %v after wave %v: %v clusters failed", sc.Name, wave.Name, failures)
+			report.Halted = true
+		}
+	}
+	return report
+}
+
+// installCluster installs the manifest to a cluster and records the outcome
+func installCluster(sc *core.SystemContext, cluster *core.TargetDescriptor, wave string, install InstallFunc) ClusterResult {
+	result := ClusterResult{Cluster: cluster.Name, Wave: wave}
+	start := time.Now()
+	clusterSC, err := sc.ForCluster(cluster)
+	if err == nil {
+		result.Address = clusterSC.URL.String()
+		fmt.Printf("Installing %v to cluster %v (%v)\n", sc.Name, cluster.Name, result.Address)
+		err = install(clusterSC)
+	}
+	result.Duration = time.Since(start).Round(time.Second)
+	if err != nil {
+		logrus.Errorf("Rollout to cluster %v failed [%v]", cluster.Name, err)
+		result.Status = Failed
+		result.Error = err.Error()
+	} else {
+		result.Status = Succeeded
+	}
+	return result
+}
+
+// Count returns the number of clusters with the given status
+func (r *Report) Count(status string) int {
+	count := 0
+	for _, result := range r.Results {
+		if result.Status == status {
+			count++
+		}
+	}
+	return count
+}
+
+// Print writes the per-cluster report to the standard output
+func (r *Report) Print() {
+	fmt.Printf("Rollout of %v to fleet %v: %v succeeded, %v failed, %v skipped\n", r.Manifest, r.Fleet,
+		r.Count(Succeeded), r.Count(Failed), r.Count(Skipped))
+	fmt.Printf("  %-12v %-24v %-10v %-9v %v\n", "WAVE", "CLUSTER", "STATUS", "DURATION", "ERROR")
+	for _, result := range r.Results {
+		fmt.Printf("  %-12v %-24v %-10v %-9v %v\n", result.Wave, result.Cluster, result.Status, result.Duration, result.Error)
+	}
+	if r.Halted {
+		fmt.Printf("Rollout halted: failure threshold reached\n")
+	}
+}
+
+// Save writes the report as json to the given path
+func (r *Report) Save(filePath string) error {
+	reportBytes, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filePath, reportBytes, 0644)
+}
@@ -0,0 +1,133 @@
+package fleet
+
+import (
+	"fmt"
+	"io/ioutil"
+	"latimer/core"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const fleetKubeConfig = `apiVersion: v1
+kind: Config
+clusters:
+  - name: edge
+    cluster:
+      server: https://edge.example.com:6443
+users:
+  - name: admin
+    user:
+      token: secret
+contexts:
+  - name: edge
+    context:
+      cluster: edge
+      user: admin
+current-context: edge
+`
+
+func newFleet(kubeConfigPath string, size int) *core.FleetDescriptor {
+	fleet := &core.FleetDescriptor{
+		Name:             "edge-fleet",
+		Waves:            []core.WaveDescriptor{{Name: "canary", Count: 1}, {Percent: 50}},
+		Concurrency:      2,
+		FailureThreshold: 1,
+	}
+	for idx := 1; idx <= size; idx++ {
+		fleet.Clusters = append(fleet.Clusters, core.TargetDescriptor{Name: fmt.Sprintf("edge-%02d", idx), KubeConfig: kubeConfigPath})
+	}
+	return fleet
+}
+
+func Test_Rollout(t *testing.T) {
+	tmpDir, err := ioutil.TempDir(os.TempDir(), "fleet-*")
+	if err != nil {
+		panic(err.Error())
+	}
+	defer os.RemoveAll(tmpDir)
+	kubeConfigPath := filepath.Join(tmpDir, "kubeconfig")
+	if err := ioutil.WriteFile(kubeConfigPath, []byte(fleetKubeConfig), 0600); err != nil {
+		panic(err.Error())
+	}
+	sc := &core.SystemContext{Name: "install-manifest", Context: &core.LatimerContext{KubeConfigPath: kubeConfigPath}}
+
+	t.Run("rollout-all", func(t *testing.T) {
+		var lock sync.Mutex
+		running, maxRunning := 0, 0
+		report := Rollout(newFleet(kubeConfigPath, 6), sc, func(clusterSC *core.SystemContext) error {
+			lock.Lock()
+			running++
+			if running > maxRunning {
+				maxRunning = running
+			}
+			lock.Unlock()
+			time.Sleep(20 * time.Millisecond)
+			lock.Lock()
+			running--
+			lock.Unlock()
+			return nil
+		})
+		if report.Halted || report.Count(Succeeded) != 6 {
+			t.Errorf("Expecting all clusters to succeed, got %v", report)
+		}
+		if maxRunning != 2 {
+			t.Errorf("Expecting 2 clusters installed at the same time, got %v", maxRunning)
+		}
+		if report.Results[0].Wave != "canary" || report.Results[1].Wave != "wave-2" || report.Results[5].Wave != "wave-3" {
+			t.Errorf("Unexpected waves %v", report.Results)
+		}
+		if report.Results[0].Address != "https://edge.example.com:6443" {
+			t.Errorf("Unexpected cluster address %v", report.Results[0].Address)
+		}
+	})
+	t.Run("rollout-halted", func(t *testing.T) {
+		fleet := newFleet(kubeConfigPath, 6)
+		fleet.Concurrency = 1
+		report := Rollout(fleet, sc, func(clusterSC *core.SystemContext) error {
+			if clusterSC.Target == "edge-03" {
+				return fmt.Errorf("install failed")
+			}
+			return nil
+		})
+		if !report.Halted {
+			t.Errorf("Expecting the rollout to be halted")
+		}
+		expected := []string{Succeeded, Succeeded, Failed, Skipped, Skipped, Skipped}
+		for idx, result := range report.Results {
+			if result.Status != expected[idx] {
+				t.Errorf("Expecting cluster %v to be %v, got %v", result.Cluster, expected[idx], result.Status)
+			}
+		}
+		if report.Results[2].Error != "install failed" {
+			t.Errorf("Expecting the error of the failed cluster, got %v", report.Results[2].Error)
+		}
+	})
+	t.Run("rollout-threshold", func(t *testing.T) {
+		fleet := newFleet(kubeConfigPath, 6)
+		fleet.FailureThreshold = 3
+		report := Rollout(fleet, sc, func(clusterSC *core.SystemContext) error {
+			if clusterSC.Target == "edge-02" {
+				return fmt.Errorf("install failed")
+			}
+			return nil
+		})
+		if report.Halted || report.Count(Failed) != 1 || report.Count(Succeeded) != 5 {
+			t.Errorf("Expecting the rollout to go on below the failure threshold, got %v", report)
+		}
+	})
+	t.Run("rollout-report", func(t *testing.T) {
+		report := Rollout(newFleet(kubeConfigPath, 2), sc, func(clusterSC *core.SystemContext) error { return nil })
+		reportPath := filepath.Join(tmpDir, "report.json")
+		if err := report.Save(reportPath); err != nil {
+			t.Fatalf("Error saving report [%v]", err)
+		}
+		reportBytes, err := ioutil.ReadFile(reportPath)
+		if err != nil || !strings.Contains(string(reportBytes), `"duration": "0s"`) {
+			t.Errorf("Expecting the report file with durations as strings: %v [%v]", string(reportBytes), err)
+		}
+	})
+}
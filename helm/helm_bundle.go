@@ -0,0 +1,483 @@
+package helm
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"latimer/core"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/releaseutil"
+)
+
+const (
+	// BundleChartsDir is the directory of the chart archives in a bundle
+	BundleChartsDir = "charts"
+	// BundleValuesDir is the directory of the resolved values files in a bundle
+	BundleValuesDir = "values"
+)
+
+// Bundle packages the chart archive and its resolved values in the bundle directory, collecting the container
+// images referenced by the rendered templates.  The values of charts with encrypted values files are encrypted
+// with the values key.
+func (hc *Chart) Bundle(sc *core.SystemContext, bundle *core.BundleIndex) (*core.BundledChart, error) {
+	valuesMap, err := hc.valuesFor(sc)
+	if err != nil {
+		return nil, err
+	}
+	helmClient, err := hc.helmClientFor(sc)
+	if err != nil {
+		return nil, err
+	}
+	bundled := &core.BundledChart{
+		Name:         hc.Name,
+		ChartLocator: hc.ChartRef,
+		Values:       path.Join(BundleValuesDir, hc.Name+".yaml"),
+	}
+	chartsDir := bundle.Path(BundleChartsDir)
+	if err := os.MkdirAll(chartsDir, 0755); err != nil {
+		return nil, err
+	}
+	archivePath, ch, err := helmClient.Archive(hc.ChartRef, chartsDir)
+	if err != nil {
+		return nil, err
+	}
+	bundled.Version = ch.Metadata.Version
+	bundled.Archive = path.Join(BundleChartsDir, fmt.Sprintf("%v-%v.tgz", hc.Name, bundled.Version))
+	if err := os.Rename(archivePath, bundle.Path(bundled.Archive)); err != nil {
+		return nil, err
+	}
+	if helmClient.Verify {
+		// Keep the provenance file so the chart can be verified again at install time
+		if err := os.Rename(archivePath+".prov", bundle.Path(bundled.Archive)+".prov"); err != nil {
+			return nil, err
+		}
+	}
+	bundled.Digest, err = fileDigest(bundle.Path(bundled.Archive))
+	if err != nil {
+		return nil, err
+	}
+
+	manifests, err := helmClient.render(ch, hc.Descriptor.ReleaseName, hc.Descriptor.Namespace, valuesMap)
+	if err != nil {
+		return nil, fmt.Errorf("Error rendering chart %v: %v", hc.Name, err)
+	}
+	bundled.Images, err = collectImages(manifests)
+	if err != nil {
+		return nil, fmt.Errorf("Error collecting images of chart %v: %v", hc.Name, err)
+	}
+
+	valuesBytes, err := yaml.Marshal(valuesMap)
+	if err != nil {
+		return nil, err
+	}
+	if hc.Encrypted {
+		// Never write decrypted secrets to the bundle
+		key, err := ValuesKey()
+		if err != nil {
+			return nil, fmt.Errorf("Cannot bundle the encrypted values of chart %v: %v", hc.Name, err)
+		}
+		valuesBytes, err = EncryptValues(valuesBytes, key)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := os.MkdirAll(bundle.Path(BundleValuesDir), 0755); err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(bundle.Path(bundled.Values), valuesBytes, 0644); err != nil {
+		return nil, err
+	}
+	return bundled, nil
+}
+
+// Archive copies the archive of the chart to the given directory, packaging the chart first if it is a directory.
+// The provenance file is copied along if verified.  Returns the path of the archive and the loaded chart.
+func (hc *HelmClient) Archive(chartRef string, destDir string) (string, *chart.Chart, error) {
+	chartPath, cleanup, err := hc.chartPath(chartRef)
+	if err != nil {
+		return "", nil, err
+	}
+	defer cleanup()
+	ch, err := hc.loadChartPath(chartRef, chartPath)
+	if err != nil {
+		return "", nil, err
+	}
+	info, err := os.Stat(chartPath)
+	if err != nil {
+		return "", nil, err
+	}
+	if info.IsDir() {
+		archivePath, err := chartutil.Save(ch, destDir)
+		return archivePath, ch, err
+	}
+	archivePath := filepath.Join(destDir, filepath.Base(chartPath))
+	if hc.Verify {
+		if err := copyFile(chartPath+".prov", archivePath+".prov"); err != nil {
+			return "", nil, err
+		}
+	}
+	return archivePath, ch, copyFile(chartPath, archivePath)
+}
+
+// render renders the chart templates without contacting the API server, returning the manifests of the release
+// followed by those of its hooks
+func (hc *HelmClient) render(ch *chart.Chart, releaseName string, namespace string, valuesMap map[string]interface{}) (string, error) {
+	actionConfig := &action.Configuration{Log: logrus.Debugf}
+	iCli := action.NewInstall(actionConfig)
+	iCli.Namespace = namespace
+	iCli.ReleaseName = releaseName
+	iCli.DryRun = true
+	iCli.ClientOnly = true
+	iCli.Replace = true
+	iCli.IncludeCRDs = true
+
+	rel, err := iCli.Run(ch, valuesMap)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	b.WriteString(rel.Manifest)
+	for _, hook := range rel.Hooks {
+		fmt.Fprintf(&b, "---\n# Source: %s\n%s\n", hook.Path, hook.Manifest)
+	}
+	return b.String(), nil
+}
+
+// PackBundle records the checksums of the files of the bundle directory in its index and writes the bundle
+// tarball
+func PackBundle(bundle *core.BundleIndex, bundlePath string) error {
+	bundle.Checksums = map[string]string{}
+	images := map[string]bool{}
+	for _, c := range bundle.Charts {
+		for _, image := range c.Images {
+			images[image] = true
+		}
+	}
+	bundle.Images = sortedKeys(images)
+	err := filepath.Walk(bundle.Dir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		relPath, err := filepath.Rel(bundle.Dir, filePath)
+		if err != nil || relPath == core.BundleIndexName {
+			return err
+		}
+		bundle.Checksums[filepath.ToSlash(relPath)], err = fileDigest(filePath)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := bundle.Save(); err != nil {
+		return err
+	}
+
+	out, err := os.Create(bundlePath)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	gz := gzip.NewWriter(out)
+	tw := tar.NewWriter(gz)
+	relPaths := make([]string, 0, len(bundle.Checksums))
+	for relPath := range bundle.Checksums {
+		relPaths = append(relPaths, relPath)
+	}
+	sort.Strings(relPaths)
+	for _, relPath := range append([]string{core.BundleIndexName}, relPaths...) {
+		if err := addTarFile(tw, bundle.Path(relPath), relPath); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// OpenBundle extracts the bundle tarball to the given directory and verifies the checksums of its files
+func OpenBundle(bundlePath string, destDir string) (*core.BundleIndex, error) {
+	in, err := os.Open(bundlePath)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+	gz, err := gzip.NewReader(in)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading bundle %v: %v", bundlePath, err)
+	}
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("Error reading bundle %v: %v", bundlePath, err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		relPath := path.Clean(header.Name)
+		if path.IsAbs(relPath) || relPath == ".." || strings.HasPrefix(relPath, "../") {
+			return nil, fmt.Errorf("Invalid file %v in bundle %v", header.Name, bundlePath)
+		}
+		filePath := filepath.Join(destDir, filepath.FromSlash(relPath))
+		if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+			return nil, err
+		}
+		if err := extractTarFile(tr, filePath); err != nil {
+			return nil, err
+		}
+	}
+
+	bundle, err := core.LoadBundleIndex(destDir)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading index of bundle %v: %v", bundlePath, err)
+	}
+	for _, c := range bundle.Charts {
+		for _, relPath := range []string{c.Archive, c.Values} {
+			if _, found := bundle.Checksums[relPath]; !found {
+				return nil, fmt.Errorf("No checksum for %v of chart %v in bundle %v", relPath, c.Name, bundlePath)
+			}
+		}
+	}
+	for relPath, checksum := range bundle.Checksums {
+		digest, err := fileDigest(bundle.Path(relPath))
+		if err != nil {
+			return nil, fmt.Errorf("Bundle %v is incomplete: %v", bundlePath, err)
+		}
+		if digest != checksum {
+			return nil, fmt.Errorf("Checksum mismatch for %v in bundle %v: expected %v, got %v", relPath, bundlePath, checksum, digest)
+		}
+	}
+	logrus.Infof("Opened bundle %v with %v charts and %v images", bundlePath, len(bundle.Charts), len(bundle.Images))
+	return bundle, nil
+}
+
+// addTarFile writes a file to the tarball under the given name
+func addTarFile(tw *tar.Writer, filePath string, name string) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// extractTarFile writes the current file of the tarball to the given path
+func extractTarFile(tr *tar.Reader, filePath string) error {
+	f, err := os.OpenFile(filePath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := io.Copy(f, tr); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// imageMirror is a helm post renderer rewriting the container images of the rendered manifests to a mirror
+// registry.  Helm does not post render the manifests of hooks, see checkHooks.
+type imageMirror struct {
+	mirror string
+}
+
+// checkHooks verifies that the hooks of the release rendered by the dry run only use images of the mirror.  Helm
+// installs the hooks as rendered, so their images must be pointed to the mirror through the chart values.
+func (im *imageMirror) checkHooks(chartName string, dryRun func() (*release.Release, error)) error {
+	rel, err := dryRun()
+	if err != nil {
+		return err
+	}
+	outside := map[string]bool{}
+	for _, hook := range rel.Hooks {
+		images, err := collectImages(hook.Manifest)
+		if err != nil {
+			return err
+		}
+		for _, image := range images {
+			if MirrorImage(image, im.mirror) != image {
+				outside[image] = true
+			}
+		}
+	}
+	if len(outside) > 0 {
+		return fmt.Errorf("Hooks of chart %v use images outside of mirror %v %v, helm does not rewrite the images of hooks: set them to the mirror through the chart values",
+			chartName, im.mirror, sortedKeys(outside))
+	}
+	return nil
+}
+
+// Run rewrites the images of the rendered manifests
+func (im *imageMirror) Run(renderedManifests *bytes.Buffer) (*bytes.Buffer, error) {
+	docs, err := splitManifests(renderedManifests.String())
+	if err != nil {
+		return nil, err
+	}
+	out := new(bytes.Buffer)
+	for _, doc := range docs {
+		rewritten := walkImages(doc.content, func(image string) string {
+			return MirrorImage(image, im.mirror)
+		})
+		out.WriteString("---\n")
+		out.WriteString(doc.comments)
+		if rewritten == 0 {
+			out.WriteString(doc.text)
+			out.WriteString("\n")
+			continue
+		}
+		docBytes, err := yaml.Marshal(doc.content)
+		if err != nil {
+			return nil, err
+		}
+		out.Write(docBytes)
+	}
+	return out, nil
+}
+
+// dockerHubRegistries are the names of the docker hub registry, the default registry of images without one
+var dockerHubRegistries = map[string]bool{"docker.io": true, "index.docker.io": true, "registry-1.docker.io": true}
+
+// MirrorImage returns the reference of the image in the mirror registry: the registry host of the image, if
+// any, is replaced by the mirror and docker hub official images get their implicit library/ prefix, so that all
+// the references of a docker hub image map to the same mirror path.
+func MirrorImage(image string, mirror string) string {
+	mirror = strings.TrimSuffix(mirror, "/")
+	if strings.HasPrefix(image, mirror+"/") {
+		return image
+	}
+	registry, imagePath := "", image
+	parts := strings.SplitN(image, "/", 2)
+	if len(parts) == 2 && (strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") {
+		registry, imagePath = parts[0], parts[1]
+	}
+	if (registry == "" || dockerHubRegistries[registry]) && !strings.Contains(imagePath, "/") {
+		imagePath = "library/" + imagePath
+	}
+	return mirror + "/" + imagePath
+}
+
+// collectImages returns the sorted container images referenced by the rendered manifests
+func collectImages(manifests string) ([]string, error) {
+	docs, err := splitManifests(manifests)
+	if err != nil {
+		return nil, err
+	}
+	images := map[string]bool{}
+	for _, doc := range docs {
+		walkImages(doc.content, func(image string) string {
+			images[image] = true
+			return image
+		})
+	}
+	return sortedKeys(images), nil
+}
+
+// manifestDoc is a yaml document of rendered manifests
+type manifestDoc struct {
+	// comments are the comment lines heading the document (eg # Source: <template>)
+	comments string
+	text     string
+	content  yaml.MapSlice
+}
+
+// splitManifests splits and parses the documents of the rendered manifests, in order
+func splitManifests(manifests string) ([]manifestDoc, error) {
+	split := releaseutil.SplitManifests(manifests)
+	keys := make([]string, 0, len(split))
+	for key := range split {
+		keys = append(keys, key)
+	}
+	sort.Sort(releaseutil.BySplitManifestsOrder(keys))
+	docs := make([]manifestDoc, 0, len(keys))
+	for _, key := range keys {
+		doc := manifestDoc{text: strings.TrimSpace(split[key])}
+		lines := strings.Split(doc.text, "\n")
+		for len(lines) > 0 && strings.HasPrefix(lines[0], "#") {
+			doc.comments += lines[0] + "\n"
+			lines = lines[1:]
+		}
+		doc.text = strings.Join(lines, "\n")
+		if err := yaml.Unmarshal([]byte(doc.text), &doc.content); err != nil {
+			return nil, err
+		}
+		docs = append(docs, doc)
+	}
+	return docs, nil
+}
+
+// walkImages replaces the string values of the image keys of a parsed manifest with the result of the given
+// function.  Returns the number of values changed.
+func walkImages(node interface{}, rewrite func(string) string) int {
+	changed := 0
+	switch n := node.(type) {
+	case yaml.MapSlice:
+		for idx := range n {
+			if image, ok := n[idx].Value.(string); ok && n[idx].Key == "image" {
+				if rewritten := rewrite(image); rewritten != image {
+					n[idx].Value = rewritten
+					changed++
+				}
+			} else {
+				changed += walkImages(n[idx].Value, rewrite)
+			}
+		}
+	case map[interface{}]interface{}:
+		for key, value := range n {
+			if image, ok := value.(string); ok && key == "image" {
+				if rewritten := rewrite(image); rewritten != image {
+					n[key] = rewritten
+					changed++
+				}
+			} else {
+				changed += walkImages(value, rewrite)
+			}
+		}
+	case []interface{}:
+		for _, item := range n {
+			changed += walkImages(item, rewrite)
+		}
+	}
+	return changed
+}
+
+// sortedKeys returns the sorted keys of a set
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,183 @@
+package helm
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"latimer/core"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/release"
+)
+
+func Test_helm_bundle(t *testing.T) {
+	tmpDir, err := ioutil.TempDir(os.TempDir(), "helm-bundle-*")
+	if err != nil {
+		panic(err.Error())
+	}
+	defer os.RemoveAll(tmpDir)
+	chartDir, err := chartutil.Create("sample", tmpDir)
+	if err != nil {
+		panic(err.Error())
+	}
+	valuesPath := filepath.Join(tmpDir, "values.yaml")
+	if err := ioutil.WriteFile(valuesPath, []byte("replicaCount: {{.replicas}}\n"), 0644); err != nil {
+		panic(err.Error())
+	}
+	descriptor := core.ChartDescriptor{
+		Name:         "sample-chart",
+		ChartName:    "sample",
+		ChartLocator: "file://" + chartDir,
+		Namespace:    "paas",
+		ReleaseName:  "test-sample",
+		Values:       []core.ValuesDescriptor{{URL: valuesPath, Template: true}},
+	}
+	chart := NewChart(&descriptor, map[string]string{"replicas": "3"})
+	bundlePath := filepath.Join(tmpDir, "sample-bundle.tgz")
+
+	t.Run("helm-bundle-create", func(t *testing.T) {
+		sc := &core.SystemContext{Name: "sample", Context: &core.LatimerContext{}}
+		bundle := &core.BundleIndex{Manifest: "sample", Dir: filepath.Join(tmpDir, "create")}
+		bundled, err := chart.Bundle(sc, bundle)
+		if err != nil {
+			t.Fatalf("Error bundling chart %v [%v]", chart.Name, err)
+		}
+		if bundled.Version != "0.1.0" || bundled.Archive != "charts/sample-chart-0.1.0.tgz" {
+			t.Errorf("Unexpected bundled chart %v", bundled)
+		}
+		if len(bundled.Images) != 2 || bundled.Images[0] != "busybox" || bundled.Images[1] != "nginx:1.16.0" {
+			t.Errorf("Unexpected images %v, expected the deployment and test hook images", bundled.Images)
+		}
+		bundle.Charts = append(bundle.Charts, *bundled)
+		if err := PackBundle(bundle, bundlePath); err != nil {
+			t.Fatalf("Error writing bundle %v [%v]", bundlePath, err)
+		}
+		if len(bundle.Checksums) != 2 {
+			t.Errorf("Expected checksums of the archive and values, got %v", bundle.Checksums)
+		}
+	})
+
+	t.Run("helm-bundle-install-source", func(t *testing.T) {
+		bundle, err := OpenBundle(bundlePath, filepath.Join(tmpDir, "open"))
+		if err != nil {
+			t.Fatalf("Error opening bundle %v [%v]", bundlePath, err)
+		}
+		sc := &core.SystemContext{Name: "sample", Context: &core.LatimerContext{Bundle: bundle, ImageMirror: "mirror.local:5000"}}
+		helmClient, err := chart.helmClientFor(sc)
+		if err != nil {
+			t.Fatalf("Error getting bundle helm client [%v]", err)
+		}
+		if helmClient.Digest != bundle.Charts[0].Digest || helmClient.ImageMirror != "mirror.local:5000" {
+			t.Errorf("Bundle helm client not pinned to the bundled chart: %v", helmClient)
+		}
+		chartRef := chart.chartRefFor(sc)
+		if chartRef != "file://"+bundle.Path(bundle.Charts[0].Archive) {
+			t.Errorf("Unexpected bundled chart locator %v", chartRef)
+		}
+		if _, err := helmClient.loadChart(chartRef); err != nil {
+			t.Errorf("Error loading bundled chart %v [%v]", chartRef, err)
+		}
+		valuesMap, err := chart.valuesFor(sc)
+		if err != nil {
+			t.Fatalf("Error loading bundled values [%v]", err)
+		}
+		if valuesMap["replicaCount"] != 3 {
+			t.Errorf("Unexpected bundled values %v", valuesMap)
+		}
+	})
+
+	t.Run("helm-bundle-checksum", func(t *testing.T) {
+		bundle, err := OpenBundle(bundlePath, filepath.Join(tmpDir, "tampered"))
+		if err != nil {
+			t.Fatalf("Error opening bundle %v [%v]", bundlePath, err)
+		}
+		if err := ioutil.WriteFile(bundle.Path(bundle.Charts[0].Values), []byte("replicaCount: 5\n"), 0644); err != nil {
+			panic(err.Error())
+		}
+		// Repack the tampered values with the original index
+		tamperedPath := filepath.Join(tmpDir, "tampered.tgz")
+		out, err := os.Create(tamperedPath)
+		if err != nil {
+			panic(err.Error())
+		}
+		gz := gzip.NewWriter(out)
+		tw := tar.NewWriter(gz)
+		for _, relPath := range []string{core.BundleIndexName, bundle.Charts[0].Archive, bundle.Charts[0].Values} {
+			if err := addTarFile(tw, bundle.Path(relPath), relPath); err != nil {
+				panic(err.Error())
+			}
+		}
+		tw.Close()
+		gz.Close()
+		out.Close()
+		_, err = OpenBundle(tamperedPath, filepath.Join(tmpDir, "tampered-open"))
+		if err == nil || !strings.Contains(err.Error(), "Checksum mismatch") {
+			t.Errorf("Expected a checksum mismatch opening a tampered bundle, got [%v]", err)
+		}
+	})
+
+	t.Run("helm-bundle-mirror", func(t *testing.T) {
+		images := map[string]string{
+			"nginx:1.16.0":                           "mirror.local:5000/library/nginx:1.16.0",
+			"docker.io/nginx:1.16.0":                 "mirror.local:5000/library/nginx:1.16.0",
+			"index.docker.io/nginx:1.16.0":           "mirror.local:5000/library/nginx:1.16.0",
+			"registry-1.docker.io/nginx:1.16.0":      "mirror.local:5000/library/nginx:1.16.0",
+			"docker.io/library/nginx:1.16.0":         "mirror.local:5000/library/nginx:1.16.0",
+			"bitnami/redis:6.0":                      "mirror.local:5000/bitnami/redis:6.0",
+			"docker.io/bitnami/redis:6.0":            "mirror.local:5000/bitnami/redis:6.0",
+			"index.docker.io/bitnami/redis:6.0":      "mirror.local:5000/bitnami/redis:6.0",
+			"localhost/tools/busybox":                "mirror.local:5000/tools/busybox",
+			"quay.io:443/coreos/etcd@sha256":         "mirror.local:5000/coreos/etcd@sha256",
+			"gcr.io/distroless":                      "mirror.local:5000/distroless",
+			"mirror.local:5000/app/api:1":            "mirror.local:5000/app/api:1",
+			"mirror.local:5000/library/nginx:1.16.0": "mirror.local:5000/library/nginx:1.16.0",
+		}
+		for image, expected := range images {
+			if mirrored := MirrorImage(image, "mirror.local:5000/"); mirrored != expected {
+				t.Errorf("Mirror of %v is %v, expected %v", image, mirrored, expected)
+			}
+		}
+		rendered := "---\n# Source: sample/templates/deployment.yaml\napiVersion: apps/v1\nkind: Deployment\nspec:\n  template:\n    spec:\n      containers:\n        - name: sample\n          image: \"nginx:1.16.0\"\n---\n# Source: sample/templates/service.yaml\napiVersion: v1\nkind: Service\n"
+		out, err := (&imageMirror{mirror: "mirror.local:5000"}).Run(bytes.NewBufferString(rendered))
+		if err != nil {
+			t.Fatalf("Error rewriting images [%v]", err)
+		}
+		if !strings.Contains(out.String(), "image: mirror.local:5000/library/nginx:1.16.0") ||
+			!strings.Contains(out.String(), "# Source: sample/templates/deployment.yaml") ||
+			!strings.Contains(out.String(), "kind: Service") {
+			t.Errorf("Unexpected rewritten manifests:\n%v", out.String())
+		}
+	})
+
+}
+
+func Test_helm_bundle_hooks(t *testing.T) {
+	t.Run("helm-bundle-mirror-hooks", func(t *testing.T) {
+		mirror := &imageMirror{mirror: "mirror.local:5000"}
+		rel := &release.Release{
+			Manifest: "---\n# Source: sample/templates/deployment.yaml\napiVersion: apps/v1\nkind: Deployment\nspec:\n  template:\n    spec:\n      containers:\n        - name: app\n          image: nginx:1.19\n",
+		}
+		dryRun := func() (*release.Release, error) {
+			return rel, nil
+		}
+		if err := mirror.checkHooks("sample", dryRun); err != nil {
+			t.Errorf("Expecting the chart without hooks to be accepted [%v]", err)
+		}
+		hookManifest := "apiVersion: batch/v1\nkind: Job\nmetadata:\n  annotations:\n    helm.sh/hook: pre-install\nspec:\n  template:\n    spec:\n      containers:\n        - name: migrate\n          image: %v\n"
+		rel.Hooks = []*release.Hook{{Name: "sample-migrate", Manifest: fmt.Sprintf(hookManifest, "mirror.local:5000/library/migrate:4")}}
+		if err := mirror.checkHooks("sample", dryRun); err != nil {
+			t.Errorf("Expecting the hook image of the mirror to be accepted [%v]", err)
+		}
+		rel.Hooks[0].Manifest = fmt.Sprintf(hookManifest, "docker.io/migrate:4")
+		err := mirror.checkHooks("sample", dryRun)
+		if err == nil || !strings.Contains(err.Error(), "docker.io/migrate:4") || !strings.Contains(err.Error(), "chart values") {
+			t.Errorf("Expecting the hook image outside of the mirror to be refused [%v]", err)
+		}
+	})
+}
@@ -0,0 +1,318 @@
+package helm
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+	"helm.sh/helm/v3/pkg/chart/loader"
+)
+
+const (
+	// chartCacheIndexFile is the name of the index file of the chart cache
+	chartCacheIndexFile = "index.yaml"
+)
+
+// ChartCacheEntry records a chart archive stored in the cache
+type ChartCacheEntry struct {
+	// ChartRef is the locator the chart was pulled from (repository URL/name or oci:// reference)
+	ChartRef string `json:"chartRef" yaml:"chartRef"`
+	Version  string `json:"version"`
+	// Digest is the sha256 digest of the archive, which names the archive file in the cache
+	Digest   string    `json:"digest"`
+	Size     int64     `json:"size"`
+	Created  time.Time `json:"created"`
+	LastUsed time.Time `json:"lastUsed" yaml:"lastUsed"`
+}
+
+// ChartCache is a content-addressed store of chart archives shared between runs.  A single instance is shared by
+// the helm clients of the process for each cache directory.
+type ChartCache struct {
+	// Dir is the directory of the cache
+	Dir string `json:"-" yaml:"-"`
+
+	Entries []ChartCacheEntry `json:"entries"`
+
+	// lock guards the entries, used bumps the pending usage times not written to the index yet
+	lock sync.Mutex
+	used bool
+}
+
+// chartCaches are the chart caches opened by the process, by directory
+var chartCaches = struct {
+	lock   sync.Mutex
+	caches map[string]*ChartCache
+}{caches: map[string]*ChartCache{}}
+
+// NewChartCache opens (or creates) the chart cache in the given directory.  The cache of a directory is opened
+// once per process, later calls return the same instance.
+func NewChartCache(dir string) (*ChartCache, error) {
+	key, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	chartCaches.lock.Lock()
+	defer chartCaches.lock.Unlock()
+	if cache, ok := chartCaches.caches[key]; ok {
+		return cache, nil
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	cache := &ChartCache{Dir: dir, Entries: make([]ChartCacheEntry, 0)}
+	if err := cache.reload(); err != nil {
+		return nil, err
+	}
+	chartCaches.caches[key] = cache
+	return cache, nil
+}
+
+// FlushChartCaches writes the usage times of the charts found in the caches of the process to their index
+func FlushChartCaches() {
+	chartCaches.lock.Lock()
+	defer chartCaches.lock.Unlock()
+	for _, cache := range chartCaches.caches {
+		if err := cache.Flush(); err != nil {
+			logrus.Warningf("Error updating chart cache index in %v [%v]", cache.Dir, err)
+		}
+	}
+}
+
+// Flush writes the pending usage times of the charts to the index, if any
+func (cache *ChartCache) Flush() error {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	if !cache.used {
+		return nil
+	}
+	if err := cache.reload(); err != nil {
+		return err
+	}
+	return cache.save()
+}
+
+// Lookup returns the path of the cached archive of the chart version, or the empty string if not cached.  If a
+// digest is given, only an archive with that digest is returned.
+func (cache *ChartCache) Lookup(chartRef string, version string, digest string) string {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	for idx := range cache.Entries {
+		entry := &cache.Entries[idx]
+		if entry.ChartRef != chartRef || entry.Version != version || (digest != "" && entry.Digest != digest) {
+			continue
+		}
+		archivePath := cache.archivePath(entry.Digest)
+		if _, err := os.Stat(archivePath); err != nil {
+			continue
+		}
+		// Refresh the usage time so prune keeps the charts in use, the index is written on the next update or flush
+		entry.LastUsed = time.Now().UTC()
+		cache.used = true
+		logrus.Infof("Chart %v %v found in cache [%v]", chartRef, version, entry.Digest)
+		return archivePath
+	}
+	return ""
+}
+
+// Store copies a chart archive into the cache and returns the path of the cached archive
+func (cache *ChartCache) Store(chartRef string, archivePath string) (string, error) {
+	digest, err := fileDigest(archivePath)
+	if err != nil {
+		return "", err
+	}
+	c, err := loader.Load(archivePath)
+	if err != nil {
+		return "", err
+	}
+	cachedPath := cache.archivePath(digest)
+	if _, err := os.Stat(cachedPath); os.IsNotExist(err) {
+		if err := copyFile(archivePath, cachedPath); err != nil {
+			return "", err
+		}
+	}
+	// Keep the provenance file of the archive, if any, for signature verification
+	if _, err := os.Stat(archivePath + ".prov"); err == nil {
+		if err := copyFile(archivePath+".prov", cachedPath+".prov"); err != nil {
+			return "", err
+		}
+	}
+	info, err := os.Stat(cachedPath)
+	if err != nil {
+		return "", err
+	}
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	// Another process may have updated the index since it was read
+	if err := cache.reload(); err != nil {
+		return "", err
+	}
+	now := time.Now().UTC()
+	entries := make([]ChartCacheEntry, 0, len(cache.Entries)+1)
+	for _, entry := range cache.Entries {
+		if entry.ChartRef != chartRef || entry.Version != c.Metadata.Version {
+			entries = append(entries, entry)
+		}
+	}
+	cache.Entries = append(entries, ChartCacheEntry{
+		ChartRef: chartRef,
+		Version:  c.Metadata.Version,
+		Digest:   digest,
+		Size:     info.Size(),
+		Created:  now,
+		LastUsed: now,
+	})
+	logrus.Infof("Chart %v %v stored in cache [%v]", chartRef, c.Metadata.Version, digest)
+	return cachedPath, cache.save()
+}
+
+// Prune removes the entries not used since the given time, and the archives no longer referenced.  Returns
+// the entries removed.
+func (cache *ChartCache) Prune(unusedSince time.Time) ([]ChartCacheEntry, error) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	if err := cache.reload(); err != nil {
+		return nil, err
+	}
+	kept := make([]ChartCacheEntry, 0)
+	removed := make([]ChartCacheEntry, 0)
+	for _, entry := range cache.Entries {
+		if entry.LastUsed.Before(unusedSince) {
+			removed = append(removed, entry)
+		} else {
+			kept = append(kept, entry)
+		}
+	}
+	cache.Entries = kept
+	if err := cache.save(); err != nil {
+		return nil, err
+	}
+	referenced := map[string]bool{}
+	for _, entry := range kept {
+		referenced[filepath.Base(cache.archivePath(entry.Digest))] = true
+	}
+	files, err := ioutil.ReadDir(cache.Dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		archiveName := strings.TrimSuffix(file.Name(), ".prov")
+		if strings.HasSuffix(archiveName, ".tgz") && !referenced[archiveName] {
+			if err := os.Remove(filepath.Join(cache.Dir, file.Name())); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return removed, nil
+}
+
+// archivePath returns the path of the archive with the given digest
+func (cache *ChartCache) archivePath(digest string) string {
+	return filepath.Join(cache.Dir, strings.Replace(digest, ":", "-", 1)+".tgz")
+}
+
+// reload merges the index on disk into the entries: entries added by other processes are kept, the latest usage
+// time of each entry wins, and the entries whose archive was pruned meanwhile are dropped
+func (cache *ChartCache) reload() error {
+	indexBytes, err := ioutil.ReadFile(filepath.Join(cache.Dir, chartCacheIndexFile))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	index := &ChartCache{}
+	if err := yaml.Unmarshal(indexBytes, index); err != nil {
+		return fmt.Errorf("Error parsing chart cache index in %v: %v", cache.Dir, err)
+	}
+	for _, stored := range index.Entries {
+		found := false
+		for idx := range cache.Entries {
+			entry := &cache.Entries[idx]
+			if entry.ChartRef == stored.ChartRef && entry.Version == stored.Version && entry.Digest == stored.Digest {
+				if stored.LastUsed.After(entry.LastUsed) {
+					entry.LastUsed = stored.LastUsed
+				}
+				found = true
+				break
+			}
+		}
+		if !found {
+			cache.Entries = append(cache.Entries, stored)
+		}
+	}
+	entries := make([]ChartCacheEntry, 0, len(cache.Entries))
+	for _, entry := range cache.Entries {
+		if _, err := os.Stat(cache.archivePath(entry.Digest)); err == nil {
+			entries = append(entries, entry)
+		}
+	}
+	cache.Entries = entries
+	return nil
+}
+
+// save writes the cache index atomically, with the entries sorted by chart and version
+func (cache *ChartCache) save() error {
+	sort.Slice(cache.Entries, func(i, j int) bool {
+		if cache.Entries[i].ChartRef != cache.Entries[j].ChartRef {
+			return cache.Entries[i].ChartRef < cache.Entries[j].ChartRef
+		}
+		return cache.Entries[i].Version < cache.Entries[j].Version
+	})
+	indexBytes, err := yaml.Marshal(cache)
+	if err != nil {
+		return err
+	}
+	tmpFile, err := ioutil.TempFile(cache.Dir, chartCacheIndexFile+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+	if _, err := tmpFile.Write(indexBytes); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpFile.Name(), filepath.Join(cache.Dir, chartCacheIndexFile)); err != nil {
+		return err
+	}
+	cache.used = false
+	return nil
+}
+
+// exactVersion returns whether the version constraint designates a single version
+func exactVersion(constraint string) bool {
+	_, err := semver.StrictNewVersion(strings.TrimPrefix(constraint, "v"))
+	return err == nil
+}
+
+// copyFile copies a file through a temporary file renamed into place
+func copyFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := ioutil.TempFile(filepath.Dir(dst), filepath.Base(dst)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(out.Name())
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Rename(out.Name(), dst)
+}
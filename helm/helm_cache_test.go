@@ -0,0 +1,158 @@
+package helm
+
+import (
+	"io/ioutil"
+	"latimer/core"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+func Test_helm_cache(t *testing.T) {
+	tmpDir, err := ioutil.TempDir(os.TempDir(), "helm-cache-*")
+	if err != nil {
+		panic(err.Error())
+	}
+	defer os.RemoveAll(tmpDir)
+	chartDir, err := chartutil.Create("sample", tmpDir)
+	if err != nil {
+		panic(err.Error())
+	}
+	sample, err := loader.Load(chartDir)
+	if err != nil {
+		panic(err.Error())
+	}
+	chartPath, err := chartutil.Save(sample, tmpDir)
+	if err != nil {
+		panic(err.Error())
+	}
+	server, requestedTags := newRegistryStandIn(t, chartPath)
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+	chartRef := "oci://" + host + "/charts/sample"
+	cacheDir := filepath.Join(tmpDir, "cache")
+
+	os.Setenv("LATIMER_TEST_REGISTRY_USER", "latimer")
+	os.Setenv("LATIMER_TEST_REGISTRY_PASSWORD", "secret")
+	defer os.Unsetenv("LATIMER_TEST_REGISTRY_USER")
+	defer os.Unsetenv("LATIMER_TEST_REGISTRY_PASSWORD")
+	newClient := func() *HelmClient {
+		cache, err := NewChartCache(cacheDir)
+		if err != nil {
+			t.Fatalf("Error opening chart cache %v [%v]", cacheDir, err)
+		}
+		helmClient := NewHelmClient()
+		helmClient.Version = "0.1.0"
+		helmClient.Cache = cache
+		helmClient.Registries = []core.RepositoryDescriptor{{
+			Name:        "stand-in",
+			URL:         "oci://" + host,
+			UsernameEnv: "LATIMER_TEST_REGISTRY_USER",
+			PasswordEnv: "LATIMER_TEST_REGISTRY_PASSWORD",
+			PlainHTTP:   true,
+		}}
+		return helmClient
+	}
+
+	t.Run("helm-cache-miss-and-hit", func(t *testing.T) {
+		for run := 0; run < 2; run++ {
+			if _, err := newClient().loadChart(chartRef); err != nil {
+				t.Fatalf("Error loading chart [%v]", err)
+			}
+		}
+		if len(*requestedTags) != 1 {
+			t.Errorf("Expecting a single pull from the registry, requested: %v", *requestedTags)
+		}
+		cache, _ := NewChartCache(cacheDir)
+		if len(cache.Entries) != 1 || cache.Entries[0].Version != "0.1.0" || cache.Entries[0].ChartRef != chartRef {
+			t.Errorf("Unexpected cache entries: %v", cache.Entries)
+		}
+	})
+
+	t.Run("helm-cache-lookup", func(t *testing.T) {
+		cache, _ := NewChartCache(cacheDir)
+		if other, _ := NewChartCache(cacheDir + "/"); other != cache {
+			t.Errorf("Expecting a single chart cache instance per directory")
+		}
+		indexPath := filepath.Join(cacheDir, chartCacheIndexFile)
+		before, _ := ioutil.ReadFile(indexPath)
+		if cache.Lookup(chartRef, "0.1.0", "") == "" {
+			t.Fatalf("Expecting chart found in cache")
+		}
+		if after, _ := ioutil.ReadFile(indexPath); string(after) != string(before) {
+			t.Errorf("Expecting index not rewritten on lookup")
+		}
+		if err := cache.Flush(); err != nil {
+			t.Fatalf("Error flushing chart cache [%v]", err)
+		}
+		if after, _ := ioutil.ReadFile(indexPath); string(after) == string(before) {
+			t.Errorf("Expecting usage time written to index on flush")
+		}
+	})
+
+	t.Run("helm-cache-prune", func(t *testing.T) {
+		cache, _ := NewChartCache(cacheDir)
+		removed, err := cache.Prune(time.Now().Add(-time.Hour))
+		if err != nil || len(removed) != 0 {
+			t.Errorf("Expecting recently used chart kept: %v [%v]", removed, err)
+		}
+		removed, err = cache.Prune(time.Now().Add(time.Hour))
+		if err != nil || len(removed) != 1 || len(cache.Entries) != 0 {
+			t.Errorf("Expecting chart removed: %v [%v]", removed, err)
+		}
+		archives := findFilesInDir(cacheDir, ".tgz")
+		if len(archives) != 0 {
+			t.Errorf("Expecting no archives left in cache: %v", archives)
+		}
+	})
+
+	t.Run("helm-cache-concurrent-writer", func(t *testing.T) {
+		cache, _ := NewChartCache(cacheDir)
+		if _, err := cache.Store(chartRef, chartPath); err != nil {
+			t.Fatalf("Error storing chart [%v]", err)
+		}
+		// Another process adds an entry to the index on disk
+		index, err := ioutil.ReadFile(filepath.Join(cacheDir, chartCacheIndexFile))
+		if err != nil {
+			t.Fatalf("Error reading index [%v]", err)
+		}
+		other := strings.Replace(string(index), chartRef, "oci://other/charts/sample", 1)
+		if err := ioutil.WriteFile(filepath.Join(cacheDir, chartCacheIndexFile), []byte(other), 0644); err != nil {
+			t.Fatalf("Error writing index [%v]", err)
+		}
+		if _, err := cache.Store(chartRef, chartPath); err != nil {
+			t.Fatalf("Error storing chart [%v]", err)
+		}
+		if len(cache.Entries) != 2 || cache.Lookup("oci://other/charts/sample", "0.1.0", "") == "" {
+			t.Errorf("Expecting the entry of the other process kept: %v", cache.Entries)
+		}
+	})
+
+	t.Run("helm-cache-repository-url", func(t *testing.T) {
+		refs := map[string]string{}
+		for _, url := range []string{"https://charts.example.com/stable", "https://mirror.example.com/charts/"} {
+			repoFile := repo.NewFile()
+			repoFile.Update(&repo.Entry{Name: "stable", URL: url})
+			repoConfig := filepath.Join(tmpDir, "repositories-"+strings.Split(url, "/")[2]+".yaml")
+			if err := repoFile.WriteFile(repoConfig, 0600); err != nil {
+				t.Fatalf("Error writing repositories file [%v]", err)
+			}
+			helmClient := NewHelmClient()
+			helmClient.RepositoryConfig = repoConfig
+			refs[url] = helmClient.cacheRef("stable/mysql")
+		}
+		if refs["https://charts.example.com/stable"] != "https://charts.example.com/stable/mysql" ||
+			refs["https://mirror.example.com/charts/"] != "https://mirror.example.com/charts/mysql" {
+			t.Errorf("Expecting charts cached by repository URL, got %v", refs)
+		}
+		if ref := newClient().cacheRef("unknown/mysql"); ref != "" {
+			t.Errorf("Expecting the chart of an unknown repository not cached, got %v", ref)
+		}
+	})
+}
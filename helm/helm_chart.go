@@ -2,12 +2,15 @@ package helm
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"latimer/core"
 	"latimer/kube"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v2"
+	"helm.sh/helm/v3/pkg/storage/driver"
 )
 
 // Chart class is a wrapper around a k8s HELM chart
@@ -21,28 +24,47 @@ type Chart struct {
 	// The chart descriptor
 	Descriptor *core.ChartDescriptor `json:"descriptor"`
 
-	// The loaded values map
-	ValuesMap map[string]interface{}
+	// The loaded values map, never serialized as it may hold decrypted secrets
+	ValuesMap map[string]interface{} `json:"-" yaml:"-"`
+
+	// Encrypted is whether any of the values files of the chart was encrypted
+	Encrypted bool `json:"encrypted"`
+
+	// templateValues are the latimer values the values files are templated with
+	templateValues map[string]string
 }
 
-// NewChart creates a new instance of a helm chart
-func NewChart(chartDescriptor *core.ChartDescriptor) *Chart {
+// NewChart creates a new instance of a helm chart.  The values files are templated with the given latimer values.
+// The values of a chart with config map or secret values files are loaded from the cluster the chart is installed
+// in (see valuesFor).
+func NewChart(chartDescriptor *core.ChartDescriptor, values map[string]string) *Chart {
 	hc := new(Chart)
 	hc.Name = chartDescriptor.Name
 	hc.ChartRef = chartDescriptor.ChartLocator
 	hc.Descriptor = chartDescriptor
-	valuesFiles := make([]string, 0)
-	for _, valueFile := range hc.Descriptor.Values {
-		valuesFiles = append(valuesFiles, valueFile.URL)
+	hc.templateValues = values
+	if hc.clusterValues() {
+		return hc
 	}
-	valMap, err := loadHelmValues(valuesFiles)
+	valMap, encrypted, err := loadHelmValues(hc.Descriptor.Values, values)
 	if err != nil {
 		panic("Error loading values file for chart: " + hc.Name)
 	}
 	hc.ValuesMap = valMap
+	hc.Encrypted = encrypted
 	return hc
 }
 
+// clusterValues returns whether any values file of the chart is a config map or secret key of a cluster
+func (hc *Chart) clusterValues() bool {
+	for _, valuesFile := range hc.Descriptor.Values {
+		if core.IsClusterLocator(valuesFile.URL) {
+			return true
+		}
+	}
+	return false
+}
+
 // GetID returns the identifier name for this Installable.
 func (hc *Chart) GetID() string {
 	return hc.Name
@@ -71,8 +93,26 @@ func (hc *Chart) Install(sc *core.SystemContext) bool {
 	releaseNamespace := hc.Descriptor.Namespace
 	releaseName := hc.Descriptor.ReleaseName
 
-	helmClient := NewHelmClient()
-	releaseInfo, err := helmClient.Install(releaseName, releaseNamespace, hc.ChartRef, hc.ValuesMap)
+	sc, err := sc.ForTarget(hc.Descriptor.Target)
+	if err != nil {
+		logrus.Errorf("Cannot install chart %v [%v]", hc.Name, err)
+		return false
+	}
+	valuesMap, err := hc.valuesFor(sc)
+	if err != nil {
+		logrus.Errorf("Invalid value overrides for chart %v [%v]", hc.Name, err)
+		return false
+	}
+	helmClient, err := hc.helmClientFor(sc)
+	if err != nil {
+		logrus.Errorf("Cannot install chart %v [%v]", hc.Name, err)
+		return false
+	}
+	if err := hc.ensureNamespace(sc); err != nil {
+		logrus.Errorf("Cannot set up namespace %v of chart %v [%v]", releaseNamespace, hc.Name, err)
+		return false
+	}
+	releaseInfo, err := helmClient.Install(releaseName, releaseNamespace, hc.chartRefFor(sc), valuesMap)
 	status := true
 	if releaseInfo != nil && err != nil {
 		logrus.Warningf("Helm chart %v is already installed in the namespace %v", releaseName, releaseNamespace)
@@ -82,20 +122,69 @@ func (hc *Chart) Install(sc *core.SystemContext) bool {
 		status = false
 	} else {
 		fmt.Printf("%v", releaseInfo.Info.Notes)
-		fmt.Printf("Helm chart %v installed to namespace %v\n", releaseName, releaseNamespace)
+		fmt.Printf("Helm chart %v installed to namespace %v%v\n", releaseName, releaseNamespace, targetSuffix(sc))
 		fmt.Println("----------------------------------------------------------------------------------------")
 	}
 	return status
 }
 
+// Upgrade upgrades the release of the chart to the chart version and values of the manifest, or installs it if
+// it does not exist yet
+func (hc *Chart) Upgrade(sc *core.SystemContext) bool {
+	releaseNamespace := hc.Descriptor.Namespace
+	releaseName := hc.Descriptor.ReleaseName
+
+	targetSC, err := sc.ForTarget(hc.Descriptor.Target)
+	if err != nil {
+		logrus.Errorf("Cannot upgrade chart %v [%v]", hc.Name, err)
+		return false
+	}
+	helmClient, err := hc.helmClientFor(targetSC)
+	if err != nil {
+		logrus.Errorf("Cannot upgrade chart %v [%v]", hc.Name, err)
+		return false
+	}
+	if _, err := helmClient.Status(releaseName, releaseNamespace); errors.Is(err, driver.ErrReleaseNotFound) {
+		return hc.Install(sc)
+	} else if err != nil {
+		logrus.Errorf("Cannot get the status of release %v [%v]", releaseName, err)
+		return false
+	}
+	valuesMap, err := hc.valuesFor(targetSC)
+	if err != nil {
+		logrus.Errorf("Invalid value overrides for chart %v [%v]", hc.Name, err)
+		return false
+	}
+	releaseInfo, err := helmClient.Upgrade(releaseName, releaseNamespace, hc.chartRefFor(targetSC), valuesMap)
+	if err != nil {
+		logrus.Errorf("Upgrade failed [%v]", err)
+		return false
+	}
+	fmt.Printf("%v", releaseInfo.Info.Notes)
+	fmt.Printf("Helm chart %v upgraded in namespace %v%v\n", releaseName, releaseNamespace, targetSuffix(targetSC))
+	fmt.Println("----------------------------------------------------------------------------------------")
+	return true
+}
+
 // Uninstall the contents of this installable
 func (hc *Chart) Uninstall(sc *core.SystemContext) bool {
 	releaseNamespace := hc.Descriptor.Namespace
 	releaseName := hc.Descriptor.ReleaseName
 
 	status := true
+	sc, err := sc.ForTarget(hc.Descriptor.Target)
+	if err != nil {
+		logrus.Errorf("Cannot uninstall chart %v [%v]", hc.Name, err)
+		return false
+	}
 	helmClient := NewHelmClient()
+	setTarget(helmClient, sc)
 	release, err := helmClient.Status(releaseName, releaseNamespace)
+	if err != nil && !errors.Is(err, driver.ErrReleaseNotFound) {
+		// The release may still be installed, its volumes and namespace are left alone
+		logrus.Errorf("Cannot get the status of release %v [%v]", releaseName, err)
+		return false
+	}
 
 	// If release does not exist already we just return successful uninstall
 	if err == nil && release != nil {
@@ -104,15 +193,137 @@ func (hc *Chart) Uninstall(sc *core.SystemContext) bool {
 			logrus.Errorf("Delete failed [%v]", err.Error())
 			status = false
 		} else {
-			fmt.Printf("Helm chart %v deleted from namespace %v\n", releaseName, releaseNamespace)
+			fmt.Printf("Helm chart %v deleted from namespace %v%v\n", releaseName, releaseNamespace, targetSuffix(sc))
+			status = hc.waitForDeletion(sc, release.Manifest)
 		}
 	}
+	if status {
+		status = hc.deleteVolumes(sc)
+	}
+	if status && hc.Descriptor.DeletesNamespace() {
+		status = hc.deleteNamespace(sc)
+	}
 	return status
 }
 
+// waitForDeletion waits for the objects of the release manifest, and the pods (and volume claims) created on
+// behalf of the release, to be gone.  Objects left when the delete timeout expires are reported, with the
+// finalizers holding them.
+func (hc *Chart) waitForDeletion(sc *core.SystemContext, manifest string) bool {
+	releaseName := hc.Descriptor.ReleaseName
+	refs, err := kube.ReleaseObjects(manifest, hc.Descriptor.Namespace)
+	if err != nil {
+		logrus.Errorf("Cannot read the objects of release %v [%v]", releaseName, err)
+		return false
+	}
+	scope := kube.DeletionScope{
+		Refs:      refs,
+		Namespace: hc.Descriptor.Namespace,
+		Selectors: kube.ReleaseSelectors(releaseName),
+		Volumes:   hc.Descriptor.WaitForVolumes,
+	}
+	timeout := time.Duration(hc.Descriptor.DeleteTimeout) * time.Second
+	pending, err := sc.GetKubeClient().WaitForDeletion(scope, timeout)
+	if err != nil {
+		logrus.Errorf("Error waiting for the deletion of release %v [%v]", releaseName, err)
+		return false
+	}
+	if len(pending) > 0 {
+		logrus.Errorf("Release %v not fully deleted after %v, %v objects left:", releaseName, timeout, len(pending))
+		for _, p := range pending {
+			logrus.Errorf("  %v", p)
+		}
+		return false
+	}
+	return true
+}
+
+// deleteVolumes applies the onDelete policy to the persistent volume claims of the release: they are deleted if
+// the policy is delete or data is purged, otherwise they are recorded as retained in the context
+func (hc *Chart) deleteVolumes(sc *core.SystemContext) bool {
+	k8s := sc.GetKubeClient()
+	releaseName := hc.Descriptor.ReleaseName
+	selectors := kube.ReleaseSelectors(releaseName)
+	claims, err := k8s.GetVolumeClaims(hc.Descriptor.Namespace, selectors)
+	if err != nil {
+		logrus.Errorf("Cannot list the volume claims of release %v [%v]", releaseName, err)
+		return false
+	}
+	if len(claims) == 0 {
+		return true
+	}
+	purge := sc.Context != nil && sc.Context.PurgeData
+	if hc.Descriptor.OnDelete.PVCs != core.DeleteVolumes && !purge {
+		if sc.Context != nil {
+			sc.Context.AddRetainedVolumes(hc.Name, claims)
+		}
+		return true
+	}
+	if err := k8s.DeleteVolumeClaims(claims); err != nil {
+		logrus.Errorf("Cannot delete the volume claims of release %v [%v]", releaseName, err)
+		return false
+	}
+	for _, claim := range claims {
+		fmt.Printf("Volume claim %v deleted%v\n", claim, targetSuffix(sc))
+	}
+	scope := kube.DeletionScope{Namespace: hc.Descriptor.Namespace, Selectors: selectors, Volumes: true}
+	timeout := time.Duration(hc.Descriptor.DeleteTimeout) * time.Second
+	pending, err := k8s.WaitForDeletion(scope, timeout)
+	if err != nil || len(pending) > 0 {
+		logrus.Errorf("Volume claims of release %v not deleted after %v: %v [%v]", releaseName, timeout, pending, err)
+		return false
+	}
+	return true
+}
+
+// ensureNamespace creates the namespace of the chart if missing and enabled, and applies the labels and
+// annotations declared for it in the manifest
+func (hc *Chart) ensureNamespace(sc *core.SystemContext) error {
+	k8s := sc.GetKubeClient()
+	namespace := hc.Descriptor.Namespace
+	labels, annotations := map[string]string{}, map[string]string{}
+	if md := hc.Descriptor.NamespaceMetadata; md != nil {
+		labels, annotations = md.Labels, md.Annotations
+	}
+	if !hc.Descriptor.CreatesNamespace() {
+		if len(labels) == 0 && len(annotations) == 0 {
+			return nil
+		}
+		if k8s.GetNamespace(namespace) == nil {
+			return fmt.Errorf("Namespace %v not found, set createNamespace to create it", namespace)
+		}
+	}
+	created, err := k8s.EnsureNamespace(namespace, labels, annotations)
+	if created {
+		fmt.Printf("Namespace %v created%v\n", namespace, targetSuffix(sc))
+	}
+	return err
+}
+
+// deleteNamespace deletes the namespace of the chart if latimer created it and it is empty.  A namespace still in
+// use (eg by other charts) is kept.
+func (hc *Chart) deleteNamespace(sc *core.SystemContext) bool {
+	namespace := hc.Descriptor.Namespace
+	deleted, reason, err := sc.GetKubeClient().DeleteNamespaceIfEmpty(namespace)
+	if err != nil {
+		logrus.Errorf("Cannot delete namespace %v [%v]", namespace, err)
+		return false
+	}
+	if deleted {
+		fmt.Printf("Namespace %v deleted%v\n", namespace, targetSuffix(sc))
+	} else {
+		logrus.Infof("Namespace %v of chart %v kept: %v", namespace, hc.Name, reason)
+	}
+	return true
+}
+
 // Status returns the status of the  installation
 func (hc *Chart) Status(sc *core.SystemContext) kube.InstallStatus {
-	k8s := sc.Context.KubeClient
+	sc, err := sc.ForTarget(hc.Descriptor.Target)
+	if err != nil {
+		return kube.InstallationError
+	}
+	k8s := sc.GetKubeClient()
 	namespace := hc.Descriptor.Namespace
 	releaseName := hc.Descriptor.ReleaseName
 	rr, err := k8s.GetResourcesInRelease(releaseName, namespace)
@@ -121,3 +332,156 @@ func (hc *Chart) Status(sc *core.SystemContext) kube.InstallStatus {
 	}
 	return rr.ReleaseStatus()
 }
+
+// valuesFor returns the chart values with the command line/environment overrides of the system context applied
+func (hc *Chart) valuesFor(sc *core.SystemContext) (map[string]interface{}, error) {
+	valuesMap := hc.ValuesMap
+	if hc.clusterValues() && (sc.Context == nil || sc.Context.Bundle.GetChart(hc.Name) == nil) {
+		// The config maps and secrets are read in the target cluster of the chart
+		clusterValuesMap, encrypted, err := readHelmValues(hc.Descriptor.Values, hc.templateValues, sc.ReadLocator)
+		if err != nil {
+			return nil, fmt.Errorf("Error loading values files of chart %v%v: %v", hc.Name, targetSuffix(sc), err)
+		}
+		valuesMap = clusterValuesMap
+		hc.Encrypted = hc.Encrypted || encrypted
+	}
+	if sc.Context == nil {
+		return valuesMap, nil
+	}
+	if bundled := sc.Context.Bundle.GetChart(hc.Name); bundled != nil {
+		// The bundled values are already templated
+		valuesPath := sc.Context.Bundle.Path(bundled.Values)
+		valuesBytes, err := readFile(valuesPath)
+		if err != nil {
+			return nil, err
+		}
+		valuesBytes, _, err = decryptValues(valuesPath, valuesBytes)
+		if err != nil {
+			return nil, err
+		}
+		valuesMap = map[string]interface{}{}
+		if err := yaml.Unmarshal(valuesBytes, &valuesMap); err != nil {
+			return nil, err
+		}
+		valuesMap = convertStringKeyMap(valuesMap)
+	}
+	overrides, found := sc.Context.ChartValues[hc.Name]
+	if !found {
+		return valuesMap, nil
+	}
+	return applyOverrides(valuesMap, overrides)
+}
+
+// chartRefFor returns the locator of the chart, or the path of its archive in the bundle of the system context
+func (hc *Chart) chartRefFor(sc *core.SystemContext) string {
+	if sc.Context == nil {
+		return hc.ChartRef
+	}
+	if bundled := sc.Context.Bundle.GetChart(hc.Name); bundled != nil {
+		return "file://" + sc.Context.Bundle.Path(bundled.Archive)
+	}
+	return hc.ChartRef
+}
+
+// Resolve finds the exact version and digest of the chart matching its version constraint
+func (hc *Chart) Resolve(sc *core.SystemContext) (*core.LockedChart, error) {
+	helmClient := hc.newHelmClient(sc)
+	version, digest, err := helmClient.Resolve(hc.ChartRef)
+	if err != nil {
+		return nil, err
+	}
+	return &core.LockedChart{
+		Name:         hc.Name,
+		ChartLocator: hc.ChartRef,
+		Constraint:   hc.Descriptor.Version,
+		Version:      version,
+		Digest:       digest,
+	}, nil
+}
+
+// helmClientFor returns a helm client pinned to the chart version constraint, or to the exact version and
+// digest recorded in the bundle or the lock file of the system context
+func (hc *Chart) helmClientFor(sc *core.SystemContext) (*HelmClient, error) {
+	if sc.Context != nil && sc.Context.Bundle != nil {
+		return hc.bundleHelmClient(sc)
+	}
+	helmClient := hc.newHelmClient(sc)
+	if sc.Context == nil || sc.Context.Lock == nil {
+		return helmClient, nil
+	}
+	locked := sc.Context.Lock.GetChart(hc.Name)
+	if locked == nil && helmClient.Verify {
+		return nil, fmt.Errorf("Chart %v is verified but not in the lock file, its digest cannot be checked", hc.Name)
+	} else if locked == nil {
+		logrus.Warningf("Chart %v is not in the lock file, using version constraint [%v]", hc.Name, hc.Descriptor.Version)
+		return helmClient, nil
+	}
+	if err := locked.Check(hc.Descriptor); err != nil {
+		return nil, err
+	}
+	helmClient.Version = locked.Version
+	helmClient.Digest = locked.Digest
+	return helmClient, nil
+}
+
+// newHelmClient returns a helm client for the chart version constraint using the repositories and chart cache
+// of the context
+func (hc *Chart) newHelmClient(sc *core.SystemContext) *HelmClient {
+	helmClient := NewHelmClient()
+	helmClient.Version = hc.Descriptor.Version
+	helmClient.Verify = hc.Descriptor.Verify
+	setTarget(helmClient, sc)
+	if sc.Context != nil {
+		helmClient.RepositoryConfig = sc.Context.RepositoryConfig
+		helmClient.RepositoryCache = sc.Context.RepositoryCache
+		helmClient.Registries = sc.Context.Registries
+		helmClient.Repositories = sc.Context.Repositories
+		helmClient.ImageMirror = sc.Context.ImageMirror
+		helmClient.Verify = hc.Descriptor.Verify || sc.Context.Verify
+		helmClient.Keyring = sc.Context.Keyring
+		if sc.Context.ChartCacheDir != "" {
+			cache, err := NewChartCache(sc.Context.ChartCacheDir)
+			if err != nil {
+				logrus.Warningf("Chart cache %v not available [%v]", sc.Context.ChartCacheDir, err)
+			} else {
+				helmClient.Cache = cache
+			}
+		}
+	}
+	return helmClient
+}
+
+// bundleHelmClient returns a helm client pinned to the version and digest of the chart archive in the bundle of
+// the system context.  The client has no access to chart repositories.
+func (hc *Chart) bundleHelmClient(sc *core.SystemContext) (*HelmClient, error) {
+	bundled := sc.Context.Bundle.GetChart(hc.Name)
+	if bundled == nil {
+		return nil, fmt.Errorf("Chart %v is not in the bundle", hc.Name)
+	}
+	if bundled.ChartLocator != hc.ChartRef {
+		return nil, fmt.Errorf("Bundle is out of date for chart %v: bundled %v, manifest has %v", hc.Name, bundled.ChartLocator, hc.ChartRef)
+	}
+	helmClient := NewHelmClient()
+	helmClient.Version = bundled.Version
+	helmClient.Digest = bundled.Digest
+	helmClient.ImageMirror = sc.Context.ImageMirror
+	helmClient.Verify = hc.Descriptor.Verify || sc.Context.Verify
+	helmClient.Keyring = sc.Context.Keyring
+	setTarget(helmClient, sc)
+	return helmClient, nil
+}
+
+// setTarget points the helm client to the cluster of the system context
+func setTarget(helmClient *HelmClient, sc *core.SystemContext) {
+	if kubeClient := sc.GetKubeClient(); kubeClient != nil {
+		helmClient.KubeFactory = kubeClient.Factory()
+	}
+}
+
+// targetSuffix returns the description of the target cluster of the system context for user messages
+func targetSuffix(sc *core.SystemContext) string {
+	if sc.Target == "" {
+		return ""
+	}
+	return fmt.Sprintf(" of target %v (%v)", sc.Target, sc.URL.String())
+}
@@ -15,16 +15,14 @@ var chartDescriptor core.ChartDescriptor = core.ChartDescriptor{
 	ChartLocator: "stable/memcached",
 	Namespace:    "paas",
 	ReleaseName:  "test-memcached",
-	Values: []struct {
-		URL string `json:"url"`
-	}{},
+	Values:       []core.ValuesDescriptor{},
 }
 
 func Test_helmchart(t *testing.T) {
 	t.Run("helm-chart-create", func(t *testing.T) {
 		t.Logf("Testing helm chart create")
 
-		chart := NewChart(&chartDescriptor)
+		chart := NewChart(&chartDescriptor, map[string]string{})
 		if chart.Name != chartDescriptor.Name || chart.ChartRef != chartDescriptor.ChartLocator {
 			t.Errorf("Chart creation failed %v  descriptor=%v", chart, chartDescriptor.Name)
 		}
@@ -35,7 +33,7 @@ func Test_helmchart(t *testing.T) {
 		t.Logf("Testing helm chart create")
 
 		lc := core.GetLatimerContext()
-		chart := NewChart(&chartDescriptor)
+		chart := NewChart(&chartDescriptor, map[string]string{})
 		sc := new(core.SystemContext)
 		sc.Context = lc
 		sc.Name = "test-memcached"
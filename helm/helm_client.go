@@ -1,14 +1,21 @@
 package helm
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"latimer/core"
+	"latimer/kube"
 	"log"
 	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
+	"text/template"
 
 	"github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v2"
@@ -17,11 +24,37 @@ import (
 	"helm.sh/helm/v3/pkg/chart/loader"
 	"helm.sh/helm/v3/pkg/cli"
 	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/strvals"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
 )
 
 // HelmClient represents a helm client capable of issuing helm commands againts a kubernetes API server in a given
 // namespace
 type HelmClient struct {
+	// Version is the version constraint of the charts to pull (latest if empty)
+	Version string
+	// Digest is the expected digest (sha256:<hex>) of the chart archives pulled (not verified if empty)
+	Digest string
+	// RepositoryConfig is the path of the repositories file (helm default if empty)
+	RepositoryConfig string
+	// RepositoryCache is the directory of the repository index files (helm default if empty)
+	RepositoryCache string
+	// Registries holds the settings and credentials of the OCI registries charts are pulled from
+	Registries []core.RepositoryDescriptor
+	// Repositories holds the chart repositories declared in the manifest, whose credentials are passed to the
+	// pulls rather than stored in the repositories file
+	Repositories []core.RepositoryDescriptor
+	// Cache is the chart cache shared between runs (charts are always pulled if nil)
+	Cache *ChartCache
+	// ImageMirror is the registry the container images of the rendered templates are rewritten to (no rewrite
+	// if empty)
+	ImageMirror string
+	// Verify is whether to verify the provenance file of the charts against the keyring before loading them
+	Verify bool
+	// Keyring is the path of the public keyring used to verify chart provenance (helm default if empty)
+	Keyring string
+	// KubeFactory configures the clients of the cluster the releases are managed in (helm environment if nil)
+	KubeFactory *kube.ClientFactory
 }
 
 // NewHelmClient creates a new helm client to manage charts in a specified namespace
@@ -32,7 +65,7 @@ func NewHelmClient() *HelmClient {
 
 // Status returns the status of the helm release in the given namespace.  Used to know if release exists
 func (hc *HelmClient) Status(releaseName string, namespace string) (*release.Release, error) {
-	actionConfig, err := newHelmConfig(namespace)
+	actionConfig, err := hc.newHelmConfig(namespace)
 	if err != nil {
 		return nil, err
 	}
@@ -50,12 +83,25 @@ func (hc *HelmClient) Upgrade(releaseName string, namespace string, chartRef str
 		return nil, err
 	}
 
-	actionConfig, err := newHelmConfig(namespace)
+	actionConfig, err := hc.newHelmConfig(namespace)
 	if err != nil {
 		return nil, err
 	}
 
 	iCli := action.NewUpgrade(actionConfig)
+	if hc.ImageMirror != "" {
+		mirror := &imageMirror{mirror: hc.ImageMirror}
+		iCli.PostRenderer = mirror
+		err = mirror.checkHooks(chart.Name(), func() (*release.Release, error) {
+			dryRun := action.NewUpgrade(actionConfig)
+			dryRun.PostRenderer = mirror
+			dryRun.DryRun = true
+			return dryRun.Run(releaseName, chart, valuesMap)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
 	releaseInfo, err := iCli.Run(releaseName, chart, valuesMap)
 	return releaseInfo, err
 }
@@ -74,7 +120,7 @@ func (hc *HelmClient) Install(releaseName string, namespace string, chartRef str
 		return nil, err
 	}
 
-	actionConfig, err := newHelmConfig(namespace)
+	actionConfig, err := hc.newHelmConfig(namespace)
 	if err != nil {
 		logrus.Errorf("Error obtaining helm-config: [%v]", err)
 		return nil, err
@@ -84,6 +130,22 @@ func (hc *HelmClient) Install(releaseName string, namespace string, chartRef str
 	iCli.Namespace = namespace
 	iCli.ReleaseName = releaseName
 	iCli.DryRun = false
+	if hc.ImageMirror != "" {
+		mirror := &imageMirror{mirror: hc.ImageMirror}
+		iCli.PostRenderer = mirror
+		err = mirror.checkHooks(chart.Name(), func() (*release.Release, error) {
+			dryRun := action.NewInstall(actionConfig)
+			dryRun.Namespace = namespace
+			dryRun.ReleaseName = releaseName
+			dryRun.PostRenderer = mirror
+			dryRun.DryRun = true
+			return dryRun.Run(chart, valuesMap)
+		})
+		if err != nil {
+			logrus.Errorf("Error rendering chart: [%v]", err)
+			return nil, err
+		}
+	}
 
 	rel, err := iCli.Run(chart, valuesMap)
 	if err != nil {
@@ -96,7 +158,7 @@ func (hc *HelmClient) Install(releaseName string, namespace string, chartRef str
 
 // Delete installs the helm chart located in the specified chart path location
 func (hc *HelmClient) Delete(releaseName string, namespace string) error {
-	actionConfig, err := newHelmConfig(namespace)
+	actionConfig, err := hc.newHelmConfig(namespace)
 	if err != nil {
 		return err
 	}
@@ -116,43 +178,208 @@ func (hc *HelmClient) Delete(releaseName string, namespace string) error {
 func (hc *HelmClient) Pull(chartRef string, outDir string) (string, error) {
 	actionPull := action.NewPull()
 	actionPull.DestDir = outDir
-	actionPull.Settings = cli.New()
+	actionPull.Settings = hc.settings()
+	actionPull.Version = hc.Version
+	actionPull.Verify = hc.Verify
+	actionPull.Keyring = hc.keyring()
+	username, password, err := hc.repositoryCredentials(chartRef)
+	if err != nil {
+		return "", err
+	}
+	actionPull.Username = username
+	actionPull.Password = password
 
 	output, err := actionPull.Run(chartRef)
 	return output, err
 }
 
+// Resolve pulls the chart matching the version constraint of the client and returns its exact version and the
+// digest of its archive
+func (hc *HelmClient) Resolve(chartRef string) (string, string, error) {
+	chartPath, cleanup, err := hc.chartPath(chartRef)
+	if err != nil {
+		return "", "", err
+	}
+	defer cleanup()
+	digest := ""
+	if info, err := os.Stat(chartPath); err == nil && !info.IsDir() {
+		digest, err = fileDigest(chartPath)
+		if err != nil {
+			return "", "", err
+		}
+	}
+	chart, err := loader.Load(chartPath)
+	if err != nil {
+		return "", "", err
+	}
+	return chart.Metadata.Version, digest, nil
+}
+
+// settings returns the helm environment settings with the repository locations of the client
+func (hc *HelmClient) settings() *cli.EnvSettings {
+	settings := cli.New()
+	if hc.RepositoryConfig != "" {
+		settings.RepositoryConfig = hc.RepositoryConfig
+	}
+	if hc.RepositoryCache != "" {
+		settings.RepositoryCache = hc.RepositoryCache
+	}
+	return settings
+}
+
 func (hc *HelmClient) loadChart(chartRef string) (*chart.Chart, error) {
-	chartPath := ""
+	chartPath, cleanup, err := hc.chartPath(chartRef)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+	return hc.loadChartPath(chartRef, chartPath)
+}
+
+// loadChartPath loads the chart pulled to the given path, verifying its provenance, digest and version constraint
+func (hc *HelmClient) loadChartPath(chartRef string, chartPath string) (*chart.Chart, error) {
+	if hc.Verify {
+		verification, err := verifyProvenance(chartPath, hc.keyring())
+		if err != nil {
+			return nil, fmt.Errorf("Provenance verification failed for chart %v: %v", chartRef, err)
+		}
+		logrus.Infof("Chart %v signed by %v [%v]", chartRef, verification.signerName(), verification.FileHash)
+	}
+	if hc.Digest != "" {
+		digest, err := fileDigest(chartPath)
+		if err != nil {
+			return nil, err
+		}
+		if digest != hc.Digest {
+			return nil, fmt.Errorf("Digest mismatch for chart %v: expected %v, got %v", chartRef, hc.Digest, digest)
+		}
+	}
+	chart, err := loader.Load(chartPath)
+	if err != nil {
+		return nil, err
+	}
+	ok, err := core.SatisfiesConstraint(chart.Metadata.Version, hc.Version)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("Chart %v version %v does not satisfy %v", chartRef, chart.Metadata.Version, hc.Version)
+	}
+	return chart, nil
+}
+
+// chartPath returns the local path of the chart, pulling it from its repository (or the chart cache) if
+// needed.  The returned cleanup function removes any temporary file created.
+func (hc *HelmClient) chartPath(chartRef string) (string, func(), error) {
+	cleanup := func() {}
 	if strings.HasPrefix(chartRef, "file:") {
 		urlRef, err := url.Parse(chartRef)
 		if err != nil {
 			logrus.Errorf("Error parsing file URL %v [%v]\n", chartRef, err.Error())
-			return nil, err
+			return "", cleanup, err
 		}
-		chartPath = urlRef.RequestURI()
-	} else {
-		// If installing directly from repository, pull chart first and then install from temp filesystem location
-		tmpDir, err := ioutil.TempDir(os.TempDir(), "helm-*")
+		return urlRef.RequestURI(), cleanup, nil
+	}
+	// The charts are cached by the URL of their repository, the same alias may name other repositories elsewhere
+	cacheRef := hc.cacheRef(chartRef)
+	if hc.Cache != nil && cacheRef != "" && exactVersion(hc.Version) {
+		if cachedPath := hc.Cache.Lookup(cacheRef, strings.TrimPrefix(hc.Version, "v"), hc.Digest); cachedPath != "" && hc.hasProvenance(cachedPath) {
+			return cachedPath, cleanup, nil
+		}
+	}
+	// If installing directly from repository, pull chart first and then install from temp filesystem location
+	tmpDir, err := ioutil.TempDir(os.TempDir(), "helm-*")
+	if err != nil {
+		logrus.Errorf("Error creating temp directory %v %v", err.Error(), tmpDir)
+		return "", cleanup, err
+	}
+	cleanup = func() { os.RemoveAll(tmpDir) }
+	chartPath := ""
+	if strings.HasPrefix(chartRef, OCIScheme) {
+		if hc.Verify {
+			return "", cleanup, errors.New("Provenance verification is not supported for OCI chart " + chartRef)
+		}
+		chartPath, err = hc.pullOCI(chartRef, tmpDir)
 		if err != nil {
-			logrus.Errorf("Error creating temp directory %v %v", err.Error(), tmpDir)
-			return nil, err
+			logrus.Errorf("Error pulling chart from registry: %v", err.Error())
+			return "", cleanup, err
 		}
-		defer os.RemoveAll(tmpDir)
-		chartPath, err = hc.Pull(chartRef, tmpDir)
+	} else {
+		_, err = hc.Pull(chartRef, tmpDir)
 		if err != nil {
 			logrus.Errorf("Error pulling chart: %v", err.Error())
-			return nil, err
+			return "", cleanup, err
 		}
 		f := findFilesInDir(tmpDir, ".tgz")
 		if len(f) > 0 {
 			chartPath = filepath.Join(tmpDir, f[0].Name())
 		} else {
-			return nil, errors.New("No chart file found in directory " + tmpDir)
+			return "", cleanup, errors.New("No chart file found in directory " + tmpDir)
 		}
 	}
-	chart, err := loader.Load(chartPath)
-	return chart, err
+	if hc.Cache != nil && cacheRef != "" {
+		cachedPath, err := hc.Cache.Store(cacheRef, chartPath)
+		if err != nil {
+			logrus.Warningf("Error storing chart %v in cache [%v]", chartRef, err)
+		} else {
+			cleanup()
+			return cachedPath, func() {}, nil
+		}
+	}
+	return chartPath, cleanup, nil
+}
+
+// cacheRef returns the reference of the chart in the chart cache: the oci:// reference, or the URL of the
+// repository of a repo/name reference followed by the chart name.  Returns the empty string if the repository is
+// unknown, the chart is not cached then.
+func (hc *HelmClient) cacheRef(chartRef string) string {
+	if strings.HasPrefix(chartRef, OCIScheme) {
+		return chartRef
+	}
+	parts := strings.SplitN(chartRef, "/", 2)
+	if len(parts) != 2 {
+		return ""
+	}
+	repoFile, err := hc.loadRepositoryFile()
+	if err != nil {
+		return ""
+	}
+	entry := repoFile.Get(parts[0])
+	if entry == nil {
+		return ""
+	}
+	return strings.TrimSuffix(entry.URL, "/") + "/" + parts[1]
+}
+
+// keyring returns the path of the public keyring used to verify chart provenance
+func (hc *HelmClient) keyring() string {
+	if hc.Keyring != "" {
+		return hc.Keyring
+	}
+	return defaultKeyring()
+}
+
+// hasProvenance returns whether the chart archive has a provenance file, or provenance is not verified
+func (hc *HelmClient) hasProvenance(chartPath string) bool {
+	if !hc.Verify {
+		return true
+	}
+	_, err := os.Stat(chartPath + ".prov")
+	return err == nil
+}
+
+// fileDigest returns the sha256 digest of a file in the form sha256:<hex>
+func fileDigest(filePath string) (string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
 }
 
 // Returns files with filename suffix
@@ -171,10 +398,14 @@ func findFilesInDir(directory string, suffix string) []os.FileInfo {
 }
 
 // newHelmConfig returns the helm context for a given installation
-func newHelmConfig(releaseNamespace string) (*action.Configuration, error) {
+func (hc *HelmClient) newHelmConfig(releaseNamespace string) (*action.Configuration, error) {
 	actionConfig := new(action.Configuration)
-	var settings = cli.New()
-	err := actionConfig.Init(settings.RESTClientGetter(), releaseNamespace, os.Getenv("HELM_DRIVER"), func(format string, v ...interface{}) {
+	var getter genericclioptions.RESTClientGetter = hc.settings().RESTClientGetter()
+	if hc.KubeFactory != nil {
+		// Same cluster, identity and rate limits as the latimer kubernetes clients
+		getter = hc.KubeFactory.ForNamespace(releaseNamespace)
+	}
+	err := actionConfig.Init(getter, releaseNamespace, os.Getenv("HELM_DRIVER"), func(format string, v ...interface{}) {
 		strContent := fmt.Sprintf(format, v)
 		logrus.Infof("HELM: %v\n", strContent)
 	})
@@ -185,27 +416,76 @@ func newHelmConfig(releaseNamespace string) (*action.Configuration, error) {
 }
 
 // Load helm values files in the order specified by the array.  Later file entries will overwrite earlier ones.
-func loadHelmValues(valueFiles []string) (map[string]interface{}, error) {
+// Each values file is decrypted if needed and, if marked as a template, templated with the latimer values before
+// being parsed.  Returns whether any of the values files was encrypted.
+func loadHelmValues(valueFiles []core.ValuesDescriptor, values map[string]string) (map[string]interface{}, bool, error) {
+	return readHelmValues(valueFiles, values, readFile)
+}
+
+// readHelmValues loads the helm values files like loadHelmValues, reading each file with the given function
+func readHelmValues(valueFiles []core.ValuesDescriptor, values map[string]string, read func(string) ([]byte, error)) (map[string]interface{}, bool, error) {
 	base := map[string]interface{}{}
+	encrypted := false
 
 	// User specified a values files via -f/--values
-	for _, filePath := range valueFiles {
+	for _, valueFile := range valueFiles {
 		currentMap := map[string]interface{}{}
+		filePath := valueFile.URL
 
 		logrus.Debugf("Reading values yaml file %v", filePath)
-		bytes, err := readFile(filePath)
+		fileBytes, err := read(filePath)
 		if err != nil {
 			logrus.Errorf("Error reading values yaml file: %v [%v]", filePath, err)
-			return nil, err
+			return nil, false, err
+		}
+		fileBytes, fileEncrypted, err := decryptValues(filePath, fileBytes)
+		if err != nil {
+			logrus.Errorf("Error decrypting values yaml file: %v [%v]", filePath, err)
+			return nil, false, err
+		}
+		encrypted = encrypted || fileEncrypted
+		if valueFile.Template {
+			// Other values files may hold {{ }} expressions of their own, eg for the tpl function of the chart
+			fileBytes, err = templateValues(filePath, fileBytes, values)
+			if err != nil {
+				logrus.Errorf("Error templating values yaml file: %v [%v]", filePath, err)
+				return nil, false, err
+			}
 		}
 
-		if err := yaml.Unmarshal(bytes, &currentMap); err != nil {
-			return nil, err
+		if err := yaml.Unmarshal(fileBytes, &currentMap); err != nil {
+			return nil, false, err
 		}
 		// Merge with the previous map
 		base = mergeMaps(base, currentMap)
 	}
 	base = convertStringKeyMap(base)
+	return base, encrypted, nil
+}
+
+// templateValues renders the contents of a values file using the latimer values as template arguments
+func templateValues(name string, content []byte, values map[string]string) ([]byte, error) {
+	tpl, err := template.New(filepath.Base(name)).Option("missingkey=error").Parse(string(content))
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	if err := tpl.Execute(&b, values); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// applyOverrides merges the key=value override expressions on top of a copy of the given values map
+func applyOverrides(valuesMap map[string]interface{}, overrides []string) (map[string]interface{}, error) {
+	base := mergeMaps(map[string]interface{}{}, valuesMap)
+	for _, override := range overrides {
+		overrideMap := map[string]interface{}{}
+		if err := strvals.ParseInto(override, overrideMap); err != nil {
+			return nil, err
+		}
+		base = mergeMaps(base, overrideMap)
+	}
 	return base, nil
 }
 
@@ -232,11 +512,8 @@ func mergeMaps(a, b map[string]interface{}) map[string]interface{} {
 // convertStringKeyMap converts internal map[interface{}]interface{} types to map[string]interface{}
 func convertStringKeyMap(m map[string]interface{}) map[string]interface{} {
 	a := make(map[string]interface{}, len(m))
-	logrus.Debugf("=======================================================")
-	logrus.Debugf("map = %#v", m)
 	for k, v := range m {
 		if aMap, ok := v.(map[interface{}]interface{}); ok {
-			logrus.Debugf("######### key=[%v]  valueMAP=[%v]", k, aMap)
 			nm := make(map[string]interface{}, len(aMap))
 			for kk, vv := range aMap {
 				strKey := kk.(string)
@@ -244,14 +521,14 @@ func convertStringKeyMap(m map[string]interface{}) map[string]interface{} {
 			}
 			a[k] = convertStringKeyMap(nm)
 		} else {
-			logrus.Debugf("######### key=[%v]  valueSTR=[%v]", k, v)
 			a[k] = v
 		}
 	}
 	return a
 }
 
-// readFile load a file from stdin, the local directory, or a remote file with a url.
+// readFile loads a values file from a local path, a file:// or http(s) URL, or a config map or secret key of the
+// cluster (see core.ReadLocator)
 func readFile(filePath string) ([]byte, error) {
-	return ioutil.ReadFile(filePath)
+	return core.ReadLocator(filePath)
 }
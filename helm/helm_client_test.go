@@ -2,7 +2,10 @@ package helm
 
 import (
 	"io/ioutil"
+	"latimer/core"
+	"latimer/kube"
 	"os"
+	"strings"
 	"testing"
 )
 
@@ -61,3 +64,92 @@ func Test_helm_delete(t *testing.T) {
 		t.Logf("Deleted helm chart [%v] from namespace: %v\n", releaseName, namespace)
 	})
 }
+
+func Test_helm_values(t *testing.T) {
+	t.Run("helm-values-template", func(t *testing.T) {
+		tmpFile, err := ioutil.TempFile(os.TempDir(), "values-*.yaml")
+		if err != nil {
+			panic(err.Error())
+		}
+		defer os.Remove(tmpFile.Name())
+		tmpFile.WriteString("image:\n  tag: \"{{.ImageTag}}\"\n  pullPolicy: Always\n")
+		tmpFile.Close()
+
+		valuesMap, _, err := loadHelmValues([]core.ValuesDescriptor{{URL: tmpFile.Name(), Template: true}}, map[string]string{"ImageTag": "8.0.20"})
+		if err != nil {
+			t.Errorf("Error loading values file %v [%v]", tmpFile.Name(), err)
+		}
+		image := valuesMap["image"].(map[string]interface{})
+		if image["tag"] != "8.0.20" {
+			t.Errorf("Expecting templated image tag, got: %v", image["tag"])
+		}
+
+		overridden, err := applyOverrides(valuesMap, []string{"image.tag=8.0.21,replicas=2"})
+		if err != nil {
+			t.Errorf("Error applying overrides [%v]", err)
+		}
+		image = overridden["image"].(map[string]interface{})
+		if image["tag"] != "8.0.21" || image["pullPolicy"] != "Always" || overridden["replicas"] != int64(2) {
+			t.Errorf("Unexpected overridden values: %v", overridden)
+		}
+		t.Logf("Overridden values: %v\n", overridden)
+	})
+
+	t.Run("helm-values-not-templated", func(t *testing.T) {
+		tmpFile, err := ioutil.TempFile(os.TempDir(), "values-*.yaml")
+		if err != nil {
+			panic(err.Error())
+		}
+		defer os.Remove(tmpFile.Name())
+		// Rendered by the tpl function of the chart, not by latimer
+		tmpFile.WriteString("ingress:\n  hostname: \"{{ .Release.Name }}.{{ .Values.domain }}\"\n")
+		tmpFile.Close()
+
+		valuesMap, _, err := loadHelmValues([]core.ValuesDescriptor{{URL: tmpFile.Name()}}, map[string]string{"ImageTag": "8.0.20"})
+		if err != nil {
+			t.Fatalf("Error loading values file %v [%v]", tmpFile.Name(), err)
+		}
+		ingress := valuesMap["ingress"].(map[string]interface{})
+		if ingress["hostname"] != "{{ .Release.Name }}.{{ .Values.domain }}" {
+			t.Errorf("Expecting the tpl expression kept as is, got: %v", ingress["hostname"])
+		}
+		_, _, err = loadHelmValues([]core.ValuesDescriptor{{URL: tmpFile.Name(), Template: true}}, map[string]string{"ImageTag": "8.0.20"})
+		if err == nil {
+			t.Errorf("Expecting an error templating a values file with missing values")
+		}
+	})
+
+	t.Run("helm-values-cluster-locator", func(t *testing.T) {
+		descriptor := core.ChartDescriptor{Name: "redis", ChartLocator: "bitnami/redis",
+			Values: []core.ValuesDescriptor{{URL: "configmap://paas/redis/values.yaml"}}}
+		chart := NewChart(&descriptor, map[string]string{})
+		if chart.ValuesMap != nil {
+			t.Errorf("Expecting cluster values not loaded before the target is known: %v", chart.ValuesMap)
+		}
+		// The config map is read with the client of the system context, which has none here
+		sc := &core.SystemContext{Name: "fleet", Target: "workload"}
+		if _, err := chart.valuesFor(sc); err == nil || !strings.Contains(err.Error(), "kubernetes client") ||
+			!strings.Contains(err.Error(), "workload") {
+			t.Errorf("Expecting an error reading the config map of the target [%v]", err)
+		}
+	})
+}
+
+func Test_helm_factory(t *testing.T) {
+	t.Run("helm-config-factory", func(t *testing.T) {
+		helmClient := NewHelmClient()
+		helmClient.KubeFactory = &kube.ClientFactory{KubeConfig: "/etc/latimer/kubeconfig", Context: "workload", Impersonate: "deployer"}
+		actionConfig, err := helmClient.newHelmConfig("paas")
+		if err != nil {
+			t.Fatalf("Error creating the helm configuration [%v]", err)
+		}
+		factory, ok := actionConfig.RESTClientGetter.(*kube.ClientFactory)
+		if !ok || factory.KubeConfig != "/etc/latimer/kubeconfig" || factory.Context != "workload" ||
+			factory.Impersonate != "deployer" || factory.Namespace != "paas" {
+			t.Errorf("Expecting the helm configuration to use the kube client factory, got %v", actionConfig.RESTClientGetter)
+		}
+		if helmClient.KubeFactory.Namespace != "" {
+			t.Errorf("Expecting the client factory to be left unchanged")
+		}
+	})
+}
@@ -0,0 +1,61 @@
+package helm
+
+import (
+	"errors"
+	"latimer/core"
+	"latimer/kube"
+
+	"helm.sh/helm/v3/pkg/storage/driver"
+)
+
+const (
+	// InSync is the drift status of a release whose live objects match the release
+	InSync = "in-sync"
+	// Drifted is the drift status of a release whose live objects were modified, deleted or added
+	Drifted = "drifted"
+	// ReleaseMissing is the drift status of a chart without release
+	ReleaseMissing = "missing"
+)
+
+// ReleaseDrift is the drift of the live objects of a release from the release manifest
+type ReleaseDrift struct {
+	Chart       string             `json:"chart"`
+	ReleaseName string             `json:"releaseName"`
+	Namespace   string             `json:"namespace"`
+	Target      string             `json:"target,omitempty"`
+	Status      string             `json:"status"`
+	Objects     []kube.ObjectDrift `json:"objects,omitempty"`
+}
+
+// Drift compares the live objects of the release of the chart with the objects of the release manifest
+func (hc *Chart) Drift(sc *core.SystemContext) (*ReleaseDrift, error) {
+	releaseName := hc.Descriptor.ReleaseName
+	namespace := hc.Descriptor.Namespace
+	drift := &ReleaseDrift{Chart: hc.Name, ReleaseName: releaseName, Namespace: namespace, Target: hc.Descriptor.Target}
+	sc, err := sc.ForTarget(hc.Descriptor.Target)
+	if err != nil {
+		return nil, err
+	}
+	helmClient := NewHelmClient()
+	setTarget(helmClient, sc)
+	release, err := helmClient.Status(releaseName, namespace)
+	if errors.Is(err, driver.ErrReleaseNotFound) || (err == nil && release == nil) {
+		drift.Status = ReleaseMissing
+		return drift, nil
+	} else if err != nil {
+		return nil, err
+	}
+	desired, err := kube.ParseObjects([]byte(release.Manifest))
+	if err != nil {
+		return nil, err
+	}
+	drift.Objects, err = sc.GetKubeClient().DiffObjects(desired, namespace, kube.ReleaseSelectors(releaseName))
+	if err != nil {
+		return nil, err
+	}
+	drift.Status = InSync
+	if len(drift.Objects) > 0 {
+		drift.Status = Drifted
+	}
+	return drift, nil
+}
@@ -0,0 +1,96 @@
+package helm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/clearsign"
+	"gopkg.in/yaml.v2"
+	"helm.sh/helm/v3/pkg/provenance"
+	"k8s.io/client-go/util/homedir"
+)
+
+// Verification is the result of the provenance verification of a chart archive
+type Verification struct {
+	// SignedBy is the key the provenance file is signed with
+	SignedBy *openpgp.Entity
+	// FileName is the name of the archive in the provenance file
+	FileName string
+	// FileHash is the digest of the archive (sha256:<hex>)
+	FileHash string
+}
+
+// verifyProvenance checks the signature of the provenance file of a chart archive (<archive>.prov) against the
+// keyring, and the digest of the archive against the provenance file.  Unlike helm, the archive name is not
+// checked, so archives renamed by the chart cache or a bundle can be verified.
+func verifyProvenance(chartPath string, keyring string) (*Verification, error) {
+	info, err := os.Stat(chartPath)
+	if err != nil {
+		return nil, err
+	} else if info.IsDir() {
+		return nil, errors.New("unpacked charts cannot be verified")
+	}
+	provBytes, err := ioutil.ReadFile(chartPath + ".prov")
+	if err != nil {
+		return nil, fmt.Errorf("could not load provenance file: %v", err)
+	}
+	block, _ := clearsign.Decode(provBytes)
+	if block == nil {
+		return nil, errors.New("provenance file is not signed")
+	}
+	sig, err := provenance.NewFromKeyring(keyring, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to load keyring %v: %v", keyring, err)
+	}
+	signer, err := openpgp.CheckDetachedSignature(sig.KeyRing, bytes.NewBuffer(block.Bytes), block.ArmoredSignature.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	// The signed message holds the chart metadata and the archive digests separated by a yaml document end
+	parts := bytes.Split(block.Plaintext, []byte("\n...\n"))
+	if len(parts) < 2 {
+		return nil, errors.New("provenance message block must have at least two parts")
+	}
+	sums := struct {
+		Files map[string]string `yaml:"files"`
+	}{}
+	if err := yaml.Unmarshal(parts[1], &sums); err != nil {
+		return nil, err
+	}
+	if len(sums.Files) != 1 {
+		return nil, fmt.Errorf("provenance file must hold the digest of a single archive, found %v", len(sums.Files))
+	}
+	digest, err := fileDigest(chartPath)
+	if err != nil {
+		return nil, err
+	}
+	for name, signedDigest := range sums.Files {
+		if signedDigest != digest {
+			return nil, fmt.Errorf("sha256 sum does not match for %v: %q != %q", name, signedDigest, digest)
+		}
+		return &Verification{SignedBy: signer, FileName: name, FileHash: digest}, nil
+	}
+	return nil, errors.New("no archive digest in provenance file")
+}
+
+// signerName returns the name of the signer of a verified chart
+func (v *Verification) signerName() string {
+	for name := range v.SignedBy.Identities {
+		return name
+	}
+	return "unknown"
+}
+
+// defaultKeyring returns the helm default public keyring (~/.gnupg/pubring.gpg)
+func defaultKeyring() string {
+	if v, ok := os.LookupEnv("GNUPGHOME"); ok {
+		return filepath.Join(v, "pubring.gpg")
+	}
+	return filepath.Join(homedir.HomeDir(), ".gnupg", "pubring.gpg")
+}
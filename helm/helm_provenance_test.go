@@ -0,0 +1,134 @@
+package helm
+
+import (
+	"io/ioutil"
+	"latimer/core"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/openpgp"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/provenance"
+)
+
+// writeKeyring generates a signing key and writes its public keyring to the given path
+func writeKeyring(keyringPath string, name string) *openpgp.Entity {
+	entity, err := openpgp.NewEntity(name, "", name+"@example.com", nil)
+	if err != nil {
+		panic(err.Error())
+	}
+	f, err := os.Create(keyringPath)
+	if err != nil {
+		panic(err.Error())
+	}
+	defer f.Close()
+	if err := entity.Serialize(f); err != nil {
+		panic(err.Error())
+	}
+	return entity
+}
+
+func Test_helm_provenance(t *testing.T) {
+	tmpDir, err := ioutil.TempDir(os.TempDir(), "helm-provenance-*")
+	if err != nil {
+		panic(err.Error())
+	}
+	defer os.RemoveAll(tmpDir)
+	chartDir, err := chartutil.Create("sample", tmpDir)
+	if err != nil {
+		panic(err.Error())
+	}
+	sample, err := loader.Load(chartDir)
+	if err != nil {
+		panic(err.Error())
+	}
+	chartPath, err := chartutil.Save(sample, tmpDir)
+	if err != nil {
+		panic(err.Error())
+	}
+	keyring := filepath.Join(tmpDir, "pubring.gpg")
+	entity := writeKeyring(keyring, "latimer-release")
+	signer := &provenance.Signatory{Entity: entity, KeyRing: openpgp.EntityList{entity}}
+	prov, err := signer.ClearSign(chartPath)
+	if err != nil {
+		panic(err.Error())
+	}
+	if err := ioutil.WriteFile(chartPath+".prov", []byte(prov), 0644); err != nil {
+		panic(err.Error())
+	}
+
+	t.Run("helm-provenance-verify", func(t *testing.T) {
+		verification, err := verifyProvenance(chartPath, keyring)
+		if err != nil {
+			t.Fatalf("Error verifying chart %v [%v]", chartPath, err)
+		}
+		if verification.signerName() != "latimer-release <latimer-release@example.com>" || verification.FileName != "sample-0.1.0.tgz" {
+			t.Errorf("Unexpected verification %v", verification)
+		}
+
+		// Archives renamed by the cache keep verifying
+		renamed := filepath.Join(tmpDir, "sha256-renamed.tgz")
+		if err := copyFile(chartPath, renamed); err != nil {
+			panic(err.Error())
+		}
+		if err := copyFile(chartPath+".prov", renamed+".prov"); err != nil {
+			panic(err.Error())
+		}
+		if _, err := verifyProvenance(renamed, keyring); err != nil {
+			t.Errorf("Error verifying renamed chart %v [%v]", renamed, err)
+		}
+
+		otherKeyring := filepath.Join(tmpDir, "other.gpg")
+		writeKeyring(otherKeyring, "someone-else")
+		if _, err := verifyProvenance(chartPath, otherKeyring); err == nil {
+			t.Errorf("Expecting an error verifying with a keyring without the signer")
+		}
+	})
+
+	t.Run("helm-provenance-tampered", func(t *testing.T) {
+		tampered := filepath.Join(tmpDir, "tampered", "sample-0.1.0.tgz")
+		os.MkdirAll(filepath.Dir(tampered), 0755)
+		archive, err := ioutil.ReadFile(chartPath)
+		if err != nil {
+			panic(err.Error())
+		}
+		if err := ioutil.WriteFile(tampered, append(archive, 0), 0644); err != nil {
+			panic(err.Error())
+		}
+		if err := copyFile(chartPath+".prov", tampered+".prov"); err != nil {
+			panic(err.Error())
+		}
+		helmClient := NewHelmClient()
+		helmClient.Verify = true
+		helmClient.Keyring = keyring
+		if _, err := helmClient.loadChart("file://" + chartPath); err != nil {
+			t.Errorf("Error loading signed chart [%v]", err)
+		}
+		if _, err := helmClient.loadChart("file://" + tampered); err == nil || !strings.Contains(err.Error(), "sha256 sum does not match") {
+			t.Errorf("Expecting a digest mismatch loading a tampered chart [%v]", err)
+		}
+		if _, err := helmClient.loadChart("file://" + chartDir); err == nil {
+			t.Errorf("Expecting an error verifying an unpacked chart")
+		}
+	})
+
+	t.Run("helm-provenance-lock", func(t *testing.T) {
+		descriptor := core.ChartDescriptor{Name: "sample", ChartLocator: "file://" + chartPath, Verify: true}
+		chart := NewChart(&descriptor, map[string]string{})
+		sc := &core.SystemContext{Context: &core.LatimerContext{Keyring: keyring, Lock: &core.LockFile{Manifest: "sample"}}}
+		if _, err := chart.helmClientFor(sc); err == nil {
+			t.Errorf("Expecting an error for a verified chart missing from the lock file")
+		}
+		sc.Context.Lock.Charts = []core.LockedChart{{Name: "sample", ChartLocator: descriptor.ChartLocator, Version: "0.1.0", Digest: "sha256:0000"}}
+		helmClient, err := chart.helmClientFor(sc)
+		if err != nil || !helmClient.Verify || helmClient.Keyring != keyring {
+			t.Fatalf("Unexpected helm client for a verified chart %v [%v]", helmClient, err)
+		}
+		if _, err := helmClient.loadChart(descriptor.ChartLocator); err == nil || !strings.Contains(err.Error(), "Digest mismatch") {
+			t.Errorf("Expecting a digest mismatch against the lock file [%v]", err)
+		}
+	})
+}
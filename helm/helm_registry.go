@@ -0,0 +1,451 @@
+package helm
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"latimer/core"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// OCIScheme is the locator prefix of charts stored in OCI registries (eg oci://registry.example.com/charts/redis:10.7.9)
+	OCIScheme = "oci://"
+
+	ociManifestMediaType = "application/vnd.oci.image.manifest.v1+json"
+	// Timeout of each request to a registry
+	registryRequestTimeout = 120 * time.Second
+)
+
+// helmChartLayerMediaTypes are the media types of the chart archive layer (helm 3.0-3.6 and later versions)
+var helmChartLayerMediaTypes = []string{
+	"application/tar+gzip",
+	"application/vnd.cncf.helm.chart.content.v1.tar+gzip",
+}
+
+// ociReference identifies a chart in an OCI registry
+type ociReference struct {
+	Host       string
+	Repository string
+	Tag        string
+}
+
+// ociDescriptor describes a blob of an OCI manifest
+type ociDescriptor struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+}
+
+// ociManifest is an OCI image manifest holding a helm chart
+type ociManifest struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	Config        ociDescriptor   `json:"config"`
+	Layers        []ociDescriptor `json:"layers"`
+}
+
+// registryClient issues requests against the OCI distribution API of a registry
+type registryClient struct {
+	baseURL    string
+	username   string
+	password   string
+	realmHosts []string
+	basicAuth  bool
+	token      string
+	httpClient *http.Client
+}
+
+// parseOCIReference parses a chart locator of the form oci://host[:port]/repository[:tag]
+func parseOCIReference(chartRef string) (*ociReference, error) {
+	ref := strings.TrimPrefix(chartRef, OCIScheme)
+	slash := strings.Index(ref, "/")
+	if slash <= 0 || slash == len(ref)-1 {
+		return nil, fmt.Errorf("Invalid OCI chart reference %v, expecting %vhost/repository[:tag]", chartRef, OCIScheme)
+	}
+	ociRef := &ociReference{Host: ref[:slash], Repository: ref[slash+1:]}
+	if colon := strings.LastIndex(ociRef.Repository, ":"); colon > strings.LastIndex(ociRef.Repository, "/") {
+		ociRef.Tag = ociRef.Repository[colon+1:]
+		ociRef.Repository = ociRef.Repository[:colon]
+	}
+	return ociRef, nil
+}
+
+// pullOCI pulls a chart from an OCI registry and saves it into the output directory.  Without a tag in the
+// reference, the highest tag satisfying the version constraint of the client is used.  Returns the file name.
+func (hc *HelmClient) pullOCI(chartRef string, outDir string) (string, error) {
+	ociRef, err := parseOCIReference(chartRef)
+	if err != nil {
+		return "", err
+	}
+	rc, err := hc.newRegistryClient(ociRef.Host)
+	if err != nil {
+		return "", err
+	}
+	tag := ociRef.Tag
+	if tag == "" {
+		tags, err := rc.tags(ociRef.Repository)
+		if err != nil {
+			return "", err
+		}
+		tag, err = highestTag(tags, hc.Version)
+		if err != nil {
+			return "", fmt.Errorf("Chart %v: %v", chartRef, err)
+		}
+	}
+	manifest, err := rc.manifest(ociRef.Repository, tag)
+	if err != nil {
+		return "", err
+	}
+	for _, layer := range manifest.Layers {
+		for _, mediaType := range helmChartLayerMediaTypes {
+			if layer.MediaType != mediaType {
+				continue
+			}
+			name := filepath.Base(ociRef.Repository) + "-" + tag + ".tgz"
+			outPath := filepath.Join(outDir, name)
+			if err := rc.fetchBlob(ociRef.Repository, layer.Digest, outPath); err != nil {
+				return "", err
+			}
+			logrus.Infof("Pulled chart %v:%v [%v]", chartRef, tag, layer.Digest)
+			return outPath, nil
+		}
+	}
+	return "", fmt.Errorf("No helm chart layer found in %v:%v", chartRef, tag)
+}
+
+// highestTag returns the highest semver tag satisfying the constraint (any if empty)
+func highestTag(tags []string, constraint string) (string, error) {
+	versions := make([]*semver.Version, 0)
+	versionTags := map[*semver.Version]string{}
+	for _, tag := range tags {
+		// OCI tags cannot contain '+', helm replaces it with '_'
+		v, err := semver.NewVersion(strings.Replace(tag, "_", "+", -1))
+		if err != nil {
+			continue
+		}
+		if ok, err := core.SatisfiesConstraint(v.String(), constraint); err != nil {
+			return "", err
+		} else if ok {
+			versions = append(versions, v)
+			versionTags[v] = tag
+		}
+	}
+	if len(versions) == 0 {
+		return "", fmt.Errorf("No tag satisfies version constraint [%v] in %v", constraint, tags)
+	}
+	sort.Sort(semver.Collection(versions))
+	return versionTags[versions[len(versions)-1]], nil
+}
+
+// newRegistryClient creates a registry client for the host, with the credentials of the matching oci://
+// repository of the client, or else the ones of the docker config
+func (hc *HelmClient) newRegistryClient(host string) (*registryClient, error) {
+	rc := &registryClient{baseURL: "https://" + host}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	registry := hc.registryFor(host)
+	if registry != nil {
+		var err error
+		rc.username, rc.password, err = registry.Credentials()
+		if err != nil {
+			return nil, err
+		}
+		if registry.PlainHTTP {
+			rc.baseURL = "http://" + host
+		}
+		rc.realmHosts = registry.TokenRealmHosts
+		tlsConfig := &tls.Config{InsecureSkipVerify: registry.InsecureSkipTLSVerify}
+		if registry.CAFile != "" {
+			caBytes, err := ioutil.ReadFile(registry.CAFile)
+			if err != nil {
+				return nil, err
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(caBytes) {
+				return nil, fmt.Errorf("No certificates found in CA file %v", registry.CAFile)
+			}
+			tlsConfig.RootCAs = pool
+		}
+		if registry.CertFile != "" && registry.KeyFile != "" {
+			cert, err := tls.LoadX509KeyPair(registry.CertFile, registry.KeyFile)
+			if err != nil {
+				return nil, err
+			}
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+	if rc.username == "" && rc.password == "" {
+		rc.username, rc.password = dockerConfigCredentials(host)
+	}
+	rc.httpClient = &http.Client{Transport: transport, Timeout: registryRequestTimeout}
+	return rc, nil
+}
+
+// registryFor returns the oci:// repository of the client for the host, or nil if not found
+func (hc *HelmClient) registryFor(host string) *core.RepositoryDescriptor {
+	for idx := range hc.Registries {
+		r := &hc.Registries[idx]
+		if strings.TrimSuffix(strings.TrimPrefix(r.URL, OCIScheme), "/") == host {
+			return r
+		}
+	}
+	return nil
+}
+
+// dockerConfigCredentials returns the credentials of the host from the docker config file, if any
+func dockerConfigCredentials(host string) (string, string) {
+	configDir := os.Getenv("DOCKER_CONFIG")
+	if configDir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", ""
+		}
+		configDir = filepath.Join(home, ".docker")
+	}
+	configBytes, err := ioutil.ReadFile(filepath.Join(configDir, "config.json"))
+	if err != nil {
+		return "", ""
+	}
+	dockerConfig := struct {
+		Auths map[string]struct {
+			Auth string `json:"auth"`
+		} `json:"auths"`
+	}{}
+	if err := json.Unmarshal(configBytes, &dockerConfig); err != nil {
+		logrus.Warningf("Error parsing docker config in %v [%v]", configDir, err)
+		return "", ""
+	}
+	for _, key := range []string{host, "https://" + host, "http://" + host} {
+		if entry, found := dockerConfig.Auths[key]; found {
+			decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+			if err != nil {
+				return "", ""
+			}
+			userPass := strings.SplitN(string(decoded), ":", 2)
+			if len(userPass) == 2 {
+				return userPass[0], userPass[1]
+			}
+		}
+	}
+	return "", ""
+}
+
+// tags returns the tags of a repository
+func (rc *registryClient) tags(repository string) ([]string, error) {
+	resp, err := rc.get("/v2/"+repository+"/tags/list", "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	tagList := struct {
+		Tags []string `json:"tags"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&tagList); err != nil {
+		return nil, err
+	}
+	return tagList.Tags, nil
+}
+
+// manifest returns the OCI manifest of a repository tag
+func (rc *registryClient) manifest(repository string, tag string) (*ociManifest, error) {
+	resp, err := rc.get("/v2/"+repository+"/manifests/"+tag, ociManifestMediaType)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	manifest := new(ociManifest)
+	if err := json.NewDecoder(resp.Body).Decode(manifest); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// fetchBlob saves a blob of a repository to the output path, verifying its digest
+func (rc *registryClient) fetchBlob(repository string, digest string, outPath string) error {
+	if !strings.HasPrefix(digest, "sha256:") {
+		return fmt.Errorf("Unsupported blob digest %v", digest)
+	}
+	resp, err := rc.get("/v2/"+repository+"/blobs/"+digest, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	f, err := os.Create(outPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(f, h), resp.Body); err != nil {
+		return err
+	}
+	if actual := "sha256:" + hex.EncodeToString(h.Sum(nil)); actual != digest {
+		return fmt.Errorf("Digest mismatch for blob %v of %v: got %v", digest, repository, actual)
+	}
+	return nil
+}
+
+// get issues a GET request against the registry, authenticating on a Basic or Bearer challenge
+func (rc *registryClient) get(path string, accept string) (*http.Response, error) {
+	resp, err := rc.doGet(path, accept)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		challenge := resp.Header.Get("WWW-Authenticate")
+		resp.Body.Close()
+		if err := rc.authenticate(challenge); err != nil {
+			return nil, err
+		}
+		resp, err = rc.doGet(path, accept)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("Registry request %v%v failed: %v", rc.baseURL, path, resp.Status)
+	}
+	return resp, nil
+}
+
+// doGet issues a single GET request with the credentials of the last challenge answered, if any
+func (rc *registryClient) doGet(path string, accept string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, rc.baseURL+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	if rc.token != "" {
+		req.Header.Set("Authorization", "Bearer "+rc.token)
+	} else if rc.basicAuth {
+		req.SetBasicAuth(rc.username, rc.password)
+	}
+	return rc.httpClient.Do(req)
+}
+
+// authenticate answers an authentication challenge, with the basic credentials or a bearer token fetched
+// from the realm.  The credentials are only sent to a realm on the registry host or on a trusted realm host.
+func (rc *registryClient) authenticate(challenge string) error {
+	hasCredentials := rc.username != "" || rc.password != ""
+	if strings.HasPrefix(challenge, "Basic") {
+		if rc.basicAuth || !hasCredentials {
+			return errors.New("Registry authentication failed for " + rc.baseURL)
+		}
+		rc.basicAuth = true
+		return nil
+	}
+	if rc.token != "" || !strings.HasPrefix(challenge, "Bearer ") {
+		return errors.New("Registry authentication failed for " + rc.baseURL)
+	}
+	params := parseChallenge(strings.TrimPrefix(challenge, "Bearer "))
+	realm, err := url.Parse(params["realm"])
+	if err != nil || params["realm"] == "" || realm.Host == "" {
+		return fmt.Errorf("Invalid registry authentication challenge: %v", challenge)
+	}
+	query := realm.Query()
+	for _, key := range []string{"service", "scope"} {
+		if params[key] != "" {
+			query.Set(key, params[key])
+		}
+	}
+	realm.RawQuery = query.Encode()
+	req, err := http.NewRequest(http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return err
+	}
+	if hasCredentials {
+		if rc.trustsRealm(realm.Host) {
+			req.SetBasicAuth(rc.username, rc.password)
+		} else {
+			logrus.Warningf("Not sending the credentials of %v to token realm %v, add it to the tokenRealmHosts of the registry to trust it",
+				rc.baseURL, realm.Host)
+		}
+	}
+	resp, err := rc.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Registry token request to %v failed: %v", realm.Host, resp.Status)
+	}
+	tokenResp := struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&tokenResp); err != nil {
+		return err
+	}
+	rc.token = tokenResp.Token
+	if rc.token == "" {
+		rc.token = tokenResp.AccessToken
+	}
+	if rc.token == "" {
+		return fmt.Errorf("No token returned by %v", realm.Host)
+	}
+	return nil
+}
+
+// trustsRealm returns whether the credentials of the registry can be sent to the realm host
+func (rc *registryClient) trustsRealm(realmHost string) bool {
+	registryURL, err := url.Parse(rc.baseURL)
+	if err != nil {
+		return false
+	}
+	if strings.EqualFold(realmHost, registryURL.Host) {
+		return true
+	}
+	for _, host := range rc.realmHosts {
+		if strings.EqualFold(realmHost, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseChallenge parses the key="value" parameters of an authentication challenge (values may hold commas)
+func parseChallenge(params string) map[string]string {
+	result := map[string]string{}
+	for params != "" {
+		eq := strings.Index(params, "=")
+		if eq < 0 {
+			break
+		}
+		key := strings.TrimSpace(strings.TrimLeft(params[:eq], ", "))
+		params = params[eq+1:]
+		value := ""
+		if strings.HasPrefix(params, "\"") {
+			end := strings.Index(params[1:], "\"")
+			if end < 0 {
+				value, params = params[1:], ""
+			} else {
+				value, params = params[1:end+1], params[end+2:]
+			}
+		} else if comma := strings.Index(params, ","); comma >= 0 {
+			value, params = params[:comma], params[comma:]
+		} else {
+			value, params = params, ""
+		}
+		result[key] = value
+	}
+	return result
+}
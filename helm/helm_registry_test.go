@@ -0,0 +1,229 @@
+package helm
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"latimer/core"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+)
+
+// newRegistryStandIn serves a chart archive under charts/sample with a few tags, requiring a bearer token
+// obtained with basic credentials.  Returns the server and the list of manifest tags requested.
+func newRegistryStandIn(t *testing.T, chartPath string) (*httptest.Server, *[]string) {
+	chartBytes, err := ioutil.ReadFile(chartPath)
+	if err != nil {
+		t.Fatalf("Error reading chart archive %v [%v]", chartPath, err)
+	}
+	sum := sha256.Sum256(chartBytes)
+	digest := "sha256:" + hex.EncodeToString(sum[:])
+	requestedTags := make([]string, 0)
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			user, password, ok := r.BasicAuth()
+			if !ok || user != "latimer" || password != "secret" || r.URL.Query().Get("scope") != "repository:charts/sample:pull" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte(`{"token": "test-token"}`))
+			return
+		}
+		if strings.HasPrefix(r.Header.Get("Authorization"), "Basic ") {
+			t.Errorf("Basic credentials sent to the registry instead of the token realm")
+		}
+		if r.Header.Get("Authorization") != "Bearer test-token" {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%v/token",service="stand-in",scope="repository:charts/sample:pull"`, server.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case r.URL.Path == "/v2/charts/sample/tags/list":
+			w.Write([]byte(`{"name": "charts/sample", "tags": ["0.1.0", "0.2.0", "1.0.0", "latest"]}`))
+		case strings.HasPrefix(r.URL.Path, "/v2/charts/sample/manifests/"):
+			requestedTags = append(requestedTags, strings.TrimPrefix(r.URL.Path, "/v2/charts/sample/manifests/"))
+			manifest := ociManifest{
+				SchemaVersion: 2,
+				Config:        ociDescriptor{MediaType: "application/vnd.cncf.helm.config.v1+json", Digest: "sha256:00", Size: 2},
+				Layers:        []ociDescriptor{{MediaType: "application/tar+gzip", Digest: digest, Size: int64(len(chartBytes))}},
+			}
+			json.NewEncoder(w).Encode(manifest)
+		case r.URL.Path == "/v2/charts/sample/blobs/"+digest:
+			w.Write(chartBytes)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	return server, &requestedTags
+}
+
+func Test_helm_registry(t *testing.T) {
+	tmpDir, err := ioutil.TempDir(os.TempDir(), "helm-oci-*")
+	if err != nil {
+		panic(err.Error())
+	}
+	defer os.RemoveAll(tmpDir)
+	chartDir, err := chartutil.Create("sample", tmpDir)
+	if err != nil {
+		panic(err.Error())
+	}
+	sample, err := loader.Load(chartDir)
+	if err != nil {
+		panic(err.Error())
+	}
+	chartPath, err := chartutil.Save(sample, tmpDir)
+	if err != nil {
+		panic(err.Error())
+	}
+	server, requestedTags := newRegistryStandIn(t, chartPath)
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	t.Run("helm-registry-parse-reference", func(t *testing.T) {
+		ref, err := parseOCIReference("oci://localhost:5000/charts/sample:0.1.0")
+		if err != nil || ref.Host != "localhost:5000" || ref.Repository != "charts/sample" || ref.Tag != "0.1.0" {
+			t.Errorf("Unexpected OCI reference: %v [%v]", ref, err)
+		}
+		ref, err = parseOCIReference("oci://localhost:5000/charts/sample")
+		if err != nil || ref.Repository != "charts/sample" || ref.Tag != "" {
+			t.Errorf("Unexpected OCI reference: %v [%v]", ref, err)
+		}
+	})
+
+	t.Run("helm-registry-load-chart", func(t *testing.T) {
+		os.Setenv("LATIMER_TEST_REGISTRY_USER", "latimer")
+		os.Setenv("LATIMER_TEST_REGISTRY_PASSWORD", "secret")
+		defer os.Unsetenv("LATIMER_TEST_REGISTRY_USER")
+		defer os.Unsetenv("LATIMER_TEST_REGISTRY_PASSWORD")
+
+		helmClient := NewHelmClient()
+		helmClient.Version = "<1.0.0"
+		helmClient.Registries = []core.RepositoryDescriptor{{
+			Name:        "stand-in",
+			URL:         "oci://" + host,
+			UsernameEnv: "LATIMER_TEST_REGISTRY_USER",
+			PasswordEnv: "LATIMER_TEST_REGISTRY_PASSWORD",
+			PlainHTTP:   true,
+		}}
+
+		chart, err := helmClient.loadChart("oci://" + host + "/charts/sample")
+		if err != nil {
+			t.Fatalf("Error loading chart from registry [%v]", err)
+		}
+		if chart.Metadata.Name != "sample" {
+			t.Errorf("Unexpected chart loaded: %v", chart.Metadata)
+		}
+		if len(*requestedTags) != 1 || (*requestedTags)[0] != "0.2.0" {
+			t.Errorf("Expecting highest tag satisfying constraint (0.2.0), requested: %v", *requestedTags)
+		}
+	})
+
+	t.Run("helm-registry-unauthorized", func(t *testing.T) {
+		helmClient := NewHelmClient()
+		helmClient.Registries = []core.RepositoryDescriptor{{Name: "stand-in", URL: "oci://" + host, PlainHTTP: true}}
+		os.Setenv("DOCKER_CONFIG", tmpDir)
+		defer os.Unsetenv("DOCKER_CONFIG")
+		if _, err := helmClient.loadChart("oci://" + host + "/charts/sample:0.1.0"); err == nil {
+			t.Errorf("Expecting error pulling chart without credentials")
+		}
+	})
+
+	t.Run("helm-registry-basic-challenge", func(t *testing.T) {
+		authorizations := make([]string, 0)
+		basicServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			authorizations = append(authorizations, r.Header.Get("Authorization"))
+			if user, password, ok := r.BasicAuth(); !ok || user != "latimer" || password != "secret" {
+				w.Header().Set("WWW-Authenticate", `Basic realm="stand-in"`)
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte(`{"name": "charts/sample", "tags": ["0.1.0"]}`))
+		}))
+		defer basicServer.Close()
+		basicHost := strings.TrimPrefix(basicServer.URL, "http://")
+
+		helmClient := NewHelmClient()
+		helmClient.Registries = []core.RepositoryDescriptor{{Name: "basic", URL: "oci://" + basicHost, PlainHTTP: true,
+			CredentialsFile: writeCredentials(t, tmpDir)}}
+		rc, err := helmClient.newRegistryClient(basicHost)
+		if err != nil {
+			t.Fatalf("Error creating registry client [%v]", err)
+		}
+		tags, err := rc.tags("charts/sample")
+		if err != nil || len(tags) != 1 {
+			t.Fatalf("Unexpected tags %v [%v]", tags, err)
+		}
+		if len(authorizations) != 2 || authorizations[0] != "" {
+			t.Errorf("Expecting basic credentials only in response to the challenge, got %v", authorizations)
+		}
+	})
+
+	t.Run("helm-registry-foreign-realm", func(t *testing.T) {
+		realmAuthorizations := make([]string, 0)
+		realmServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			realmAuthorizations = append(realmAuthorizations, r.Header.Get("Authorization"))
+			if user, password, ok := r.BasicAuth(); !ok || user != "latimer" || password != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte(`{"access_token": "realm-token"}`))
+		}))
+		defer realmServer.Close()
+		registryServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer realm-token" {
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%v/token",service="stand-in"`, realmServer.URL))
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte(`{"name": "charts/sample", "tags": ["0.1.0"]}`))
+		}))
+		defer registryServer.Close()
+		registryHost := strings.TrimPrefix(registryServer.URL, "http://")
+		realmHost := strings.TrimPrefix(realmServer.URL, "http://")
+
+		registry := core.RepositoryDescriptor{Name: "foreign", URL: "oci://" + registryHost, PlainHTTP: true,
+			CredentialsFile: writeCredentials(t, tmpDir)}
+		helmClient := NewHelmClient()
+		helmClient.Registries = []core.RepositoryDescriptor{registry}
+		rc, err := helmClient.newRegistryClient(registryHost)
+		if err != nil {
+			t.Fatalf("Error creating registry client [%v]", err)
+		}
+		if _, err := rc.tags("charts/sample"); err == nil {
+			t.Errorf("Expecting error with a token realm on an untrusted host")
+		}
+		if len(realmAuthorizations) != 1 || realmAuthorizations[0] != "" {
+			t.Errorf("Expecting no credentials sent to the untrusted realm, got %v", realmAuthorizations)
+		}
+
+		registry.TokenRealmHosts = []string{realmHost}
+		helmClient.Registries = []core.RepositoryDescriptor{registry}
+		rc, err = helmClient.newRegistryClient(registryHost)
+		if err != nil {
+			t.Fatalf("Error creating registry client [%v]", err)
+		}
+		if tags, err := rc.tags("charts/sample"); err != nil || len(tags) != 1 {
+			t.Errorf("Unexpected tags %v with a trusted token realm [%v]", tags, err)
+		}
+	})
+}
+
+// writeCredentials writes the credentials of the registry stand-ins to a file of the directory
+func writeCredentials(t *testing.T, dir string) string {
+	credentialsPath := filepath.Join(dir, "credentials.yaml")
+	if err := ioutil.WriteFile(credentialsPath, []byte("username: latimer\npassword: secret\n"), 0600); err != nil {
+		t.Fatalf("Error writing credentials [%v]", err)
+	}
+	return credentialsPath
+}
@@ -0,0 +1,110 @@
+package helm
+
+import (
+	"fmt"
+	"latimer/core"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"helm.sh/helm/v3/pkg/getter"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+// UpdateRepositories adds the repositories to the repositories file of the client and downloads their index.
+// Repositories already present with the same settings are only re-indexed when refresh is set.  OCI registries
+// are skipped.  Credentials are never written to the repositories file, they are passed to the pulls in memory
+// (see Repositories).
+func (hc *HelmClient) UpdateRepositories(repositories []core.RepositoryDescriptor, refresh bool) error {
+	settings := hc.settings()
+	repoFile, err := hc.loadRepositoryFile()
+	if err != nil {
+		return err
+	}
+	changed := false
+	for idx := range repositories {
+		if repositories[idx].IsRegistry() {
+			// OCI registries have no index, their settings are used when pulling charts
+			continue
+		}
+		entry, err := newRepositoryEntry(&repositories[idx])
+		if err != nil {
+			return err
+		}
+		existing := repoFile.Get(entry.Name)
+		if existing != nil && *existing == *entry && !refresh {
+			logrus.Debugf("Repository %v is up to date", entry.Name)
+			continue
+		}
+		authEntry := *entry
+		authEntry.Username, authEntry.Password, err = repositories[idx].Credentials()
+		if err != nil {
+			return err
+		}
+		chartRepo, err := repo.NewChartRepository(&authEntry, getter.All(settings))
+		if err != nil {
+			return err
+		}
+		chartRepo.CachePath = settings.RepositoryCache
+		if _, err := chartRepo.DownloadIndexFile(); err != nil {
+			return fmt.Errorf("Repository %v at %v is not reachable: %v", entry.Name, entry.URL, err)
+		}
+		repoFile.Update(entry)
+		changed = true
+		logrus.Infof("Repository %v [%v] updated", entry.Name, entry.URL)
+	}
+	if !changed {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(settings.RepositoryConfig), 0755); err != nil {
+		return err
+	}
+	// Entries added by hand may hold credentials
+	return repoFile.WriteFile(settings.RepositoryConfig, 0600)
+}
+
+// ListRepositories returns the repositories configured in the repositories file of the client
+func (hc *HelmClient) ListRepositories() ([]*repo.Entry, error) {
+	repoFile, err := hc.loadRepositoryFile()
+	if err != nil {
+		return nil, err
+	}
+	return repoFile.Repositories, nil
+}
+
+// loadRepositoryFile loads the repositories file of the client, or an empty one if it does not exist yet
+func (hc *HelmClient) loadRepositoryFile() (*repo.File, error) {
+	repoConfig := hc.settings().RepositoryConfig
+	if _, err := os.Stat(repoConfig); os.IsNotExist(err) {
+		return repo.NewFile(), nil
+	}
+	return repo.LoadFile(repoConfig)
+}
+
+// newRepositoryEntry converts a repository descriptor into a helm repositories file entry, without credentials
+func newRepositoryEntry(r *core.RepositoryDescriptor) (*repo.Entry, error) {
+	if r.Name == "" || r.URL == "" {
+		return nil, fmt.Errorf("Repository requires both a name and a url: %v", r)
+	}
+	return &repo.Entry{
+		Name:                  r.Name,
+		URL:                   r.URL,
+		CAFile:                r.CAFile,
+		CertFile:              r.CertFile,
+		KeyFile:               r.KeyFile,
+		InsecureSkipTLSverify: r.InsecureSkipTLSVerify,
+	}, nil
+}
+
+// repositoryCredentials returns the credentials of the declared repository the chart is pulled from (eg
+// internal/api), empty if none
+func (hc *HelmClient) repositoryCredentials(chartRef string) (string, string, error) {
+	name := strings.SplitN(chartRef, "/", 2)[0]
+	for idx := range hc.Repositories {
+		if r := &hc.Repositories[idx]; r.Name == name && !r.IsRegistry() {
+			return r.Credentials()
+		}
+	}
+	return "", "", nil
+}
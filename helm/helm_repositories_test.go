@@ -0,0 +1,116 @@
+package helm
+
+import (
+	"io/ioutil"
+	"latimer/core"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+func Test_helm_repositories(t *testing.T) {
+	tmpDir, err := ioutil.TempDir(os.TempDir(), "helm-repo-*")
+	if err != nil {
+		panic(err.Error())
+	}
+	defer os.RemoveAll(tmpDir)
+	chartDir, err := chartutil.Create("sample", tmpDir)
+	if err != nil {
+		panic(err.Error())
+	}
+	sample, err := loader.Load(chartDir)
+	if err != nil {
+		panic(err.Error())
+	}
+	archivePath, err := chartutil.Save(sample, tmpDir)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	index := repo.NewIndexFile()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, ok := r.BasicAuth()
+		if !ok || user != "latimer" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path == "/index.yaml" {
+			http.ServeFile(w, r, filepath.Join(tmpDir, "index.yaml"))
+			return
+		}
+		http.ServeFile(w, r, archivePath)
+	}))
+	defer server.Close()
+	index.Add(sample.Metadata, filepath.Base(archivePath), server.URL, "")
+	if err := index.WriteFile(filepath.Join(tmpDir, "index.yaml"), 0644); err != nil {
+		panic(err.Error())
+	}
+
+	helmClient := NewHelmClient()
+	helmClient.RepositoryConfig = filepath.Join(tmpDir, "repositories.yaml")
+	helmClient.RepositoryCache = filepath.Join(tmpDir, "cache")
+	repositories := []core.RepositoryDescriptor{{
+		Name:        "internal",
+		URL:         server.URL,
+		UsernameEnv: "LATIMER_TEST_REPO_USER",
+		PasswordEnv: "LATIMER_TEST_REPO_PASSWORD",
+	}}
+
+	t.Run("helm-repositories-unauthorized", func(t *testing.T) {
+		if err := helmClient.UpdateRepositories(repositories, true); err == nil {
+			t.Errorf("Expecting error adding repository without credentials")
+		}
+	})
+
+	t.Run("helm-repositories-update", func(t *testing.T) {
+		os.Setenv("LATIMER_TEST_REPO_USER", "latimer")
+		os.Setenv("LATIMER_TEST_REPO_PASSWORD", "secret")
+		defer os.Unsetenv("LATIMER_TEST_REPO_USER")
+		defer os.Unsetenv("LATIMER_TEST_REPO_PASSWORD")
+
+		if err := helmClient.UpdateRepositories(repositories, false); err != nil {
+			t.Errorf("Error adding repository [%v]", err)
+		}
+		entries, err := helmClient.ListRepositories()
+		if err != nil {
+			t.Errorf("Error listing repositories [%v]", err)
+		}
+		if len(entries) != 1 || entries[0].Name != "internal" || entries[0].Username != "" || entries[0].Password != "" {
+			t.Errorf("Unexpected repositories: %v", entries)
+		}
+		repoBytes, err := ioutil.ReadFile(helmClient.RepositoryConfig)
+		if err != nil || strings.Contains(string(repoBytes), "secret") {
+			t.Errorf("Expecting no credentials in the repositories file [%v]:\n%v", err, string(repoBytes))
+		}
+		if _, err := os.Stat(filepath.Join(helmClient.RepositoryCache, "internal-index.yaml")); err != nil {
+			t.Errorf("Expecting repository index in cache [%v]", err)
+		}
+		t.Logf("Repository %v added: %v\n", entries[0].Name, entries[0].URL)
+	})
+
+	t.Run("helm-repositories-pull", func(t *testing.T) {
+		os.Setenv("LATIMER_TEST_REPO_USER", "latimer")
+		os.Setenv("LATIMER_TEST_REPO_PASSWORD", "secret")
+		defer os.Unsetenv("LATIMER_TEST_REPO_USER")
+		defer os.Unsetenv("LATIMER_TEST_REPO_PASSWORD")
+
+		pullDir := filepath.Join(tmpDir, "pull")
+		if err := os.MkdirAll(pullDir, 0755); err != nil {
+			panic(err.Error())
+		}
+		if _, err := helmClient.Pull("internal/sample", pullDir); err == nil {
+			t.Errorf("Expecting error pulling without the credentials of the repository")
+		}
+		helmClient.Repositories = repositories
+		if _, err := helmClient.Pull("internal/sample", pullDir); err != nil {
+			t.Errorf("Error pulling chart with the credentials of the repository [%v]", err)
+		}
+	})
+}
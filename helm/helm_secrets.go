@@ -0,0 +1,168 @@
+package helm
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// EncryptedValuesHeader is the first line of a values file encrypted in the latimer format.  The following
+	// lines hold the base64 encoded AES-256-GCM nonce and ciphertext of the file contents.
+	EncryptedValuesHeader = "$LATIMER;AES256-GCM;v1"
+	// ValuesKeyEnvVar is the environment variable holding the base64 encoded 256 bit key of encrypted values files
+	ValuesKeyEnvVar = "LATIMER_VALUES_KEY"
+	// ValuesKeyFileEnvVar is the environment variable holding the path of a file with the base64 encoded key of
+	// encrypted values files (used if LATIMER_VALUES_KEY is not set)
+	ValuesKeyFileEnvVar = "LATIMER_VALUES_KEY_FILE"
+	// Width of the base64 lines of an encrypted values file
+	encryptedLineWidth = 76
+)
+
+// decryptValues returns the decrypted contents of a values file encrypted in the latimer or sops format, and
+// whether it was encrypted.  Plain values files are returned as is.  Decrypted contents are only kept in memory.
+func decryptValues(name string, content []byte) ([]byte, bool, error) {
+	if isLatimerEncrypted(content) {
+		key, err := ValuesKey()
+		if err != nil {
+			return nil, true, fmt.Errorf("Cannot decrypt values file %v: %v", name, err)
+		}
+		plain, err := DecryptValues(content, key)
+		if err != nil {
+			return nil, true, fmt.Errorf("Cannot decrypt values file %v: %v", name, err)
+		}
+		return plain, true, nil
+	}
+	if isSopsEncrypted(content) {
+		plain, err := decryptSops(content)
+		if err != nil {
+			return nil, true, fmt.Errorf("Cannot decrypt sops values file %v: %v", name, err)
+		}
+		return plain, true, nil
+	}
+	return content, false, nil
+}
+
+// ValuesKey returns the key of encrypted values files from the environment
+func ValuesKey() ([]byte, error) {
+	encoded := os.Getenv(ValuesKeyEnvVar)
+	if encoded == "" {
+		keyFile := os.Getenv(ValuesKeyFileEnvVar)
+		if keyFile == "" {
+			return nil, fmt.Errorf("No key for encrypted values, set %v or %v", ValuesKeyEnvVar, ValuesKeyFileEnvVar)
+		}
+		keyBytes, err := ioutil.ReadFile(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		encoded = string(keyBytes)
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid values key: %v", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("Invalid values key: expected 32 bytes, got %v", len(key))
+	}
+	return key, nil
+}
+
+// EncryptValues encrypts the contents of a values file in the latimer format
+func EncryptValues(content []byte, key []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	encoded := base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, content, []byte(EncryptedValuesHeader)))
+	var b bytes.Buffer
+	b.WriteString(EncryptedValuesHeader + "\n")
+	for len(encoded) > encryptedLineWidth {
+		b.WriteString(encoded[:encryptedLineWidth] + "\n")
+		encoded = encoded[encryptedLineWidth:]
+	}
+	b.WriteString(encoded + "\n")
+	return b.Bytes(), nil
+}
+
+// DecryptValues decrypts the contents of a values file encrypted in the latimer format
+func DecryptValues(content []byte, key []byte) ([]byte, error) {
+	if !isLatimerEncrypted(content) {
+		return nil, errors.New("Missing " + EncryptedValuesHeader + " header")
+	}
+	lines := strings.Fields(string(content))
+	sealed, err := base64.StdEncoding.DecodeString(strings.Join(lines[1:], ""))
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("Truncated encrypted values")
+	}
+	plain, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], []byte(EncryptedValuesHeader))
+	if err != nil {
+		return nil, errors.New("Wrong key or corrupted encrypted values")
+	}
+	return plain, nil
+}
+
+// newGCM returns the AES-256-GCM cipher of the key
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// isLatimerEncrypted returns whether the contents are encrypted in the latimer format
+func isLatimerEncrypted(content []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(content), []byte(EncryptedValuesHeader))
+}
+
+// isSopsEncrypted returns whether the contents are a sops encrypted yaml document (with a sops metadata block)
+func isSopsEncrypted(content []byte) bool {
+	doc := struct {
+		Sops map[string]interface{} `yaml:"sops"`
+	}{}
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return false
+	}
+	_, hasMac := doc.Sops["mac"]
+	return hasMac
+}
+
+// decryptSops decrypts a sops document with the sops binary, which uses the age and PGP keys available locally.
+// The document is piped to sops and the decrypted contents read from its output, nothing is written to disk.
+func decryptSops(content []byte) ([]byte, error) {
+	sopsPath, err := exec.LookPath("sops")
+	if err != nil {
+		return nil, errors.New("sops is not installed")
+	}
+	cmd := exec.Command(sopsPath, "--decrypt", "--input-type", "yaml", "--output-type", "yaml", "/dev/stdin")
+	cmd.Stdin = bytes.NewReader(content)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%v: %v", err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
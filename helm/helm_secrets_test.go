@@ -0,0 +1,112 @@
+package helm
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"latimer/core"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"helm.sh/helm/v3/pkg/chartutil"
+)
+
+func Test_helm_secrets(t *testing.T) {
+	tmpDir, err := ioutil.TempDir(os.TempDir(), "helm-secrets-*")
+	if err != nil {
+		panic(err.Error())
+	}
+	defer os.RemoveAll(tmpDir)
+	key := []byte("0123456789abcdef0123456789abcdef")
+	os.Setenv(ValuesKeyEnvVar, base64.StdEncoding.EncodeToString(key))
+	defer os.Unsetenv(ValuesKeyEnvVar)
+	plain := []byte("auth:\n  rootPassword: \"s3cr3t-{{.Env}}\"\n")
+
+	t.Run("helm-secrets-roundtrip", func(t *testing.T) {
+		encrypted, err := EncryptValues(plain, key)
+		if err != nil {
+			t.Fatalf("Error encrypting values [%v]", err)
+		}
+		if strings.Contains(string(encrypted), "s3cr3t") || !strings.HasPrefix(string(encrypted), EncryptedValuesHeader+"\n") {
+			t.Errorf("Unexpected encrypted values:\n%v", string(encrypted))
+		}
+		decrypted, err := DecryptValues(encrypted, key)
+		if err != nil || string(decrypted) != string(plain) {
+			t.Errorf("Unexpected decrypted values [%v]", err)
+		}
+		if _, err := DecryptValues(encrypted, []byte("fedcba9876543210fedcba9876543210")); err == nil {
+			t.Errorf("Expecting an error decrypting with the wrong key")
+		}
+	})
+
+	t.Run("helm-secrets-load-values", func(t *testing.T) {
+		encrypted, err := EncryptValues(plain, key)
+		if err != nil {
+			t.Fatalf("Error encrypting values [%v]", err)
+		}
+		valuesPath := filepath.Join(tmpDir, "values-mysql.enc.yaml")
+		if err := ioutil.WriteFile(valuesPath, encrypted, 0600); err != nil {
+			panic(err.Error())
+		}
+		descriptor := core.ChartDescriptor{
+			Name:         "mysql",
+			ChartLocator: "stable/mysql",
+			Values:       []core.ValuesDescriptor{{URL: valuesPath, Template: true}},
+		}
+		chart := NewChart(&descriptor, map[string]string{"Env": "prod"})
+		auth := chart.ValuesMap["auth"].(map[string]interface{})
+		if auth["rootPassword"] != "s3cr3t-prod" || !chart.Encrypted {
+			t.Errorf("Encrypted values not decrypted and templated")
+		}
+		if strings.Contains(chart.String(), "s3cr3t") || strings.Contains(chart.StringYaml(), "s3cr3t") {
+			t.Errorf("Decrypted values leaked in the chart representation")
+		}
+
+		os.Unsetenv(ValuesKeyEnvVar)
+		defer os.Setenv(ValuesKeyEnvVar, base64.StdEncoding.EncodeToString(key))
+		if _, _, err := loadHelmValues([]core.ValuesDescriptor{{URL: valuesPath}}, map[string]string{}); err == nil {
+			t.Errorf("Expecting an error loading encrypted values without key")
+		}
+	})
+
+	t.Run("helm-secrets-bundle", func(t *testing.T) {
+		chartDir, err := chartutil.Create("mysql", tmpDir)
+		if err != nil {
+			panic(err.Error())
+		}
+		valuesPath := filepath.Join(tmpDir, "values-mysql.enc.yaml")
+		descriptor := core.ChartDescriptor{
+			Name:         "mysql",
+			ChartLocator: "file://" + chartDir,
+			ReleaseName:  "test-mysql",
+			Namespace:    "db-paas",
+			Values:       []core.ValuesDescriptor{{URL: valuesPath, Template: true}},
+		}
+		chart := NewChart(&descriptor, map[string]string{"Env": "prod"})
+		bundle := &core.BundleIndex{Manifest: "mysql", Dir: filepath.Join(tmpDir, "bundle")}
+		bundled, err := chart.Bundle(&core.SystemContext{Context: &core.LatimerContext{}}, bundle)
+		if err != nil {
+			t.Fatalf("Error bundling chart [%v]", err)
+		}
+		bundledValues, err := ioutil.ReadFile(bundle.Path(bundled.Values))
+		if err != nil || !isLatimerEncrypted(bundledValues) {
+			t.Errorf("Bundled values of chart with encrypted values are not encrypted [%v]", err)
+		}
+		bundle.Charts = append(bundle.Charts, *bundled)
+		valuesMap, err := chart.valuesFor(&core.SystemContext{Context: &core.LatimerContext{Bundle: bundle}})
+		if err != nil {
+			t.Fatalf("Error loading bundled values [%v]", err)
+		}
+		if valuesMap["auth"].(map[string]interface{})["rootPassword"] != "s3cr3t-prod" {
+			t.Errorf("Bundled values not decrypted")
+		}
+	})
+
+	t.Run("helm-secrets-sops-detection", func(t *testing.T) {
+		sops := []byte("auth:\n  rootPassword: ENC[AES256_GCM,data:abcd,iv:ef01,tag:2345,type:str]\nsops:\n  mac: ENC[AES256_GCM,data:6789]\n  version: 3.6.1\n")
+		if !isSopsEncrypted(sops) || isSopsEncrypted(plain) || isLatimerEncrypted(sops) {
+			t.Errorf("Unexpected detection of sops encrypted values")
+		}
+	})
+}
@@ -0,0 +1,204 @@
+package hook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"latimer/core"
+	"latimer/kube"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+	batchv1 "k8s.io/api/batch/v1"
+)
+
+const (
+	// DefaultNamespace is the namespace of the Job of a hook without namespace
+	DefaultNamespace = "default"
+)
+
+// Hook is a step run between the items of a manifest: a kubernetes Job from an inline spec, or a local command
+type Hook struct {
+	// Name of the hook
+	Name string `json:"name"`
+
+	// The hook descriptor
+	Descriptor *core.HookDescriptor `json:"descriptor"`
+
+	// completed is whether the local command of the hook ran successfully
+	completed bool
+}
+
+// NewHook creates a new instance of a hook
+func NewHook(descriptor *core.HookDescriptor) *Hook {
+	h := new(Hook)
+	h.Name = descriptor.Name
+	h.Descriptor = descriptor
+	return h
+}
+
+// GetID returns the identifier name for this Installable.
+func (h *Hook) GetID() string {
+	return h.Name
+}
+
+// Return string representation of the hook
+func (h *Hook) String() string {
+	hookBytes, err := json.Marshal(h)
+	if err != nil {
+		logrus.Error("Error generating JSON representation")
+	}
+	return string(hookBytes)
+}
+
+// StringYaml returns yaml representation of the hook
+func (h *Hook) StringYaml() string {
+	hookBytes, err := yaml.Marshal(h)
+	if err != nil {
+		logrus.Error("Error generating YAML representation")
+	}
+	return string(hookBytes)
+}
+
+// Install runs the Job or local command of the hook and waits for it to complete
+func (h *Hook) Install(sc *core.SystemContext) bool {
+	if err := h.Run(sc); err != nil {
+		logrus.Errorf("Hook %v failed [%v]", h.Name, err)
+		return false
+	}
+	fmt.Printf("Hook %v completed\n", h.Name)
+	return true
+}
+
+// Uninstall deletes the Job runs of the hook (local commands have nothing to clean up)
+func (h *Hook) Uninstall(sc *core.SystemContext) bool {
+	h.completed = false
+	if h.Descriptor.IsCommand() {
+		return true
+	}
+	if err := sc.GetKubeClient().DeleteJobs(h.Name, h.namespace()); err != nil {
+		logrus.Errorf("Delete failed [%v]", err)
+		return false
+	}
+	return true
+}
+
+// Status returns whether the hook completed: the latest run of its Job succeeded, or its command ran successfully
+func (h *Hook) Status(sc *core.SystemContext) kube.InstallStatus {
+	if h.Descriptor.IsCommand() {
+		if h.completed {
+			return kube.Ready
+		}
+		return kube.NotInstalled
+	}
+	job, err := sc.GetKubeClient().GetJob(h.Name, h.namespace())
+	if err != nil || (job != nil && kube.JobFailed(job)) {
+		return kube.InstallationError
+	} else if job == nil {
+		return kube.NotInstalled
+	}
+	rr := kube.NewReleaseResources(h.Name)
+	rr.Jobs = append(rr.Jobs, *job)
+	return rr.ReleaseStatus()
+}
+
+// Run runs the Job or local command of the hook, waiting for up to the hook timeout for it to complete
+func (h *Hook) Run(sc *core.SystemContext) error {
+	timeout := time.Duration(h.Descriptor.Timeout) * time.Second
+	if h.Descriptor.IsCommand() {
+		if err := h.runCommand(timeout); err != nil {
+			return err
+		}
+		h.completed = true
+		return nil
+	}
+	spec, err := h.jobSpec()
+	if err != nil {
+		return err
+	}
+	k8s := sc.GetKubeClient()
+	job, err := k8s.RunJob(h.Name, h.namespace(), *spec)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Running job %v/%v\n", job.Namespace, job.Name)
+	completed, err := k8s.WaitForJob(h.Name, h.namespace(), timeout)
+	if err != nil {
+		return err
+	}
+	if !completed {
+		return fmt.Errorf("Timeout expired waiting for job %v/%v", job.Namespace, job.Name)
+	}
+	return nil
+}
+
+// runCommand runs the local command of the hook, killing it if it does not complete within the timeout
+func (h *Hook) runCommand(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	command := h.Descriptor.Command
+	fmt.Printf("Running command %v\n", command)
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Dir = h.Descriptor.Dir
+	cmd.Env = os.Environ()
+	for k, v := range h.Descriptor.Env {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("Timeout expired running %v", command)
+	}
+	return err
+}
+
+// jobSpec returns the Job spec of the hook, decoded from its inline yaml
+func (h *Hook) jobSpec() (*batchv1.JobSpec, error) {
+	specBytes, err := json.Marshal(convertStringKeys(h.Descriptor.Spec))
+	if err != nil {
+		return nil, err
+	}
+	spec := &batchv1.JobSpec{}
+	if err := json.Unmarshal(specBytes, spec); err != nil {
+		return nil, fmt.Errorf("Invalid job spec of hook %v: %v", h.Name, err)
+	}
+	return spec, nil
+}
+
+// namespace returns the namespace of the Job of the hook
+func (h *Hook) namespace() string {
+	if h.Descriptor.Namespace == "" {
+		return DefaultNamespace
+	}
+	return h.Descriptor.Namespace
+}
+
+// convertStringKeys converts the map[interface{}]interface{} maps decoded by yaml into map[string]interface{}
+// maps, which can be encoded as json
+func convertStringKeys(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		converted := map[string]interface{}{}
+		for key, item := range v {
+			converted[fmt.Sprintf("%v", key)] = convertStringKeys(item)
+		}
+		return converted
+	case map[string]interface{}:
+		converted := map[string]interface{}{}
+		for key, item := range v {
+			converted[key] = convertStringKeys(item)
+		}
+		return converted
+	case []interface{}:
+		converted := make([]interface{}, 0, len(v))
+		for _, item := range v {
+			converted = append(converted, convertStringKeys(item))
+		}
+		return converted
+	}
+	return value
+}
@@ -0,0 +1,75 @@
+package hook
+
+import (
+	"io/ioutil"
+	"latimer/core"
+	"latimer/kube"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+	v1 "k8s.io/api/core/v1"
+)
+
+func Test_hook_command(t *testing.T) {
+	tmpDir, err := ioutil.TempDir(os.TempDir(), "hook-command-*")
+	if err != nil {
+		panic(err.Error())
+	}
+	defer os.RemoveAll(tmpDir)
+
+	t.Run("hook-command-run", func(t *testing.T) {
+		h := NewHook(&core.HookDescriptor{
+			Name:    "seed",
+			Command: []string{"sh", "-c", "echo $TARGET > seeded"},
+			Env:     map[string]string{"TARGET": "vault"},
+			Dir:     tmpDir,
+			Timeout: 10,
+		})
+		sc := &core.SystemContext{Name: "hooks"}
+		if !h.Install(sc) {
+			t.Fatalf("Expecting hook %v to complete", h.Name)
+		}
+		seeded, err := ioutil.ReadFile(filepath.Join(tmpDir, "seeded"))
+		if err != nil || strings.TrimSpace(string(seeded)) != "vault" {
+			t.Errorf("Expecting the command to run in %v with its environment [%v]", tmpDir, err)
+		}
+		if h.Status(sc) != kube.Ready {
+			t.Errorf("Expecting hook %v to be ready, got %v", h.Name, h.Status(sc))
+		}
+	})
+	t.Run("hook-command-failure", func(t *testing.T) {
+		h := NewHook(&core.HookDescriptor{Name: "check", Command: []string{"sh", "-c", "exit 3"}, Timeout: 10})
+		if err := h.Run(&core.SystemContext{}); err == nil {
+			t.Errorf("Expecting an error running a failing command")
+		}
+	})
+	t.Run("hook-command-timeout", func(t *testing.T) {
+		h := NewHook(&core.HookDescriptor{Name: "slow", Command: []string{"sleep", "10"}, Timeout: 1})
+		err := h.Run(&core.SystemContext{})
+		if err == nil || !strings.Contains(err.Error(), "Timeout") {
+			t.Errorf("Expecting a timeout running a slow command, got [%v]", err)
+		}
+	})
+}
+
+func Test_hook_job_spec(t *testing.T) {
+	t.Run("hook-job-spec", func(t *testing.T) {
+		descriptor := core.HookDescriptor{}
+		inline := "name: migrate\nspec:\n  backoffLimit: 2\n  template:\n    spec:\n      containers:\n" +
+			"        - name: migrate\n          image: migrate/migrate:v4.11.0\n          args: [\"up\"]\n"
+		if err := yaml.Unmarshal([]byte(inline), &descriptor); err != nil {
+			panic(err.Error())
+		}
+		spec, err := NewHook(&descriptor).jobSpec()
+		if err != nil {
+			t.Fatalf("Error decoding the job spec [%v]", err)
+		}
+		if *spec.BackoffLimit != 2 || len(spec.Template.Spec.Containers) != 1 ||
+			spec.Template.Spec.Containers[0].Image != "migrate/migrate:v4.11.0" || spec.Template.Spec.RestartPolicy != v1.RestartPolicy("") {
+			t.Errorf("Unexpected job spec %v", spec)
+		}
+	})
+}
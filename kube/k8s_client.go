@@ -2,15 +2,21 @@ package kube
 
 import (
 	"context"
+	"fmt"
 	"strings"
 	"time"
 
 	"github.com/sirupsen/logrus"
 	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
-	"k8s.io/client-go/tools/clientcmd"
 )
 
 const (
@@ -23,15 +29,28 @@ const (
 // K8sClient defines a class representing a kubernetes client capable of executing a variety of commands
 // against a specified API server.
 type K8sClient struct {
-	kubeConfigPath string
-	kubeConfig     *rest.Config
-	clientSet      *kubernetes.Clientset
+	factory       *ClientFactory
+	kubeConfig    *rest.Config
+	clientSet     kubernetes.Interface
+	dynamicClient dynamic.Interface
+	mapper        meta.RESTMapper
 }
 
 // NewK8sClient creates a new instance of a kubernetes client
 func NewK8sClient(kubeConfigPath string) (*K8sClient, error) {
+	return NewK8sClientForContext(kubeConfigPath, "")
+}
+
+// NewK8sClientForContext creates a new instance of a kubernetes client for the cluster of the given context of the
+// kube config file (its current context if empty)
+func NewK8sClientForContext(kubeConfigPath string, kubeContext string) (*K8sClient, error) {
+	return NewK8sClientFromFactory(&ClientFactory{KubeConfig: kubeConfigPath, Context: kubeContext})
+}
+
+// NewK8sClientFromFactory creates a new instance of a kubernetes client with the configuration of the factory
+func NewK8sClientFromFactory(factory *ClientFactory) (*K8sClient, error) {
 	kubeClient := new(K8sClient)
-	config, err := newKubeConfig(kubeConfigPath)
+	config, err := factory.ToRESTConfig()
 	if err != nil {
 		return nil, err
 	}
@@ -39,16 +58,31 @@ func NewK8sClient(kubeConfigPath string) (*K8sClient, error) {
 	if err != nil {
 		return nil, err
 	}
-	kubeClient.kubeConfigPath = kubeConfigPath
+	kubeClient.factory = factory
 	kubeClient.kubeConfig = config
 	kubeClient.clientSet = clientSet
+	kubeClient.dynamicClient, err = dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	kubeClient.mapper, err = factory.ToRESTMapper()
+	if err != nil {
+		return nil, err
+	}
 	return kubeClient, nil
 }
 
-// newGetKubeConfig loads the kube config settings
-func newKubeConfig(kubeConfig string) (*rest.Config, error) {
-	config, err := clientcmd.BuildConfigFromFlags("", kubeConfig)
-	return config, err
+// Factory returns the client factory of the client, to configure other clients (eg helm) for the same cluster
+func (k8s *K8sClient) Factory() *ClientFactory {
+	return k8s.factory
+}
+
+// Host returns the address of the API server of the cluster
+func (k8s *K8sClient) Host() string {
+	if k8s.kubeConfig == nil {
+		return ""
+	}
+	return k8s.kubeConfig.Host
 }
 
 // newClientSet returns the clientset object which to use for issuing k8s API calls.
@@ -79,9 +113,142 @@ func (k8s *K8sClient) DeleteNamespace(namespace string) error {
 	return k8s.clientSet.CoreV1().Namespaces().Delete(context.TODO(), namespace, metav1.DeleteOptions{})
 }
 
+// EnsureNamespace creates the namespace if missing, labelled as created by latimer, and applies the given labels
+// and annotations to it.  Returns whether the namespace was created.
+func (k8s *K8sClient) EnsureNamespace(namespace string, labels map[string]string, annotations map[string]string) (bool, error) {
+	namespaces := k8s.clientSet.CoreV1().Namespaces()
+	ns, err := namespaces.Get(context.TODO(), namespace, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		ns = &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: namespace, Labels: map[string]string{}, Annotations: annotations}}
+		for k, v := range labels {
+			ns.Labels[k] = v
+		}
+		ns.Labels[LabelManagedBy] = FieldManager
+		_, err = namespaces.Create(context.TODO(), ns, metav1.CreateOptions{})
+		if err != nil {
+			return false, err
+		}
+		logrus.Infof("Created namespace %v", namespace)
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+	changed := false
+	if ns.Labels == nil {
+		ns.Labels = map[string]string{}
+	}
+	if ns.Annotations == nil {
+		ns.Annotations = map[string]string{}
+	}
+	for k, v := range labels {
+		changed = changed || ns.Labels[k] != v
+		ns.Labels[k] = v
+	}
+	for k, v := range annotations {
+		changed = changed || ns.Annotations[k] != v
+		ns.Annotations[k] = v
+	}
+	if changed {
+		if _, err := namespaces.Update(context.TODO(), ns, metav1.UpdateOptions{}); err != nil {
+			return false, err
+		}
+		logrus.Infof("Updated labels and annotations of namespace %v", namespace)
+	}
+	return false, nil
+}
+
+// DeleteNamespaceIfEmpty deletes the namespace if latimer created it and it holds no objects anymore (objects
+// being deleted aside).  Returns whether the namespace was deleted, or why it was kept.
+func (k8s *K8sClient) DeleteNamespaceIfEmpty(namespace string) (bool, string, error) {
+	ns := k8s.GetNamespace(namespace)
+	if ns == nil || ns.DeletionTimestamp != nil {
+		return false, "not found", nil
+	}
+	if ns.Labels[LabelManagedBy] != FieldManager {
+		return false, "not created by latimer", nil
+	}
+	remaining, err := k8s.namespaceObjects(namespace)
+	if err != nil {
+		return false, "", err
+	}
+	if len(remaining) > 0 {
+		return false, fmt.Sprintf("not empty: %v", strings.Join(remaining, ", ")), nil
+	}
+	if err := k8s.DeleteNamespace(namespace); err != nil && !apierrors.IsNotFound(err) {
+		return false, "", err
+	}
+	return true, "", nil
+}
+
+// namespaceObjects returns the kind/name of the objects left in the namespace, leaving out the objects being
+// deleted and the ones kubernetes creates in every namespace (default service account and its token, root CA,
+// events).  Every namespaced resource the API server can list is checked, custom resources included.  The API
+// groups which cannot be discovered are returned as well, as the namespace may hold objects of theirs.
+func (k8s *K8sClient) namespaceObjects(namespace string) ([]string, error) {
+	objects := make([]string, 0)
+	resourceLists, err := discovery.ServerPreferredNamespacedResources(k8s.clientSet.Discovery())
+	if failed, ok := err.(*discovery.ErrGroupDiscoveryFailed); ok {
+		for gv := range failed.Groups {
+			objects = append(objects, fmt.Sprintf("%v (discovery failed)", gv))
+		}
+	} else if err != nil {
+		return nil, err
+	}
+	for _, resourceList := range resourceLists {
+		gv, err := schema.ParseGroupVersion(resourceList.GroupVersion)
+		if err != nil {
+			return nil, err
+		}
+		for _, resource := range resourceList.APIResources {
+			if strings.Contains(resource.Name, "/") || resource.Name == "events" || !hasVerb(resource.Verbs, "list") {
+				continue
+			}
+			list, err := k8s.dynamicClient.Resource(gv.WithResource(resource.Name)).Namespace(namespace).List(context.TODO(), metav1.ListOptions{})
+			if err != nil {
+				return nil, fmt.Errorf("Error listing %v in namespace %v: %v", resource.Name, namespace, err)
+			}
+			for _, o := range list.Items {
+				if o.GetDeletionTimestamp() == nil && !defaultNamespaceObject(resource.Kind, &o) {
+					objects = append(objects, resource.Kind+"/"+o.GetName())
+				}
+			}
+		}
+	}
+	return objects, nil
+}
+
+// defaultNamespaceObject returns whether kubernetes creates the object in every namespace
+func defaultNamespaceObject(kind string, o *unstructured.Unstructured) bool {
+	switch kind {
+	case "ConfigMap":
+		return o.GetName() == "kube-root-ca.crt"
+	case "ServiceAccount":
+		return o.GetName() == "default"
+	case "Secret":
+		secretType, _, _ := unstructured.NestedString(o.Object, "type")
+		return secretType == string(v1.SecretTypeServiceAccountToken)
+	}
+	return false
+}
+
+// hasVerb returns whether the verb is in the list of verbs of an API resource
+func hasVerb(verbs metav1.Verbs, verb string) bool {
+	for _, v := range verbs {
+		if v == verb {
+			return true
+		}
+	}
+	return false
+}
+
 // GetResourcesInRelease returns all runtime resources under a given release name in a namespace
 func (k8s *K8sClient) GetResourcesInRelease(releaseName string, releaseNamespace string) (*ReleaseResources, error) {
-	rr := NewReleaseResources(releaseName)
+	return k8s.getResourcesWithLabel(releaseName, LabelReleaseName, releaseName)
+}
+
+// getResourcesWithLabel returns all runtime resources with the given label value, across namespaces
+func (k8s *K8sClient) getResourcesWithLabel(name string, label string, value string) (*ReleaseResources, error) {
+	rr := NewReleaseResources(name)
 	listOpts := metav1.ListOptions{}
 
 	namespaceList, err := k8s.clientSet.CoreV1().Namespaces().List(context.TODO(), listOpts)
@@ -97,51 +264,31 @@ func (k8s *K8sClient) GetResourcesInRelease(releaseName string, releaseNamespace
 		if strings.HasPrefix("kube-", namespace) {
 			continue
 		}
-		// Deployments
-		deployList, err := k8s.clientSet.AppsV1().Deployments(namespace).List(context.TODO(), listOpts)
+		workloads, err := k8s.getWorkloads(namespace, listOpts)
 		if err != nil {
-			logrus.Error("Error getting deployments in namespace " + namespace)
 			return nil, err
 		}
-		for _, deployment := range deployList.Items {
-			val, exists := deployment.Labels[LabelReleaseName]
-			if exists && val == releaseName {
+		for _, deployment := range workloads.Deployments {
+			val, exists := deployment.Labels[label]
+			if exists && val == value {
 				rr.Deployments = append(rr.Deployments, deployment)
 			}
 		}
-		// StatefulSets
-		ssList, err := k8s.clientSet.AppsV1().StatefulSets(namespace).List(context.TODO(), listOpts)
-		if err != nil {
-			logrus.Error("Error getting statefulsets in namespace " + namespace)
-			return nil, err
-		}
-		for _, ss := range ssList.Items {
-			val, exists := ss.Labels[LabelReleaseName]
-			if exists && val == releaseName {
+		for _, ss := range workloads.StatefulSets {
+			val, exists := ss.Labels[label]
+			if exists && val == value {
 				rr.StatefulSets = append(rr.StatefulSets, ss)
 			}
 		}
-		// Daemonsets
-		dsList, err := k8s.clientSet.AppsV1().DaemonSets(namespace).List(context.TODO(), listOpts)
-		if err != nil {
-			logrus.Error("Error getting daemonsets in namespace " + namespace)
-			return nil, err
-		}
-		for _, ds := range dsList.Items {
-			val, exists := ds.Labels[LabelReleaseName]
-			if exists && val == releaseName {
+		for _, ds := range workloads.DaemonSets {
+			val, exists := ds.Labels[label]
+			if exists && val == value {
 				rr.DaemonSets = append(rr.DaemonSets, ds)
 			}
 		}
-		// Jobs
-		jobsList, err := k8s.clientSet.BatchV1().Jobs(namespace).List(context.TODO(), listOpts)
-		if err != nil {
-			logrus.Error("Error getting jobs in namespace " + namespace)
-			return nil, err
-		}
-		for _, job := range jobsList.Items {
-			val, exists := job.Labels[LabelReleaseName]
-			if exists && val == releaseName {
+		for _, job := range workloads.Jobs {
+			val, exists := job.Labels[label]
+			if exists && val == value {
 				rr.Jobs = append(rr.Jobs, job)
 			}
 		}
@@ -149,10 +296,58 @@ func (k8s *K8sClient) GetResourcesInRelease(releaseName string, releaseNamespace
 	return rr, nil
 }
 
+// getWorkloads returns the deployments, statefulsets, daemonsets and jobs of a namespace
+func (k8s *K8sClient) getWorkloads(namespace string, listOpts metav1.ListOptions) (*ReleaseResources, error) {
+	rr := NewReleaseResources(namespace)
+	// Deployments
+	deployList, err := k8s.clientSet.AppsV1().Deployments(namespace).List(context.TODO(), listOpts)
+	if err != nil {
+		logrus.Error("Error getting deployments in namespace " + namespace)
+		return nil, err
+	}
+	rr.Deployments = append(rr.Deployments, deployList.Items...)
+	// StatefulSets
+	ssList, err := k8s.clientSet.AppsV1().StatefulSets(namespace).List(context.TODO(), listOpts)
+	if err != nil {
+		logrus.Error("Error getting statefulsets in namespace " + namespace)
+		return nil, err
+	}
+	rr.StatefulSets = append(rr.StatefulSets, ssList.Items...)
+	// Daemonsets
+	dsList, err := k8s.clientSet.AppsV1().DaemonSets(namespace).List(context.TODO(), listOpts)
+	if err != nil {
+		logrus.Error("Error getting daemonsets in namespace " + namespace)
+		return nil, err
+	}
+	rr.DaemonSets = append(rr.DaemonSets, dsList.Items...)
+	// Jobs
+	jobsList, err := k8s.clientSet.BatchV1().Jobs(namespace).List(context.TODO(), listOpts)
+	if err != nil {
+		logrus.Error("Error getting jobs in namespace " + namespace)
+		return nil, err
+	}
+	rr.Jobs = append(rr.Jobs, jobsList.Items...)
+	return rr, nil
+}
+
 // WaitForRelease pauses for up to 'timeout' seconds waiting for the specified release to be fully installed
 func (k8s *K8sClient) WaitForRelease(releaseName string, namespace string, timeout time.Duration) (bool, error) {
+	return k8s.waitForResources(releaseName, namespace, timeout, func() (*ReleaseResources, error) {
+		return k8s.GetResourcesInRelease(releaseName, namespace)
+	})
+}
+
+// WaitForOwner pauses for up to 'timeout' seconds waiting for the resources owned by the named latimer item to be ready
+func (k8s *K8sClient) WaitForOwner(owner string, namespace string, timeout time.Duration) (bool, error) {
+	return k8s.waitForResources(owner, namespace, timeout, func() (*ReleaseResources, error) {
+		return k8s.GetResourcesOwnedBy(owner)
+	})
+}
+
+// waitForResources polls the resources returned by get until they are ready or the timeout expires
+func (k8s *K8sClient) waitForResources(name string, namespace string, timeout time.Duration, get func() (*ReleaseResources, error)) (bool, error) {
 	start := time.Now()
-	rr, err := k8s.GetResourcesInRelease(releaseName, namespace)
+	rr, err := get()
 	if err != nil {
 		return false, err
 	}
@@ -163,11 +358,69 @@ func (k8s *K8sClient) WaitForRelease(releaseName string, namespace string, timeo
 		if elapsed > timeout {
 			return false, nil
 		}
-		rr, err = k8s.GetResourcesInRelease(releaseName, namespace)
+		rr, err = get()
 		if err != nil {
 			return false, err
 		}
-		logrus.Debugf("Waiting for release %v [%v] Elapsed=%v\n", releaseName, namespace, elapsed)
+		logrus.Debugf("Waiting for release %v [%v] Elapsed=%v\n", name, namespace, elapsed)
 	}
 	return true, nil
 }
+
+// GetConfigMapValue returns the value of a key of a config map
+func (k8s *K8sClient) GetConfigMapValue(namespace string, name string, key string) (string, error) {
+	cm, err := k8s.clientSet.CoreV1().ConfigMaps(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	value, found := cm.Data[key]
+	if !found {
+		return "", fmt.Errorf("Key %v not found in config map %v/%v", key, namespace, name)
+	}
+	return value, nil
+}
+
+// SaveConfigMapValue sets the value of a key of a config map, creating the config map with the given labels if
+// missing
+func (k8s *K8sClient) SaveConfigMapValue(namespace string, name string, key string, value string, labels map[string]string) error {
+	configMaps := k8s.clientSet.CoreV1().ConfigMaps(namespace)
+	cm, err := configMaps.Get(context.TODO(), name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		cm = &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: labels},
+			Data:       map[string]string{key: value},
+		}
+		_, err = configMaps.Create(context.TODO(), cm, metav1.CreateOptions{})
+		return err
+	} else if err != nil {
+		return err
+	}
+	if cm.Data == nil {
+		cm.Data = map[string]string{}
+	}
+	cm.Data[key] = value
+	_, err = configMaps.Update(context.TODO(), cm, metav1.UpdateOptions{})
+	return err
+}
+
+// DeleteConfigMap deletes a config map, ignoring a config map already gone
+func (k8s *K8sClient) DeleteConfigMap(namespace string, name string) error {
+	err := k8s.clientSet.CoreV1().ConfigMaps(namespace).Delete(context.TODO(), name, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// GetSecretValue returns the (decoded) value of a key of a secret
+func (k8s *K8sClient) GetSecretValue(namespace string, name string, key string) ([]byte, error) {
+	secret, err := k8s.clientSet.CoreV1().Secrets(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	value, found := secret.Data[key]
+	if !found {
+		return nil, fmt.Errorf("Key %v not found in secret %v/%v", key, namespace, name)
+	}
+	return value, nil
+}
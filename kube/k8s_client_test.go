@@ -1,10 +1,20 @@
 package kube
 
 import (
+	"context"
 	"os/user"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
 )
 
 const (
@@ -98,3 +108,140 @@ func Test_WaitForRelease(t *testing.T) {
 		}
 	})
 }
+
+func Test_GetObjectValues(t *testing.T) {
+	k8s := &K8sClient{clientSet: fake.NewSimpleClientset(
+		&v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "redis", Namespace: "paas"},
+			Data:       map[string]string{"values.yaml": "replicaCount: 2\n"},
+		},
+		&v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "redis-creds", Namespace: "paas"},
+			Data:       map[string][]byte{"values.yaml": []byte("password: secret\n")},
+		},
+	)}
+	t.Run("get-config-map-value", func(t *testing.T) {
+		value, err := k8s.GetConfigMapValue("paas", "redis", "values.yaml")
+		if err != nil || value != "replicaCount: 2\n" {
+			t.Errorf("Unexpected config map value %v [%v]", value, err)
+		}
+		if _, err := k8s.GetConfigMapValue("paas", "redis", "missing.yaml"); err == nil {
+			t.Errorf("Expecting an error reading a missing config map key")
+		}
+	})
+	t.Run("save-config-map-value", func(t *testing.T) {
+		if err := k8s.SaveConfigMapValue("paas", "redis", "owner", "platform", nil); err != nil {
+			t.Fatalf("Error updating config map [%v]", err)
+		}
+		if value, err := k8s.GetConfigMapValue("paas", "redis", "owner"); err != nil || value != "platform" {
+			t.Errorf("Unexpected config map value %v [%v]", value, err)
+		}
+		labels := map[string]string{LabelManagedBy: FieldManager}
+		if err := k8s.SaveConfigMapValue("db-paas", "state", "releases", "[]", labels); err != nil {
+			t.Fatalf("Error creating config map [%v]", err)
+		}
+		if value, err := k8s.GetConfigMapValue("db-paas", "state", "releases"); err != nil || value != "[]" {
+			t.Errorf("Unexpected config map value %v [%v]", value, err)
+		}
+		if err := k8s.DeleteConfigMap("db-paas", "state"); err != nil {
+			t.Errorf("Error deleting config map [%v]", err)
+		}
+		if err := k8s.DeleteConfigMap("db-paas", "state"); err != nil {
+			t.Errorf("Expecting no error deleting a config map already gone [%v]", err)
+		}
+	})
+	t.Run("get-secret-value", func(t *testing.T) {
+		value, err := k8s.GetSecretValue("paas", "redis-creds", "values.yaml")
+		if err != nil || string(value) != "password: secret\n" {
+			t.Errorf("Unexpected secret value [%v]", err)
+		}
+		if _, err := k8s.GetSecretValue("db-paas", "redis-creds", "values.yaml"); err == nil {
+			t.Errorf("Expecting an error reading a missing secret")
+		}
+	})
+}
+
+// Returns a client whose discovery serves the core resources and a custom resource, over a dynamic client
+// holding the given objects
+func newNamespaceClient(objects ...runtime.Object) *K8sClient {
+	clientSet := fake.NewSimpleClientset(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "shared"}})
+	list := []string{"list", "get", "create", "delete"}
+	clientSet.Resources = []*metav1.APIResourceList{
+		{GroupVersion: "v1", APIResources: []metav1.APIResource{
+			{Name: "configmaps", Kind: "ConfigMap", Namespaced: true, Verbs: list},
+			{Name: "events", Kind: "Event", Namespaced: true, Verbs: list},
+			{Name: "namespaces", Kind: "Namespace", Verbs: list},
+			{Name: "persistentvolumeclaims", Kind: "PersistentVolumeClaim", Namespaced: true, Verbs: list},
+			{Name: "pods/log", Kind: "Pod", Namespaced: true, Verbs: []string{"get"}},
+			{Name: "serviceaccounts", Kind: "ServiceAccount", Namespaced: true, Verbs: list},
+		}},
+		{GroupVersion: "example.io/v1", APIResources: []metav1.APIResource{
+			{Name: "backups", Kind: "Backup", Namespaced: true, Verbs: list},
+		}},
+	}
+	return &K8sClient{
+		clientSet:     clientSet,
+		dynamicClient: dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), objects...),
+	}
+}
+
+func Test_NamespaceLifecycle(t *testing.T) {
+	k8s := newNamespaceClient(
+		newObject("v1", "ConfigMap", "paas", "kube-root-ca.crt"),
+		newObject("v1", "ServiceAccount", "paas", "default"),
+		newObject("v1", "Event", "paas", "redis-master-0.16f9a"),
+	)
+	t.Run("ensure-namespace-create", func(t *testing.T) {
+		created, err := k8s.EnsureNamespace("paas", map[string]string{"istio-injection": "enabled"}, map[string]string{"owner": "platform"})
+		if err != nil || !created {
+			t.Fatalf("Expecting namespace paas to be created [%v]", err)
+		}
+		ns := k8s.GetNamespace("paas")
+		if ns.Labels[LabelManagedBy] != FieldManager || ns.Labels["istio-injection"] != "enabled" || ns.Annotations["owner"] != "platform" {
+			t.Errorf("Unexpected metadata of namespace paas %v %v", ns.Labels, ns.Annotations)
+		}
+	})
+	t.Run("ensure-namespace-existing", func(t *testing.T) {
+		created, err := k8s.EnsureNamespace("shared", map[string]string{"team": "data"}, nil)
+		if err != nil || created {
+			t.Fatalf("Expecting namespace shared to be updated only [%v]", err)
+		}
+		ns := k8s.GetNamespace("shared")
+		if ns.Labels["team"] != "data" || ns.Labels[LabelManagedBy] != "" {
+			t.Errorf("Unexpected labels of namespace shared %v", ns.Labels)
+		}
+	})
+	t.Run("delete-namespace-not-created", func(t *testing.T) {
+		deleted, reason, err := k8s.DeleteNamespaceIfEmpty("shared")
+		if err != nil || deleted || reason != "not created by latimer" {
+			t.Errorf("Expecting namespace shared to be kept, got %v %v [%v]", deleted, reason, err)
+		}
+	})
+	keptBy := func(t *testing.T, resource schema.GroupVersionResource, obj *unstructured.Unstructured) {
+		objects := k8s.dynamicClient.Resource(resource).Namespace("paas")
+		if _, err := objects.Create(context.TODO(), obj, metav1.CreateOptions{}); err != nil {
+			panic(err.Error())
+		}
+		deleted, reason, err := k8s.DeleteNamespaceIfEmpty("paas")
+		if err != nil || deleted || !strings.Contains(reason, obj.GetKind()+"/"+obj.GetName()) {
+			t.Errorf("Expecting namespace paas to be kept by %v, got %v %v [%v]", obj.GetName(), deleted, reason, err)
+		}
+		if err := objects.Delete(context.TODO(), obj.GetName(), metav1.DeleteOptions{}); err != nil {
+			panic(err.Error())
+		}
+	}
+	t.Run("delete-namespace-not-empty", func(t *testing.T) {
+		keptBy(t, schema.GroupVersionResource{Version: "v1", Resource: "persistentvolumeclaims"},
+			newObject("v1", "PersistentVolumeClaim", "paas", "data-mysql-0"))
+	})
+	t.Run("delete-namespace-custom-resource", func(t *testing.T) {
+		keptBy(t, schema.GroupVersionResource{Group: "example.io", Version: "v1", Resource: "backups"},
+			newObject("example.io/v1", "Backup", "paas", "nightly"))
+	})
+	t.Run("delete-namespace-empty", func(t *testing.T) {
+		deleted, _, err := k8s.DeleteNamespaceIfEmpty("paas")
+		if err != nil || !deleted || k8s.GetNamespace("paas") != nil {
+			t.Errorf("Expecting namespace paas to be deleted [%v]", err)
+		}
+	})
+}
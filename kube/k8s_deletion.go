@@ -0,0 +1,153 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	// AnnotationResourcePolicy is the helm annotation keeping an object on uninstall
+	AnnotationResourcePolicy = "helm.sh/resource-policy"
+	// LabelInstance is the recommended label key holding the release name of the objects of a chart
+	LabelInstance = "app.kubernetes.io/instance"
+)
+
+// PendingObject is an object of an uninstalled item which is not gone yet
+type PendingObject struct {
+	Ref ObjectRef
+	// Terminating is whether the deletion of the object has started
+	Terminating bool
+	// Finalizers lists the finalizers the deletion of the object waits for
+	Finalizers []string
+}
+
+// String returns the reference of the object along with why it is still there
+func (p PendingObject) String() string {
+	if p.Terminating && len(p.Finalizers) > 0 {
+		return fmt.Sprintf("%v stuck on finalizers [%v]", p.Ref, strings.Join(p.Finalizers, ", "))
+	} else if p.Terminating {
+		return fmt.Sprintf("%v (terminating)", p.Ref)
+	}
+	return p.Ref.String()
+}
+
+// DeletionScope describes the objects to wait for once an item is uninstalled
+type DeletionScope struct {
+	// Refs lists the objects of the item
+	Refs []ObjectRef
+	// Namespace is the namespace of the pods (and volume claims) created on behalf of the item
+	Namespace string
+	// Selectors are the label selectors of the pods (and volume claims) created on behalf of the item
+	Selectors []string
+	// Volumes is whether to wait for the volume claims being deleted as well
+	Volumes bool
+}
+
+// ReleaseSelectors returns the label selectors of the objects created on behalf of a helm release
+func ReleaseSelectors(releaseName string) []string {
+	return []string{LabelInstance + "=" + releaseName, LabelReleaseName + "=" + releaseName}
+}
+
+// ReleaseObjects returns the references of the objects of a helm release manifest, leaving out the ones helm
+// keeps on uninstall.  Objects without a namespace are in the release namespace.
+func ReleaseObjects(manifest string, namespace string) ([]ObjectRef, error) {
+	objects, err := ParseObjects([]byte(manifest))
+	if err != nil {
+		return nil, err
+	}
+	refs := make([]ObjectRef, 0, len(objects))
+	for _, obj := range objects {
+		if obj.GetAnnotations()[AnnotationResourcePolicy] == "keep" {
+			continue
+		}
+		ns := obj.GetNamespace()
+		if ns == "" {
+			ns = namespace
+		}
+		refs = append(refs, ObjectRef{APIVersion: obj.GetAPIVersion(), Kind: obj.GetKind(), Namespace: ns, Name: obj.GetName()})
+	}
+	return refs, nil
+}
+
+// WaitForDeletion pauses for up to 'timeout' waiting for the objects of the scope to be gone.  Returns the objects
+// still there when the timeout expires.
+func (k8s *K8sClient) WaitForDeletion(scope DeletionScope, timeout time.Duration) ([]PendingObject, error) {
+	start := time.Now()
+	for {
+		pending, err := k8s.PendingObjects(scope)
+		if err != nil || len(pending) == 0 {
+			return pending, err
+		}
+		elapsed := time.Since(start)
+		if elapsed > timeout {
+			return pending, nil
+		}
+		logrus.Debugf("Waiting for deletion of %v objects, eg %v. Elapsed=%v", len(pending), pending[0], elapsed)
+		time.Sleep(2 * time.Second)
+	}
+}
+
+// PendingObjects returns the objects of the scope which are not gone yet
+func (k8s *K8sClient) PendingObjects(scope DeletionScope) ([]PendingObject, error) {
+	pending := make([]PendingObject, 0)
+	for _, ref := range scope.Refs {
+		ri, namespaced, err := k8s.resourceFor(schema.FromAPIVersionAndKind(ref.APIVersion, ref.Kind))
+		if err != nil {
+			// The kind is gone (eg a custom resource deleted along with its definition)
+			continue
+		}
+		if !namespaced {
+			ref.Namespace = ""
+		}
+		obj, err := namespacedResource(ri, ref.Namespace).Get(context.TODO(), ref.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		pending = append(pending, newPendingObject(ref, obj.GetDeletionTimestamp(), obj.GetFinalizers()))
+	}
+	seen := map[string]bool{}
+	for _, selector := range scope.Selectors {
+		listOpts := metav1.ListOptions{LabelSelector: selector}
+		pods, err := k8s.clientSet.CoreV1().Pods(scope.Namespace).List(context.TODO(), listOpts)
+		if err != nil {
+			return nil, err
+		}
+		for _, pod := range pods.Items {
+			ref := ObjectRef{APIVersion: "v1", Kind: "Pod", Namespace: pod.Namespace, Name: pod.Name}
+			if !seen[ref.String()] {
+				seen[ref.String()] = true
+				pending = append(pending, newPendingObject(ref, pod.DeletionTimestamp, pod.Finalizers))
+			}
+		}
+		if !scope.Volumes {
+			continue
+		}
+		pvcs, err := k8s.clientSet.CoreV1().PersistentVolumeClaims(scope.Namespace).List(context.TODO(), listOpts)
+		if err != nil {
+			return nil, err
+		}
+		for _, pvc := range pvcs.Items {
+			ref := ObjectRef{APIVersion: "v1", Kind: "PersistentVolumeClaim", Namespace: pvc.Namespace, Name: pvc.Name}
+			// Retained volume claims are not going away
+			if pvc.DeletionTimestamp != nil && !seen[ref.String()] {
+				seen[ref.String()] = true
+				pending = append(pending, newPendingObject(ref, pvc.DeletionTimestamp, pvc.Finalizers))
+			}
+		}
+	}
+	return pending, nil
+}
+
+// newPendingObject returns the pending object of a reference given its deletion timestamp and finalizers
+func newPendingObject(ref ObjectRef, deletionTimestamp *metav1.Time, finalizers []string) PendingObject {
+	return PendingObject{Ref: ref, Terminating: deletionTimestamp != nil, Finalizers: finalizers}
+}
@@ -0,0 +1,152 @@
+package kube
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const releaseManifest = `---
+# Source: redis/templates/configmap.yaml
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: redis
+---
+apiVersion: v1
+kind: PersistentVolumeClaim
+metadata:
+  name: redis-data
+  annotations:
+    helm.sh/resource-policy: keep
+---
+apiVersion: apps/v1
+kind: StatefulSet
+metadata:
+  name: redis-master
+  namespace: cache
+---
+apiVersion: example.io/v1
+kind: Backup
+metadata:
+  name: redis-backup
+`
+
+// Returns a client over fake clientsets holding the given objects
+func newDeletionClient(objects []runtime.Object, typed ...runtime.Object) *K8sClient {
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}, meta.RESTScopeNamespace)
+	mapper.Add(schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "StatefulSet"}, meta.RESTScopeNamespace)
+	mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "Namespace"}, meta.RESTScopeRoot)
+	return &K8sClient{
+		clientSet:     fake.NewSimpleClientset(typed...),
+		dynamicClient: dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), objects...),
+		mapper:        mapper,
+	}
+}
+
+// Returns an unstructured object of the given kind
+func newObject(apiVersion string, kind string, namespace string, name string, finalizers ...string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion(apiVersion)
+	obj.SetKind(kind)
+	obj.SetNamespace(namespace)
+	obj.SetName(name)
+	if len(finalizers) > 0 {
+		now := metav1.Now()
+		obj.SetDeletionTimestamp(&now)
+		obj.SetFinalizers(finalizers)
+	}
+	return obj
+}
+
+func Test_ReleaseObjects(t *testing.T) {
+	refs, err := ReleaseObjects(releaseManifest, "paas")
+	if err != nil {
+		t.Fatalf("Error reading release objects [%v]", err)
+	}
+	expected := []string{"ConfigMap/paas/redis", "StatefulSet/cache/redis-master", "Backup/paas/redis-backup"}
+	if len(refs) != len(expected) {
+		t.Fatalf("Expecting %v objects, got %v", expected, refs)
+	}
+	for idx, ref := range refs {
+		if ref.String() != expected[idx] {
+			t.Errorf("Expecting object %v, got %v", expected[idx], ref)
+		}
+	}
+}
+
+func Test_WaitForDeletion(t *testing.T) {
+	now := metav1.Now()
+	k8s := newDeletionClient(
+		[]runtime.Object{
+			newObject("apps/v1", "StatefulSet", "cache", "redis-master", "example.io/cleanup"),
+			newObject("v1", "Namespace", "", "paas"),
+		},
+		&v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "redis-master-0", Namespace: "paas", DeletionTimestamp: &now,
+			Labels: map[string]string{LabelInstance: "redis", LabelReleaseName: "redis"}}},
+		&v1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "redis-data", Namespace: "paas",
+			Labels: map[string]string{LabelInstance: "redis"}}},
+		&v1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "redis-data-0", Namespace: "paas", DeletionTimestamp: &now,
+			Finalizers: []string{"kubernetes.io/pvc-protection"}, Labels: map[string]string{LabelInstance: "redis"}}},
+	)
+	refs, err := ReleaseObjects(releaseManifest, "paas")
+	if err != nil {
+		panic(err.Error())
+	}
+	scope := DeletionScope{Refs: refs, Namespace: "paas", Selectors: ReleaseSelectors("redis")}
+
+	t.Run("pending-objects", func(t *testing.T) {
+		pending, err := k8s.PendingObjects(scope)
+		if err != nil {
+			t.Fatalf("Error getting pending objects [%v]", err)
+		}
+		// The config map is gone, the kind of the backup is unknown and the pod is listed once
+		expected := []string{
+			"StatefulSet/cache/redis-master stuck on finalizers [example.io/cleanup]",
+			"Pod/paas/redis-master-0 (terminating)",
+		}
+		if len(pending) != len(expected) {
+			t.Fatalf("Expecting pending objects %v, got %v", expected, pending)
+		}
+		for idx, p := range pending {
+			if p.String() != expected[idx] {
+				t.Errorf("Expecting pending object %v, got %v", expected[idx], p)
+			}
+		}
+	})
+	t.Run("pending-volumes", func(t *testing.T) {
+		volumesScope := scope
+		volumesScope.Volumes = true
+		pending, err := k8s.PendingObjects(volumesScope)
+		if err != nil {
+			t.Fatalf("Error getting pending objects [%v]", err)
+		}
+		// The retained volume claim is not waited for
+		if len(pending) != 3 || !strings.HasPrefix(pending[2].String(), "PersistentVolumeClaim/paas/redis-data-0 stuck on finalizers") {
+			t.Errorf("Expecting the volume claim being deleted to be pending, got %v", pending)
+		}
+	})
+	t.Run("wait-for-deletion-timeout", func(t *testing.T) {
+		pending, err := k8s.WaitForDeletion(scope, 0)
+		if err != nil || len(pending) != 2 {
+			t.Errorf("Expecting the pending objects once the timeout expires, got %v [%v]", pending, err)
+		}
+	})
+	t.Run("wait-for-deletion-done", func(t *testing.T) {
+		start := time.Now()
+		pending, err := k8s.WaitForDeletion(DeletionScope{Refs: refs[:1], Namespace: "paas"}, time.Minute)
+		if err != nil || len(pending) != 0 || time.Since(start) > time.Second {
+			t.Errorf("Expecting no pending objects, got %v [%v]", pending, err)
+		}
+	})
+}
@@ -0,0 +1,253 @@
+package kube
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const (
+	// ModifiedObject is the drift of an object whose live fields differ from the release
+	ModifiedObject = "modified"
+	// DeletedObject is the drift of an object of the release missing from the cluster
+	DeletedObject = "deleted"
+	// ExtraObject is the drift of an object labelled as part of the release but not in the release
+	ExtraObject = "extra"
+)
+
+// podSpecPaths are the paths of the pod templates of the workload kinds
+var podSpecPaths = map[string][]string{
+	"Pod":         {"spec"},
+	"Deployment":  {"spec", "template", "spec"},
+	"StatefulSet": {"spec", "template", "spec"},
+	"DaemonSet":   {"spec", "template", "spec"},
+	"ReplicaSet":  {"spec", "template", "spec"},
+	"Job":         {"spec", "template", "spec"},
+	"CronJob":     {"spec", "jobTemplate", "spec", "template", "spec"},
+}
+
+// FieldDrift is a field whose live value differs from the release
+type FieldDrift struct {
+	Path     string `json:"path"`
+	Expected string `json:"expected"`
+	Actual   string `json:"actual"`
+}
+
+// String returns the path of the field along with the expected and actual values
+func (fd FieldDrift) String() string {
+	return fmt.Sprintf("%v: expected %v, actual %v", fd.Path, quoteValue(fd.Expected), quoteValue(fd.Actual))
+}
+
+// ObjectDrift is an object of a release which drifted: modified, deleted or extra
+type ObjectDrift struct {
+	Ref    ObjectRef    `json:"object"`
+	Drift  string       `json:"drift"`
+	Fields []FieldDrift `json:"fields,omitempty"`
+}
+
+// DiffObjects compares the live objects with the desired objects of a release: the replica counts, container
+// images and environments, and config map data.  Objects labelled with any of the selectors (deployments,
+// statefulsets, daemonsets, services and config maps of the namespace) but not desired are reported as extra.
+func (k8s *K8sClient) DiffObjects(desired []*unstructured.Unstructured, namespace string, selectors []string) ([]ObjectDrift, error) {
+	drifts := make([]ObjectDrift, 0)
+	desiredRefs := map[string]bool{}
+	for _, obj := range desired {
+		ref := ObjectRef{APIVersion: obj.GetAPIVersion(), Kind: obj.GetKind(), Namespace: obj.GetNamespace(), Name: obj.GetName()}
+		ri, namespaced, err := k8s.resourceFor(obj.GroupVersionKind())
+		if err != nil {
+			// The kind itself is gone (eg a custom resource definition was deleted)
+			drifts = append(drifts, ObjectDrift{Ref: ref, Drift: DeletedObject})
+			continue
+		}
+		if !namespaced {
+			ref.Namespace = ""
+		} else if ref.Namespace == "" {
+			ref.Namespace = namespace
+		}
+		desiredRefs[ref.Kind+"/"+ref.Namespace+"/"+ref.Name] = true
+		live, err := namespacedResource(ri, ref.Namespace).Get(context.TODO(), ref.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			drifts = append(drifts, ObjectDrift{Ref: ref, Drift: DeletedObject})
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if fields := CompareObjects(obj, live); len(fields) > 0 {
+			drifts = append(drifts, ObjectDrift{Ref: ref, Drift: ModifiedObject, Fields: fields})
+		}
+	}
+	extra, err := k8s.labelledObjects(namespace, selectors)
+	if err != nil {
+		return nil, err
+	}
+	for _, ref := range extra {
+		if !desiredRefs[ref.Kind+"/"+ref.Namespace+"/"+ref.Name] {
+			drifts = append(drifts, ObjectDrift{Ref: ref, Drift: ExtraObject})
+		}
+	}
+	return drifts, nil
+}
+
+// CompareObjects returns the fields of the live object differing from the desired object: the replica count,
+// the images and environment of the containers of workloads, and the data of config maps
+func CompareObjects(desired *unstructured.Unstructured, live *unstructured.Unstructured) []FieldDrift {
+	fields := make([]FieldDrift, 0)
+	if replicas, found, _ := unstructured.NestedFieldNoCopy(desired.Object, "spec", "replicas"); found {
+		liveReplicas, _, _ := unstructured.NestedFieldNoCopy(live.Object, "spec", "replicas")
+		if fmt.Sprint(replicas) != fmt.Sprint(liveReplicas) {
+			fields = append(fields, FieldDrift{Path: "spec.replicas", Expected: fmt.Sprint(replicas), Actual: valueString(liveReplicas)})
+		}
+	}
+	if podSpecPath, isWorkload := podSpecPaths[desired.GetKind()]; isWorkload {
+		for _, containersKey := range []string{"initContainers", "containers"} {
+			path := append(append([]string{}, podSpecPath...), containersKey)
+			desiredContainers, _, _ := unstructured.NestedSlice(desired.Object, path...)
+			liveContainers, _, _ := unstructured.NestedSlice(live.Object, path...)
+			fields = append(fields, compareContainers(strings.Join(path, "."), desiredContainers, liveContainers)...)
+		}
+	}
+	if desired.GetKind() == "ConfigMap" {
+		desiredData, _, _ := unstructured.NestedStringMap(desired.Object, "data")
+		liveData, _, _ := unstructured.NestedStringMap(live.Object, "data")
+		fields = append(fields, compareValues("data", desiredData, liveData)...)
+	}
+	return fields
+}
+
+// compareContainers compares the image and environment of the desired containers with the live ones
+func compareContainers(path string, desired []interface{}, live []interface{}) []FieldDrift {
+	fields := make([]FieldDrift, 0)
+	liveByName := map[string]map[string]interface{}{}
+	for _, c := range live {
+		if container, ok := c.(map[string]interface{}); ok {
+			liveByName[fmt.Sprint(container["name"])] = container
+		}
+	}
+	for _, c := range desired {
+		container, ok := c.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		name := fmt.Sprint(container["name"])
+		containerPath := fmt.Sprintf("%v[%v]", path, name)
+		liveContainer, found := liveByName[name]
+		if !found {
+			fields = append(fields, FieldDrift{Path: containerPath, Expected: "present", Actual: ""})
+			continue
+		}
+		if image := fmt.Sprint(container["image"]); image != valueString(liveContainer["image"]) {
+			fields = append(fields, FieldDrift{Path: containerPath + ".image", Expected: image, Actual: valueString(liveContainer["image"])})
+		}
+		fields = append(fields, compareValues(containerPath+".env", envValues(container["env"]), envValues(liveContainer["env"]))...)
+	}
+	return fields
+}
+
+// envValues returns the values of an environment list by variable name (valueFrom references as json)
+func envValues(env interface{}) map[string]string {
+	values := map[string]string{}
+	vars, _ := env.([]interface{})
+	for _, v := range vars {
+		envVar, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if valueFrom, found := envVar["valueFrom"]; found {
+			valueBytes, _ := json.Marshal(valueFrom)
+			values[fmt.Sprint(envVar["name"])] = "valueFrom:" + string(valueBytes)
+		} else {
+			values[fmt.Sprint(envVar["name"])] = valueString(envVar["value"])
+		}
+	}
+	return values
+}
+
+// compareValues compares the desired values with the live ones, reporting changed, missing and added keys
+func compareValues(path string, desired map[string]string, live map[string]string) []FieldDrift {
+	fields := make([]FieldDrift, 0)
+	keys := make([]string, 0, len(desired)+len(live))
+	for k := range desired {
+		keys = append(keys, k)
+	}
+	for k := range live {
+		if _, found := desired[k]; !found {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		desiredValue, inDesired := desired[k]
+		liveValue, inLive := live[k]
+		if inDesired != inLive || desiredValue != liveValue {
+			fields = append(fields, FieldDrift{Path: path + "." + k, Expected: desiredValue, Actual: liveValue})
+		}
+	}
+	return fields
+}
+
+// labelledObjects returns the deployments, statefulsets, daemonsets, services and config maps of the namespace
+// labelled with any of the selectors
+func (k8s *K8sClient) labelledObjects(namespace string, selectors []string) ([]ObjectRef, error) {
+	refs := make([]ObjectRef, 0)
+	seen := map[string]bool{}
+	add := func(apiVersion string, kind string, meta metav1.ObjectMeta) {
+		ref := ObjectRef{APIVersion: apiVersion, Kind: kind, Namespace: meta.Namespace, Name: meta.Name}
+		if !seen[ref.String()] {
+			seen[ref.String()] = true
+			refs = append(refs, ref)
+		}
+	}
+	for _, selector := range selectors {
+		listOpts := metav1.ListOptions{LabelSelector: selector}
+		workloads, err := k8s.getWorkloads(namespace, listOpts)
+		if err != nil {
+			return nil, err
+		}
+		for _, o := range workloads.Deployments {
+			add("apps/v1", "Deployment", o.ObjectMeta)
+		}
+		for _, o := range workloads.StatefulSets {
+			add("apps/v1", "StatefulSet", o.ObjectMeta)
+		}
+		for _, o := range workloads.DaemonSets {
+			add("apps/v1", "DaemonSet", o.ObjectMeta)
+		}
+		services, err := k8s.clientSet.CoreV1().Services(namespace).List(context.TODO(), listOpts)
+		if err != nil {
+			return nil, err
+		}
+		for _, o := range services.Items {
+			add("v1", "Service", o.ObjectMeta)
+		}
+		configMaps, err := k8s.clientSet.CoreV1().ConfigMaps(namespace).List(context.TODO(), listOpts)
+		if err != nil {
+			return nil, err
+		}
+		for _, o := range configMaps.Items {
+			add("v1", "ConfigMap", o.ObjectMeta)
+		}
+	}
+	return refs, nil
+}
+
+// valueString returns the string representation of a value, empty for nil
+func valueString(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+	return fmt.Sprint(value)
+}
+
+// quoteValue returns the value for user messages, <none> if empty
+func quoteValue(value string) string {
+	if value == "" {
+		return "<none>"
+	}
+	return value
+}
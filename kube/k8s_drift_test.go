@@ -0,0 +1,146 @@
+package kube
+
+import (
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+const driftManifest = `apiVersion: apps/v1
+kind: StatefulSet
+metadata:
+  name: redis-master
+spec:
+  replicas: 1
+  template:
+    spec:
+      containers:
+        - name: redis
+          image: bitnami/redis:6.0.5
+          env:
+            - name: REDIS_PORT
+              value: "6379"
+            - name: REDIS_PASSWORD
+              valueFrom:
+                secretKeyRef:
+                  name: redis
+                  key: redis-password
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: redis
+data:
+  redis.conf: "maxmemory 64mb"
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: redis-scripts
+data:
+  ping.sh: "redis-cli ping"
+`
+
+// Returns the objects of the drift manifest
+func driftObjects() []*unstructured.Unstructured {
+	objects, err := ParseObjects([]byte(driftManifest))
+	if err != nil {
+		panic(err.Error())
+	}
+	return objects
+}
+
+func Test_CompareObjects(t *testing.T) {
+	t.Run("compare-in-sync", func(t *testing.T) {
+		desired := driftObjects()[0]
+		live := desired.DeepCopy()
+		// Fields set by the API server are not drift
+		unstructured.SetNestedField(live.Object, "OrderedReady", "spec", "podManagementPolicy")
+		if fields := CompareObjects(desired, live); len(fields) != 0 {
+			t.Errorf("Expecting no drift, got %v", fields)
+		}
+	})
+	t.Run("compare-workload", func(t *testing.T) {
+		desired := driftObjects()[0]
+		live := desired.DeepCopy()
+		unstructured.SetNestedField(live.Object, int64(3), "spec", "replicas")
+		unstructured.SetNestedSlice(live.Object, []interface{}{
+			map[string]interface{}{
+				"name":  "redis",
+				"image": "bitnami/redis:6.0.6",
+				"env": []interface{}{
+					map[string]interface{}{"name": "REDIS_PORT", "value": "6380"},
+					map[string]interface{}{"name": "DEBUG", "value": "true"},
+				},
+			},
+		}, "spec", "template", "spec", "containers")
+		fields := CompareObjects(desired, live)
+		expected := []string{
+			"spec.replicas: expected 1, actual 3",
+			"spec.template.spec.containers[redis].image: expected bitnami/redis:6.0.5, actual bitnami/redis:6.0.6",
+			"spec.template.spec.containers[redis].env.DEBUG: expected <none>, actual true",
+			`spec.template.spec.containers[redis].env.REDIS_PASSWORD: expected valueFrom:{"secretKeyRef":{"key":"redis-password","name":"redis"}}, actual <none>`,
+			"spec.template.spec.containers[redis].env.REDIS_PORT: expected 6379, actual 6380",
+		}
+		if len(fields) != len(expected) {
+			t.Fatalf("Expecting %v, got %v", expected, fields)
+		}
+		for idx, field := range fields {
+			if field.String() != expected[idx] {
+				t.Errorf("Expecting %v, got %v", expected[idx], field)
+			}
+		}
+	})
+	t.Run("compare-config-map", func(t *testing.T) {
+		desired := driftObjects()[1]
+		live := desired.DeepCopy()
+		unstructured.SetNestedStringMap(live.Object, map[string]string{"redis.conf": "maxmemory 128mb"}, "data")
+		fields := CompareObjects(desired, live)
+		if len(fields) != 1 || fields[0].Path != "data.redis.conf" || fields[0].Actual != "maxmemory 128mb" {
+			t.Errorf("Expecting the config map data to drift, got %v", fields)
+		}
+	})
+}
+
+func Test_DiffObjects(t *testing.T) {
+	objects := driftObjects()
+	live := objects[0].DeepCopy()
+	live.SetNamespace("paas")
+	unstructured.SetNestedField(live.Object, int64(2), "spec", "replicas")
+	configMap := objects[1].DeepCopy()
+	configMap.SetNamespace("paas")
+	labels := map[string]string{LabelInstance: "redis"}
+	k8s := newDeletionClient(
+		[]runtime.Object{live, configMap},
+		&appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "redis-master", Namespace: "paas", Labels: labels}},
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "redis-debug", Namespace: "paas", Labels: labels}},
+		&v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "redis", Namespace: "paas", Labels: labels}},
+	)
+	drifts, err := k8s.DiffObjects(objects, "paas", ReleaseSelectors("redis"))
+	if err != nil {
+		t.Fatalf("Error comparing objects [%v]", err)
+	}
+	expected := []struct {
+		ref   string
+		drift string
+	}{
+		{"StatefulSet/paas/redis-master", ModifiedObject},
+		{"ConfigMap/paas/redis-scripts", DeletedObject},
+		{"Deployment/paas/redis-debug", ExtraObject},
+	}
+	if len(drifts) != len(expected) {
+		t.Fatalf("Expecting %v, got %v", expected, drifts)
+	}
+	for idx, drift := range drifts {
+		if drift.Ref.String() != expected[idx].ref || drift.Drift != expected[idx].drift {
+			t.Errorf("Expecting %v %v, got %v %v", expected[idx].drift, expected[idx].ref, drift.Drift, drift.Ref)
+		}
+	}
+	if fields := drifts[0].Fields; len(fields) != 1 || fields[0].Path != "spec.replicas" {
+		t.Errorf("Expecting the replicas of redis-master to drift, got %v", fields)
+	}
+}
@@ -0,0 +1,103 @@
+package kube
+
+import (
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// ClientFactory builds the kubernetes client configuration shared by the helm action configuration and the
+// client-go clients, so that both address the same cluster with the same identity and rate limits.  It implements
+// the RESTClientGetter interface helm configurations are initialized with.
+type ClientFactory struct {
+	// KubeConfig is the path of the kube config file (KUBECONFIG or ~/.kube/config if empty)
+	KubeConfig string
+	// Context is the context of the kube config file (its current context if empty)
+	Context string
+	// Namespace is the default namespace of the objects without namespace (the context namespace if empty)
+	Namespace string
+	// Impersonate is the user to impersonate (no impersonation if empty)
+	Impersonate string
+	// ImpersonateGroups are the groups to impersonate
+	ImpersonateGroups []string
+	// QPS is the maximum queries per second to the API server (client-go default if 0)
+	QPS float32
+	// Burst is the maximum burst of queries to the API server (client-go default if 0)
+	Burst int
+}
+
+// ForNamespace returns a copy of the factory defaulting to the given namespace
+func (f *ClientFactory) ForNamespace(namespace string) *ClientFactory {
+	namespaced := *f
+	namespaced.Namespace = namespace
+	return &namespaced
+}
+
+// ToRawKubeConfigLoader returns the kube config loader with the context, namespace and impersonation overrides
+func (f *ClientFactory) ToRawKubeConfigLoader() clientcmd.ClientConfig {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = f.KubeConfig
+	overrides := &clientcmd.ConfigOverrides{
+		CurrentContext: f.Context,
+		Context:        clientcmdapi.Context{Namespace: f.Namespace},
+		AuthInfo: clientcmdapi.AuthInfo{
+			Impersonate:       f.Impersonate,
+			ImpersonateGroups: f.ImpersonateGroups,
+		},
+	}
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides)
+}
+
+// ToRESTConfig returns the REST client configuration of the cluster
+func (f *ClientFactory) ToRESTConfig() (*rest.Config, error) {
+	config, err := f.ToRawKubeConfigLoader().ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	if f.QPS > 0 {
+		config.QPS = f.QPS
+	}
+	if f.Burst > 0 {
+		config.Burst = f.Burst
+	}
+	return config, nil
+}
+
+// ToDiscoveryClient returns a discovery client of the cluster caching the API resources in memory
+func (f *ClientFactory) ToDiscoveryClient() (discovery.CachedDiscoveryInterface, error) {
+	config, err := f.ToRESTConfig()
+	if err != nil {
+		return nil, err
+	}
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return memory.NewMemCacheClient(discoveryClient), nil
+}
+
+// ToRESTMapper returns a mapper of the kinds to the API resources of the cluster
+func (f *ClientFactory) ToRESTMapper() (meta.RESTMapper, error) {
+	discoveryClient, err := f.ToDiscoveryClient()
+	if err != nil {
+		return nil, err
+	}
+	mapper := restmapper.NewDeferredDiscoveryRESTMapper(discoveryClient)
+	return &resettableMapper{RESTMapper: restmapper.NewShortcutExpander(mapper, discoveryClient), deferred: mapper}, nil
+}
+
+// resettableMapper is a REST mapper with shortcuts whose cached discovery can be reset, to map the kinds of the
+// CRDs created since the mapper was first used
+type resettableMapper struct {
+	meta.RESTMapper
+	deferred *restmapper.DeferredDiscoveryRESTMapper
+}
+
+// Reset drops the API resources cached by the mapper, they are discovered again on the next mapping
+func (m *resettableMapper) Reset() {
+	m.deferred.Reset()
+}
@@ -0,0 +1,87 @@
+package kube
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const factoryKubeConfig = `apiVersion: v1
+kind: Config
+clusters:
+  - name: management
+    cluster:
+      server: https://management.example.com:6443
+  - name: workload
+    cluster:
+      server: https://workload.example.com:6443
+users:
+  - name: admin
+    user:
+      token: secret
+contexts:
+  - name: management
+    context:
+      cluster: management
+      user: admin
+  - name: workload
+    context:
+      cluster: workload
+      user: admin
+      namespace: apps
+current-context: management
+`
+
+func Test_ClientFactory(t *testing.T) {
+	tmpDir, err := ioutil.TempDir(os.TempDir(), "kube-factory-*")
+	if err != nil {
+		panic(err.Error())
+	}
+	defer os.RemoveAll(tmpDir)
+	kubeConfigPath := filepath.Join(tmpDir, "kubeconfig")
+	if err := ioutil.WriteFile(kubeConfigPath, []byte(factoryKubeConfig), 0600); err != nil {
+		panic(err.Error())
+	}
+
+	t.Run("client-factory-config", func(t *testing.T) {
+		factory := &ClientFactory{
+			KubeConfig:        kubeConfigPath,
+			Context:           "workload",
+			Impersonate:       "deployer",
+			ImpersonateGroups: []string{"platform"},
+			QPS:               50,
+			Burst:             100,
+		}
+		config, err := factory.ToRESTConfig()
+		if err != nil {
+			t.Fatalf("Error loading the client configuration [%v]", err)
+		}
+		if config.Host != "https://workload.example.com:6443" || config.Impersonate.UserName != "deployer" ||
+			len(config.Impersonate.Groups) != 1 || config.QPS != 50 || config.Burst != 100 {
+			t.Errorf("Unexpected client configuration %v", config)
+		}
+		if ns, _, _ := factory.ToRawKubeConfigLoader().Namespace(); ns != "apps" {
+			t.Errorf("Expecting the namespace of the context, got %v", ns)
+		}
+		if ns, _, _ := factory.ForNamespace("paas").ToRawKubeConfigLoader().Namespace(); ns != "paas" || factory.Namespace != "" {
+			t.Errorf("Expecting the namespace paas on a copy of the factory, got %v", ns)
+		}
+	})
+	t.Run("client-factory-k8s-client", func(t *testing.T) {
+		k8s, err := NewK8sClient(kubeConfigPath)
+		if err != nil {
+			t.Fatalf("Error creating the kubernetes client [%v]", err)
+		}
+		if k8s.Host() != "https://management.example.com:6443" || k8s.Factory().KubeConfig != kubeConfigPath {
+			t.Errorf("Expecting the current context cluster, got %v", k8s.Host())
+		}
+		k8s, err = NewK8sClientForContext(kubeConfigPath, "workload")
+		if err != nil || k8s.Host() != "https://workload.example.com:6443" {
+			t.Errorf("Expecting the workload cluster, got %v [%v]", k8s.Host(), err)
+		}
+		if _, err := NewK8sClientForContext(kubeConfigPath, "missing"); err == nil {
+			t.Errorf("Expecting an error with an unknown context")
+		}
+	})
+}
@@ -0,0 +1,123 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// RunJob creates a new run of the job of the named latimer item.  Jobs cannot be updated, so the previous runs of
+// the job are deleted and the new run gets a generated name.
+func (k8s *K8sClient) RunJob(owner string, namespace string, spec batchv1.JobSpec) (*batchv1.Job, error) {
+	if err := k8s.DeleteJobs(owner, namespace); err != nil {
+		return nil, err
+	}
+	ownerLabel := OwnerLabelValue(owner)
+	if spec.Template.Spec.RestartPolicy == "" {
+		spec.Template.Spec.RestartPolicy = v1.RestartPolicyNever
+	}
+	if spec.Completions == nil && spec.Parallelism == nil {
+		// The readiness of jobs is checked against their completions
+		completions := int32(1)
+		spec.Completions = &completions
+	}
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: ownerLabel + "-",
+			Namespace:    namespace,
+			Labels:       map[string]string{LabelManagedBy: FieldManager, LabelOwner: ownerLabel},
+		},
+		Spec: spec,
+	}
+	return k8s.clientSet.BatchV1().Jobs(namespace).Create(context.TODO(), job, metav1.CreateOptions{})
+}
+
+// GetJob returns the latest run of the job of the named latimer item, or nil if it never ran
+func (k8s *K8sClient) GetJob(owner string, namespace string) (*batchv1.Job, error) {
+	jobs, err := k8s.listJobs(owner, namespace)
+	if err != nil {
+		return nil, err
+	}
+	var latest *batchv1.Job
+	for idx := range jobs {
+		job := &jobs[idx]
+		if job.DeletionTimestamp != nil {
+			continue
+		}
+		if latest == nil || latest.CreationTimestamp.Before(&job.CreationTimestamp) {
+			latest = job
+		}
+	}
+	return latest, nil
+}
+
+// DeleteJobs deletes all the runs of the job of the named latimer item, and their pods
+func (k8s *K8sClient) DeleteJobs(owner string, namespace string) error {
+	jobs, err := k8s.listJobs(owner, namespace)
+	if err != nil {
+		return err
+	}
+	propagation := metav1.DeletePropagationBackground
+	for _, job := range jobs {
+		err := k8s.clientSet.BatchV1().Jobs(namespace).Delete(context.TODO(), job.Name, metav1.DeleteOptions{PropagationPolicy: &propagation})
+		if err != nil {
+			return err
+		}
+		logrus.Infof("Deleted job %v/%v", namespace, job.Name)
+	}
+	return nil
+}
+
+// WaitForJob pauses for up to 'timeout' waiting for the latest run of the job of the named latimer item to
+// complete.  Returns an error if the job failed.
+func (k8s *K8sClient) WaitForJob(owner string, namespace string, timeout time.Duration) (bool, error) {
+	start := time.Now()
+	for {
+		job, err := k8s.GetJob(owner, namespace)
+		if err != nil {
+			return false, err
+		}
+		if job == nil {
+			return false, fmt.Errorf("No job found for %v in namespace %v", owner, namespace)
+		}
+		if JobFailed(job) {
+			return false, fmt.Errorf("Job %v/%v failed", namespace, job.Name)
+		}
+		rr := NewReleaseResources(owner)
+		rr.Jobs = append(rr.Jobs, *job)
+		if rr.ReleaseStatus() == Ready {
+			return true, nil
+		}
+		elapsed := time.Since(start)
+		if elapsed > timeout {
+			return false, nil
+		}
+		logrus.Debugf("Waiting for job %v [%v] Elapsed=%v\n", job.Name, namespace, elapsed)
+		time.Sleep(2 * time.Second)
+	}
+}
+
+// JobFailed returns whether the job failed (reached its backoff limit or active deadline)
+func JobFailed(job *batchv1.Job) bool {
+	for _, condition := range job.Status.Conditions {
+		if condition.Type == batchv1.JobFailed && condition.Status == v1.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
+
+// listJobs returns the runs of the job of the named latimer item
+func (k8s *K8sClient) listJobs(owner string, namespace string) ([]batchv1.Job, error) {
+	listOpts := metav1.ListOptions{LabelSelector: LabelOwner + "=" + OwnerLabelValue(owner)}
+	jobList, err := k8s.clientSet.BatchV1().Jobs(namespace).List(context.TODO(), listOpts)
+	if err != nil {
+		return nil, err
+	}
+	return jobList.Items, nil
+}
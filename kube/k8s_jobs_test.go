@@ -0,0 +1,60 @@
+package kube
+
+import (
+	"testing"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// Returns a run of the job of the given owner
+func newJobRun(owner string, name string, created time.Time, succeeded int32) *batchv1.Job {
+	completions := int32(1)
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			Namespace:         "db-paas",
+			Labels:            map[string]string{LabelManagedBy: FieldManager, LabelOwner: OwnerLabelValue(owner)},
+			CreationTimestamp: metav1.NewTime(created),
+		},
+		Spec:   batchv1.JobSpec{Completions: &completions},
+		Status: batchv1.JobStatus{Succeeded: succeeded},
+	}
+}
+
+func Test_GetJob(t *testing.T) {
+	now := time.Now()
+	failed := newJobRun("infra/migrate", "infra.migrate-b", now, 0)
+	failed.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobFailed, Status: v1.ConditionTrue}}
+	k8s := &K8sClient{clientSet: fake.NewSimpleClientset(
+		newJobRun("infra/migrate", "infra.migrate-a", now.Add(-time.Hour), 1),
+		failed,
+		newJobRun("seed", "seed-a", now, 1),
+	)}
+	t.Run("get-job-latest", func(t *testing.T) {
+		job, err := k8s.GetJob("infra/migrate", "db-paas")
+		if err != nil || job == nil || job.Name != "infra.migrate-b" || !JobFailed(job) {
+			t.Errorf("Expecting the latest (failed) run infra.migrate-b, got %v [%v]", job, err)
+		}
+		if _, err := k8s.WaitForJob("infra/migrate", "db-paas", time.Second); err == nil {
+			t.Errorf("Expecting an error waiting for a failed job")
+		}
+		if completed, err := k8s.WaitForJob("seed", "db-paas", time.Second); !completed || err != nil {
+			t.Errorf("Expecting job seed to be completed [%v]", err)
+		}
+	})
+	t.Run("delete-jobs", func(t *testing.T) {
+		if err := k8s.DeleteJobs("infra/migrate", "db-paas"); err != nil {
+			t.Fatalf("Error deleting jobs [%v]", err)
+		}
+		if job, _ := k8s.GetJob("infra/migrate", "db-paas"); job != nil {
+			t.Errorf("Expecting no run of job infra/migrate left, got %v", job.Name)
+		}
+		if job, _ := k8s.GetJob("seed", "db-paas"); job == nil {
+			t.Errorf("Expecting the runs of other jobs to be kept")
+		}
+	})
+}
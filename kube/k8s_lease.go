@@ -0,0 +1,242 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// LeaseHeldError is returned when a lease is held by another holder and has not expired
+type LeaseHeldError struct {
+	Namespace string
+	Name      string
+	Holder    string
+	Acquired  time.Time
+	Renewed   time.Time
+	Expires   time.Time
+}
+
+func (e *LeaseHeldError) Error() string {
+	return fmt.Sprintf("Lease %v/%v is held by %v (acquired %v, renewed %v, expires %v)", e.Namespace, e.Name, e.Holder,
+		e.Acquired.Format(time.RFC3339), e.Renewed.Format(time.RFC3339), e.Expires.Format(time.RFC3339))
+}
+
+// Lease is a coordination lease held by this process.  The lease expires unless renewed within its duration.
+type Lease struct {
+	Namespace string
+	Name      string
+	Holder    string
+	Duration  time.Duration
+
+	k8s  *K8sClient
+	stop chan struct{}
+	done sync.WaitGroup
+	// lock guards the fields updated by the renewal of the lease
+	lock    sync.Mutex
+	renewed time.Time
+	lost    error
+}
+
+// LeaseLostError is returned once a lease was taken over by another holder, or could not be renewed within its
+// duration (it may have expired and been taken since)
+type LeaseLostError struct {
+	Namespace string
+	Name      string
+	Reason    string
+}
+
+func (e *LeaseLostError) Error() string {
+	return fmt.Sprintf("Lease %v/%v lost: %v", e.Namespace, e.Name, e.Reason)
+}
+
+// AcquireLease takes the named lease for the holder.  A lease held by another holder is only taken over once it
+// has expired, or if force is set, otherwise a LeaseHeldError describing the holder is returned.
+func (k8s *K8sClient) AcquireLease(namespace string, name string, holder string, duration time.Duration, force bool) (*Lease, error) {
+	leases := k8s.clientSet.CoordinationV1().Leases(namespace)
+	now := metav1.NewMicroTime(time.Now())
+	seconds := int32(duration / time.Second)
+	transitions := int32(0)
+	lease, err := leases.Get(context.TODO(), name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		lease = &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: map[string]string{LabelManagedBy: FieldManager}},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       &holder,
+				LeaseDurationSeconds: &seconds,
+				AcquireTime:          &now,
+				RenewTime:            &now,
+				LeaseTransitions:     &transitions,
+			},
+		}
+		if _, err = leases.Create(context.TODO(), lease, metav1.CreateOptions{}); apierrors.IsAlreadyExists(err) {
+			return nil, fmt.Errorf("Lease %v/%v was acquired concurrently by another holder", namespace, name)
+		} else if err != nil {
+			return nil, err
+		}
+		return k8s.newLease(namespace, name, holder, duration), nil
+	} else if err != nil {
+		return nil, err
+	}
+	current := leaseHolder(lease)
+	if current != "" && current != holder && !leaseExpired(lease, now.Time) {
+		if !force {
+			return nil, leaseHeldError(lease)
+		}
+		logrus.Warningf("Forcing the unlock of lease %v/%v held by %v", namespace, name, current)
+	}
+	if current != holder {
+		if lease.Spec.LeaseTransitions != nil {
+			transitions = *lease.Spec.LeaseTransitions
+		}
+		transitions++
+		lease.Spec.LeaseTransitions = &transitions
+		lease.Spec.AcquireTime = &now
+	}
+	lease.Spec.HolderIdentity = &holder
+	lease.Spec.LeaseDurationSeconds = &seconds
+	lease.Spec.RenewTime = &now
+	// The update fails with a conflict if another holder changed the lease since it was read
+	if _, err := leases.Update(context.TODO(), lease, metav1.UpdateOptions{}); apierrors.IsConflict(err) {
+		return nil, fmt.Errorf("Lease %v/%v was acquired concurrently by another holder", namespace, name)
+	} else if err != nil {
+		return nil, err
+	}
+	return k8s.newLease(namespace, name, holder, duration), nil
+}
+
+func (k8s *K8sClient) newLease(namespace string, name string, holder string, duration time.Duration) *Lease {
+	return &Lease{Namespace: namespace, Name: name, Holder: holder, Duration: duration, k8s: k8s, renewed: time.Now()}
+}
+
+// Renew extends the lease by its duration.  An error is returned if the lease was taken over by another holder.
+func (l *Lease) Renew() error {
+	leases := l.k8s.clientSet.CoordinationV1().Leases(l.Namespace)
+	lease, err := leases.Get(context.TODO(), l.Name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if holder := leaseHolder(lease); holder != l.Holder {
+		return l.setLost(fmt.Sprintf("taken over by %v", holder))
+	}
+	now := metav1.NewMicroTime(time.Now())
+	lease.Spec.RenewTime = &now
+	if _, err = leases.Update(context.TODO(), lease, metav1.UpdateOptions{}); err != nil {
+		return err
+	}
+	l.lock.Lock()
+	l.renewed = now.Time
+	l.lock.Unlock()
+	return nil
+}
+
+// Lost returns why the lease was lost, nil while the lease is held
+func (l *Lease) Lost() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.lost
+}
+
+// setLost records the loss of the lease, the first reason is kept
+func (l *Lease) setLost(reason string) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.lost == nil {
+		l.lost = &LeaseLostError{Namespace: l.Namespace, Name: l.Name, Reason: reason}
+	}
+	return l.lost
+}
+
+// KeepAlive renews the lease in the background every interval, until the lease is released.  The lease is lost
+// (see Lost) once taken over by another holder, or if it could not be renewed within its duration.
+func (l *Lease) KeepAlive(interval time.Duration) {
+	l.stop = make(chan struct{})
+	l.done.Add(1)
+	go func() {
+		defer l.done.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-l.stop:
+				return
+			case <-ticker.C:
+				err := l.Renew()
+				if err == nil {
+					continue
+				}
+				l.lock.Lock()
+				expired := time.Since(l.renewed) >= l.Duration
+				l.lock.Unlock()
+				if expired {
+					l.setLost(fmt.Sprintf("not renewed for %v [%v]", l.Duration, err))
+				}
+				if lost := l.Lost(); lost != nil {
+					logrus.Errorf("%v", lost)
+					return
+				}
+				logrus.Warningf("Error renewing lease %v/%v [%v]", l.Namespace, l.Name, err)
+			}
+		}
+	}()
+}
+
+// Release stops the renewal of the lease and deletes it, unless it was taken over by another holder
+func (l *Lease) Release() error {
+	if l.stop != nil {
+		close(l.stop)
+		l.done.Wait()
+		l.stop = nil
+	}
+	leases := l.k8s.clientSet.CoordinationV1().Leases(l.Namespace)
+	lease, err := leases.Get(context.TODO(), l.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if holder := leaseHolder(lease); holder != l.Holder {
+		return l.setLost(fmt.Sprintf("taken over by %v", holder))
+	}
+	uid := lease.UID
+	err = leases.Delete(context.TODO(), l.Name, metav1.DeleteOptions{Preconditions: &metav1.Preconditions{UID: &uid}})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// leaseHolder returns the holder identity of the lease (empty if none)
+func leaseHolder(lease *coordinationv1.Lease) string {
+	if lease.Spec.HolderIdentity == nil {
+		return ""
+	}
+	return *lease.Spec.HolderIdentity
+}
+
+// leaseExpired returns whether the lease was not renewed within its duration
+func leaseExpired(lease *coordinationv1.Lease, now time.Time) bool {
+	if lease.Spec.RenewTime == nil || lease.Spec.LeaseDurationSeconds == nil {
+		return true
+	}
+	return now.After(lease.Spec.RenewTime.Add(time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second))
+}
+
+func leaseHeldError(lease *coordinationv1.Lease) *LeaseHeldError {
+	e := &LeaseHeldError{Namespace: lease.Namespace, Name: lease.Name, Holder: leaseHolder(lease)}
+	if lease.Spec.AcquireTime != nil {
+		e.Acquired = lease.Spec.AcquireTime.Time
+	}
+	if lease.Spec.RenewTime != nil {
+		e.Renewed = lease.Spec.RenewTime.Time
+		if lease.Spec.LeaseDurationSeconds != nil {
+			e.Expires = e.Renewed.Add(time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second)
+		}
+	}
+	return e
+}
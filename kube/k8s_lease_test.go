@@ -0,0 +1,111 @@
+package kube
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func Test_Lease(t *testing.T) {
+	k8s := &K8sClient{clientSet: fake.NewSimpleClientset()}
+	leases := k8s.clientSet.CoordinationV1().Leases("default")
+	getLease := func() *coordinationv1.Lease {
+		lease, err := leases.Get(context.TODO(), "latimer-lock-test", metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("Error getting lease [%v]", err)
+		}
+		return lease
+	}
+	var first *Lease
+	t.Run("acquire-lease", func(t *testing.T) {
+		lease, err := k8s.AcquireLease("default", "latimer-lock-test", "ci-1", time.Minute, false)
+		if err != nil {
+			t.Fatalf("Error acquiring lease [%v]", err)
+		}
+		first = lease
+		if holder := leaseHolder(getLease()); holder != "ci-1" {
+			t.Errorf("Expecting lease held by ci-1, got %v", holder)
+		}
+		// The holder can acquire its lease again
+		if _, err := k8s.AcquireLease("default", "latimer-lock-test", "ci-1", time.Minute, false); err != nil {
+			t.Errorf("Error acquiring lease again [%v]", err)
+		}
+	})
+	t.Run("acquire-held-lease", func(t *testing.T) {
+		_, err := k8s.AcquireLease("default", "latimer-lock-test", "ci-2", time.Minute, false)
+		var held *LeaseHeldError
+		if !errors.As(err, &held) || held.Holder != "ci-1" {
+			t.Fatalf("Expecting the lease to be held by ci-1, got %v", err)
+		}
+		if held.Expires.Sub(held.Renewed) != time.Minute {
+			t.Errorf("Expecting the lease to expire a minute after its renewal, got %v", held.Expires.Sub(held.Renewed))
+		}
+	})
+	var second *Lease
+	t.Run("force-unlock", func(t *testing.T) {
+		lease, err := k8s.AcquireLease("default", "latimer-lock-test", "ci-2", time.Minute, true)
+		if err != nil {
+			t.Fatalf("Error forcing lease [%v]", err)
+		}
+		second = lease
+		current := getLease()
+		if leaseHolder(current) != "ci-2" || *current.Spec.LeaseTransitions != 1 {
+			t.Errorf("Expecting lease taken over by ci-2, got %v after %v transitions", leaseHolder(current), *current.Spec.LeaseTransitions)
+		}
+		// The former holder can neither renew nor release the lease, and finds it lost
+		if first.Lost() != nil {
+			t.Errorf("Expecting the loss to be noticed on renewal only")
+		}
+		first.KeepAlive(time.Millisecond)
+		time.Sleep(20 * time.Millisecond)
+		var lost *LeaseLostError
+		if err := first.Lost(); !errors.As(err, &lost) || !strings.Contains(lost.Reason, "ci-2") {
+			t.Errorf("Expecting the lease to be lost to ci-2, got %v", err)
+		}
+		if err := first.Renew(); err == nil {
+			t.Errorf("Expecting an error renewing a lease taken over")
+		}
+		if err := first.Release(); err == nil {
+			t.Errorf("Expecting an error releasing a lease taken over")
+		}
+		if leaseHolder(getLease()) != "ci-2" {
+			t.Errorf("Expecting lease still held by ci-2")
+		}
+	})
+	t.Run("renew-release-lease", func(t *testing.T) {
+		renewed := getLease().Spec.RenewTime.Time
+		time.Sleep(10 * time.Millisecond)
+		second.KeepAlive(time.Millisecond)
+		time.Sleep(20 * time.Millisecond)
+		if !getLease().Spec.RenewTime.After(renewed) || second.Lost() != nil {
+			t.Errorf("Expecting the lease to be renewed")
+		}
+		if err := second.Release(); err != nil {
+			t.Fatalf("Error releasing lease [%v]", err)
+		}
+		list, err := leases.List(context.TODO(), metav1.ListOptions{})
+		if err != nil || len(list.Items) != 0 {
+			t.Errorf("Expecting the lease to be deleted, got %v [%v]", list, err)
+		}
+	})
+	t.Run("acquire-expired-lease", func(t *testing.T) {
+		if _, err := k8s.AcquireLease("default", "latimer-lock-test", "ci-1", time.Minute, false); err != nil {
+			t.Fatalf("Error acquiring lease [%v]", err)
+		}
+		lease := getLease()
+		renewed := metav1.NewMicroTime(time.Now().Add(-2 * time.Minute))
+		lease.Spec.RenewTime = &renewed
+		if _, err := leases.Update(context.TODO(), lease, metav1.UpdateOptions{}); err != nil {
+			panic(err.Error())
+		}
+		if _, err := k8s.AcquireLease("default", "latimer-lock-test", "ci-2", time.Minute, false); err != nil {
+			t.Errorf("Expecting an expired lease to be taken over, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,254 @@
+package kube
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/dynamic"
+)
+
+const (
+	// FieldManager is the field manager of the objects applied by latimer
+	FieldManager = "latimer"
+	// LabelManagedBy is the label key marking the objects managed by latimer
+	LabelManagedBy = "app.kubernetes.io/managed-by"
+	// LabelOwner is the label key holding the name of the latimer item owning an object
+	LabelOwner = "latimer.io/owner"
+	// inventoryPrefix is the name prefix of the config maps recording the objects applied for an owner
+	inventoryPrefix = "latimer-"
+	// inventoryKey is the config map key of the list of objects of an inventory
+	inventoryKey = "objects"
+	// kindDiscoveryTimeout is the time to wait for the kinds of the CRDs just applied to be served
+	kindDiscoveryTimeout = 60 * time.Second
+)
+
+var invalidNameChars = regexp.MustCompile(`[^a-z0-9.-]+`)
+
+// crdGroupKind is the kind of the custom resource definitions
+var crdGroupKind = schema.GroupKind{Group: "apiextensions.k8s.io", Kind: "CustomResourceDefinition"}
+
+// ObjectRef identifies a kubernetes object applied by latimer
+type ObjectRef struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Namespace  string `json:"namespace,omitempty"`
+	Name       string `json:"name"`
+}
+
+// String returns the kind/namespace/name representation of the reference
+func (ref ObjectRef) String() string {
+	if ref.Namespace == "" {
+		return ref.Kind + "/" + ref.Name
+	}
+	return ref.Kind + "/" + ref.Namespace + "/" + ref.Name
+}
+
+// OwnerLabelValue returns the owner label value of the named latimer item (names of included items hold a /)
+func OwnerLabelValue(owner string) string {
+	return strings.Trim(invalidNameChars.ReplaceAllString(strings.ToLower(owner), "."), ".-")
+}
+
+// ParseObjects decodes the objects of a multi document yaml (or json) stream.  Empty documents are skipped and
+// lists are expanded to their items.
+func ParseObjects(manifests []byte) ([]*unstructured.Unstructured, error) {
+	objects := make([]*unstructured.Unstructured, 0)
+	decoder := yaml.NewYAMLOrJSONDecoder(bytes.NewReader(manifests), 4096)
+	for {
+		content := map[string]interface{}{}
+		if err := decoder.Decode(&content); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if len(content) == 0 {
+			continue
+		}
+		obj := &unstructured.Unstructured{Object: content}
+		if obj.IsList() {
+			err := obj.EachListItem(func(item runtime.Object) error {
+				objects = append(objects, item.(*unstructured.Unstructured))
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if obj.GetKind() == "" || obj.GetName() == "" {
+			return nil, fmt.Errorf("Object without kind or name: %v", content)
+		}
+		objects = append(objects, obj)
+	}
+	return objects, nil
+}
+
+// ApplyObjects applies the objects with server side apply, labelled as owned by the named latimer item.  Namespaced
+// objects without a namespace are applied to the given namespace.  Objects owned by another latimer item are not
+// applied, nor are existing objects not managed by latimer unless adopt is set.  The kinds of the CRDs applied are
+// available to the objects which follow them.  Returns the references of the objects applied.
+func (k8s *K8sClient) ApplyObjects(owner string, namespace string, objects []*unstructured.Unstructured, adopt bool) ([]ObjectRef, error) {
+	ownerLabel := OwnerLabelValue(owner)
+	refs := make([]ObjectRef, 0, len(objects))
+	crdApplied := false
+	for _, obj := range objects {
+		ri, namespaced, err := k8s.resourceFor(obj.GroupVersionKind())
+		if err != nil && crdApplied {
+			ri, namespaced, err = k8s.waitForKind(obj.GroupVersionKind(), kindDiscoveryTimeout)
+		}
+		if err != nil {
+			return refs, err
+		}
+		if namespaced && obj.GetNamespace() == "" {
+			obj.SetNamespace(namespace)
+		} else if !namespaced {
+			obj.SetNamespace("")
+		}
+		ref := ObjectRef{APIVersion: obj.GetAPIVersion(), Kind: obj.GetKind(), Namespace: obj.GetNamespace(), Name: obj.GetName()}
+		client := namespacedResource(ri, ref.Namespace)
+		existing, err := client.Get(context.TODO(), ref.Name, metav1.GetOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return refs, err
+		}
+		if existing != nil && err == nil {
+			current, found := existing.GetLabels()[LabelOwner]
+			if found && current != ownerLabel {
+				return refs, fmt.Errorf("%v is owned by %v", ref, current)
+			} else if !found && !adopt {
+				return refs, fmt.Errorf("%v exists and is not managed by latimer, set adopt to take it over", ref)
+			} else if !found {
+				logrus.Warningf("Adopting %v", ref)
+			}
+		}
+
+		labels := obj.GetLabels()
+		if labels == nil {
+			labels = map[string]string{}
+		}
+		labels[LabelManagedBy] = FieldManager
+		labels[LabelOwner] = ownerLabel
+		obj.SetLabels(labels)
+		data, err := obj.MarshalJSON()
+		if err != nil {
+			return refs, err
+		}
+		force := true
+		_, err = client.Patch(context.TODO(), ref.Name, types.ApplyPatchType, data, metav1.PatchOptions{FieldManager: FieldManager, Force: &force})
+		if err != nil {
+			return refs, fmt.Errorf("Error applying %v: %v", ref, err)
+		}
+		logrus.Infof("Applied %v", ref)
+		refs = append(refs, ref)
+		if obj.GroupVersionKind().GroupKind() == crdGroupKind {
+			crdApplied = true
+		}
+	}
+	return refs, nil
+}
+
+// DeleteObjects deletes the referenced objects in reverse order, ignoring the objects already gone
+func (k8s *K8sClient) DeleteObjects(refs []ObjectRef) error {
+	propagation := metav1.DeletePropagationBackground
+	for idx := len(refs) - 1; idx >= 0; idx-- {
+		ref := refs[idx]
+		ri, _, err := k8s.resourceFor(schema.FromAPIVersionAndKind(ref.APIVersion, ref.Kind))
+		if err != nil {
+			return err
+		}
+		err = namespacedResource(ri, ref.Namespace).Delete(context.TODO(), ref.Name, metav1.DeleteOptions{PropagationPolicy: &propagation})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("Error deleting %v: %v", ref, err)
+		}
+		logrus.Infof("Deleted %v", ref)
+	}
+	return nil
+}
+
+// GetInventory returns the objects recorded as applied for the named latimer item.  Returns false if there is
+// no inventory (nothing applied).
+func (k8s *K8sClient) GetInventory(owner string, namespace string) ([]ObjectRef, bool, error) {
+	cm, err := k8s.clientSet.CoreV1().ConfigMaps(namespace).Get(context.TODO(), inventoryName(owner), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	refs := make([]ObjectRef, 0)
+	if err := json.Unmarshal([]byte(cm.Data[inventoryKey]), &refs); err != nil {
+		return nil, true, fmt.Errorf("Invalid inventory %v/%v: %v", namespace, cm.Name, err)
+	}
+	return refs, true, nil
+}
+
+// SaveInventory records the objects applied for the named latimer item in a config map of the namespace
+func (k8s *K8sClient) SaveInventory(owner string, namespace string, refs []ObjectRef) error {
+	refsBytes, err := json.Marshal(refs)
+	if err != nil {
+		return err
+	}
+	labels := map[string]string{LabelManagedBy: FieldManager, LabelOwner: OwnerLabelValue(owner)}
+	return k8s.SaveConfigMapValue(namespace, inventoryName(owner), inventoryKey, string(refsBytes), labels)
+}
+
+// DeleteInventory deletes the inventory of the named latimer item
+func (k8s *K8sClient) DeleteInventory(owner string, namespace string) error {
+	return k8s.DeleteConfigMap(namespace, inventoryName(owner))
+}
+
+// GetResourcesOwnedBy returns the runtime resources owned by the named latimer item
+func (k8s *K8sClient) GetResourcesOwnedBy(owner string) (*ReleaseResources, error) {
+	return k8s.getResourcesWithLabel(owner, LabelOwner, OwnerLabelValue(owner))
+}
+
+// resourceFor returns the dynamic client of the resource of the given kind and whether it is namespaced
+func (k8s *K8sClient) resourceFor(gvk schema.GroupVersionKind) (dynamic.NamespaceableResourceInterface, bool, error) {
+	mapping, err := k8s.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return nil, false, fmt.Errorf("Unknown kind %v: %v", gvk, err)
+	}
+	return k8s.dynamicClient.Resource(mapping.Resource), mapping.Scope.Name() == meta.RESTScopeNameNamespace, nil
+}
+
+// waitForKind resets the cached discovery of the API resources until the kind is served (the CRD defining it is
+// established) or the timeout expires
+func (k8s *K8sClient) waitForKind(gvk schema.GroupVersionKind, timeout time.Duration) (dynamic.NamespaceableResourceInterface, bool, error) {
+	start := time.Now()
+	for {
+		if resettable, ok := k8s.mapper.(interface{ Reset() }); ok {
+			resettable.Reset()
+		}
+		ri, namespaced, err := k8s.resourceFor(gvk)
+		if err == nil || time.Since(start) >= timeout {
+			return ri, namespaced, err
+		}
+		logrus.Debugf("Waiting for kind %v to be served", gvk)
+		time.Sleep(2 * time.Second)
+	}
+}
+
+// namespacedResource returns the client of the resource in the namespace (for namespaced resources)
+func namespacedResource(ri dynamic.NamespaceableResourceInterface, namespace string) dynamic.ResourceInterface {
+	if namespace == "" {
+		return ri
+	}
+	return ri.Namespace(namespace)
+}
+
+// inventoryName returns the name of the inventory config map of the named latimer item
+func inventoryName(owner string) string {
+	return inventoryPrefix + OwnerLabelValue(owner)
+}
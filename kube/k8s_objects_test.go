@@ -0,0 +1,160 @@
+package kube
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func Test_ParseObjects(t *testing.T) {
+	t.Run("parse-objects", func(t *testing.T) {
+		manifests := "---\n# empty document\n---\napiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: settings\n" +
+			"---\napiVersion: v1\nkind: List\nitems:\n  - apiVersion: v1\n    kind: ServiceAccount\n    metadata:\n      name: sa\n" +
+			"  - apiVersion: v1\n    kind: Secret\n    metadata:\n      name: creds\n      namespace: db\n" +
+			"---\n{\"apiVersion\": \"apps/v1\", \"kind\": \"Deployment\", \"metadata\": {\"name\": \"api\"}}\n"
+		objects, err := ParseObjects([]byte(manifests))
+		if err != nil {
+			t.Fatalf("Error parsing objects [%v]", err)
+		}
+		expected := []string{"ConfigMap/settings", "ServiceAccount/sa", "Secret/db/creds", "Deployment/api"}
+		if len(objects) != len(expected) {
+			t.Fatalf("Expecting %v, got %v objects", expected, len(objects))
+		}
+		for idx, obj := range objects {
+			ref := ObjectRef{APIVersion: obj.GetAPIVersion(), Kind: obj.GetKind(), Namespace: obj.GetNamespace(), Name: obj.GetName()}
+			if ref.String() != expected[idx] {
+				t.Errorf("Expecting %v, got %v", expected[idx], ref)
+			}
+		}
+		if _, err := ParseObjects([]byte("apiVersion: v1\nkind: ConfigMap\n")); err == nil {
+			t.Errorf("Expecting an error parsing an object without name")
+		}
+	})
+	t.Run("owner-label-value", func(t *testing.T) {
+		if value := OwnerLabelValue("infra/Redis_Config"); value != "infra.redis.config" {
+			t.Errorf("Unexpected owner label value %v", value)
+		}
+	})
+}
+
+func Test_Inventory(t *testing.T) {
+	k8s := &K8sClient{clientSet: fake.NewSimpleClientset()}
+	refs := []ObjectRef{
+		{APIVersion: "v1", Kind: "Namespace", Name: "paas"},
+		{APIVersion: "v1", Kind: "ConfigMap", Namespace: "paas", Name: "settings"},
+	}
+	t.Run("inventory-save", func(t *testing.T) {
+		if _, found, err := k8s.GetInventory("infra/config", "paas"); found || err != nil {
+			t.Errorf("Expecting no inventory [%v]", err)
+		}
+		if err := k8s.SaveInventory("infra/config", "paas", refs); err != nil {
+			t.Fatalf("Error saving inventory [%v]", err)
+		}
+		if err := k8s.SaveInventory("infra/config", "paas", refs[1:]); err != nil {
+			t.Fatalf("Error updating inventory [%v]", err)
+		}
+		saved, found, err := k8s.GetInventory("infra/config", "paas")
+		if !found || err != nil || len(saved) != 1 || saved[0] != refs[1] {
+			t.Errorf("Unexpected inventory %v [%v]", saved, err)
+		}
+	})
+	t.Run("inventory-delete", func(t *testing.T) {
+		if err := k8s.DeleteInventory("infra/config", "paas"); err != nil {
+			t.Errorf("Error deleting inventory [%v]", err)
+		}
+		if _, found, _ := k8s.GetInventory("infra/config", "paas"); found {
+			t.Errorf("Expecting the inventory to be deleted")
+		}
+		if err := k8s.DeleteInventory("infra/config", "paas"); err != nil {
+			t.Errorf("Expecting no error deleting a missing inventory [%v]", err)
+		}
+	})
+}
+
+// A REST mapper which serves the kinds of the CRDs once reset, as discovery does once they are established
+type crdMapper struct {
+	*meta.DefaultRESTMapper
+	resets int
+}
+
+func (m *crdMapper) Reset() {
+	m.resets++
+	m.Add(schema.GroupVersionKind{Group: "example.io", Version: "v1", Kind: "Backup"}, meta.RESTScopeNamespace)
+}
+
+// Returns a client applying objects to a fake dynamic client holding the given objects
+func newApplyClient(objects ...runtime.Object) (*K8sClient, *crdMapper) {
+	mapper := &crdMapper{DefaultRESTMapper: meta.NewDefaultRESTMapper(nil)}
+	mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}, meta.RESTScopeNamespace)
+	mapper.Add(schema.GroupVersionKind{Group: "apiextensions.k8s.io", Version: "v1", Kind: "CustomResourceDefinition"}, meta.RESTScopeRoot)
+	dynamicClient := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), objects...)
+	// The fake client does not support server side apply: the applied objects are served before the seeded ones
+	applied := map[string]*unstructured.Unstructured{}
+	key := func(action k8stesting.Action, name string) string {
+		return action.GetResource().String() + "/" + action.GetNamespace() + "/" + name
+	}
+	dynamicClient.PrependReactor("patch", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		patch := action.(k8stesting.PatchAction)
+		obj := &unstructured.Unstructured{}
+		if err := obj.UnmarshalJSON(patch.GetPatch()); err != nil {
+			return true, nil, err
+		}
+		applied[key(action, patch.GetName())] = obj
+		return true, obj, nil
+	})
+	dynamicClient.PrependReactor("get", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		obj, found := applied[key(action, action.(k8stesting.GetAction).GetName())]
+		return found, obj, nil
+	})
+	return &K8sClient{clientSet: fake.NewSimpleClientset(), dynamicClient: dynamicClient, mapper: mapper}, mapper
+}
+
+func Test_ApplyObjects(t *testing.T) {
+	t.Run("apply-objects-adopt", func(t *testing.T) {
+		k8s, _ := newApplyClient(newObject("v1", "ConfigMap", "paas", "settings"))
+		objects := []*unstructured.Unstructured{newObject("v1", "ConfigMap", "", "settings")}
+		if _, err := k8s.ApplyObjects("config", "paas", objects, false); err == nil || !strings.Contains(err.Error(), "not managed by latimer") {
+			t.Errorf("Expecting unmanaged config map not adopted [%v]", err)
+		}
+		refs, err := k8s.ApplyObjects("config", "paas", objects, true)
+		if err != nil || len(refs) != 1 {
+			t.Fatalf("Expecting unmanaged config map adopted: %v [%v]", refs, err)
+		}
+		gvr := schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+		adopted, err := k8s.dynamicClient.Resource(gvr).Namespace("paas").Get(context.TODO(), "settings", metav1.GetOptions{})
+		if err != nil || adopted.GetLabels()[LabelOwner] != "config" {
+			t.Errorf("Expecting adopted config map labelled with its owner: %v [%v]", adopted, err)
+		}
+		// Once owned, the config map is applied without adopt
+		if _, err := k8s.ApplyObjects("config", "paas", objects, false); err != nil {
+			t.Errorf("Error applying owned config map [%v]", err)
+		}
+		if _, err := k8s.ApplyObjects("other", "paas", objects, true); err == nil || !strings.Contains(err.Error(), "owned by config") {
+			t.Errorf("Expecting config map owned by another item not applied [%v]", err)
+		}
+	})
+
+	t.Run("apply-objects-crd", func(t *testing.T) {
+		k8s, mapper := newApplyClient()
+		objects := []*unstructured.Unstructured{
+			newObject("apiextensions.k8s.io/v1", "CustomResourceDefinition", "", "backups.example.io"),
+			newObject("example.io/v1", "Backup", "", "nightly"),
+		}
+		refs, err := k8s.ApplyObjects("backups", "paas", objects, false)
+		if err != nil || len(refs) != 2 || refs[1].Namespace != "paas" {
+			t.Errorf("Expecting the CRD and its custom resource applied: %v [%v]", refs, err)
+		}
+		if mapper.resets == 0 {
+			t.Errorf("Expecting the REST mapper reset after the CRD was applied")
+		}
+	})
+}
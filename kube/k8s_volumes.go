@@ -0,0 +1,67 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// VolumeClaim describes a persistent volume claim and the volume bound to it
+type VolumeClaim struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	// Volume is the name of the persistent volume bound to the claim (empty if not bound)
+	Volume       string `json:"volume,omitempty"`
+	Capacity     string `json:"capacity,omitempty"`
+	StorageClass string `json:"storageClass,omitempty"`
+}
+
+// String returns the namespace/name of the claim along with its volume
+func (vc VolumeClaim) String() string {
+	if vc.Volume == "" {
+		return vc.Namespace + "/" + vc.Name
+	}
+	return fmt.Sprintf("%v/%v (volume %v)", vc.Namespace, vc.Name, vc.Volume)
+}
+
+// GetVolumeClaims returns the persistent volume claims of the namespace matching any of the label selectors,
+// leaving out the claims being deleted
+func (k8s *K8sClient) GetVolumeClaims(namespace string, selectors []string) ([]VolumeClaim, error) {
+	claims := make([]VolumeClaim, 0)
+	seen := map[string]bool{}
+	for _, selector := range selectors {
+		pvcs, err := k8s.clientSet.CoreV1().PersistentVolumeClaims(namespace).List(context.TODO(), metav1.ListOptions{LabelSelector: selector})
+		if err != nil {
+			return nil, err
+		}
+		for _, pvc := range pvcs.Items {
+			if pvc.DeletionTimestamp != nil || seen[pvc.Name] {
+				continue
+			}
+			seen[pvc.Name] = true
+			claim := VolumeClaim{Namespace: pvc.Namespace, Name: pvc.Name, Volume: pvc.Spec.VolumeName}
+			if pvc.Spec.StorageClassName != nil {
+				claim.StorageClass = *pvc.Spec.StorageClassName
+			}
+			if capacity, found := pvc.Status.Capacity["storage"]; found {
+				claim.Capacity = capacity.String()
+			}
+			claims = append(claims, claim)
+		}
+	}
+	return claims, nil
+}
+
+// DeleteVolumeClaims deletes the persistent volume claims, ignoring the ones already gone.  The data of the
+// volumes is lost if their reclaim policy is Delete.
+func (k8s *K8sClient) DeleteVolumeClaims(claims []VolumeClaim) error {
+	for _, claim := range claims {
+		err := k8s.clientSet.CoreV1().PersistentVolumeClaims(claim.Namespace).Delete(context.TODO(), claim.Name, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("Error deleting volume claim %v: %v", claim, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package kube
+
+import (
+	"context"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// Returns a volume claim of the redis release bound to the given volume
+func newVolumeClaim(name string, volume string, labels map[string]string) *v1.PersistentVolumeClaim {
+	storageClass := "standard"
+	return &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "paas", Labels: labels},
+		Spec:       v1.PersistentVolumeClaimSpec{VolumeName: volume, StorageClassName: &storageClass},
+		Status:     v1.PersistentVolumeClaimStatus{Capacity: v1.ResourceList{"storage": resource.MustParse("8Gi")}},
+	}
+}
+
+func Test_VolumeClaims(t *testing.T) {
+	now := metav1.Now()
+	terminating := newVolumeClaim("redis-data-1", "pvc-2", map[string]string{LabelInstance: "redis"})
+	terminating.DeletionTimestamp = &now
+	k8s := &K8sClient{clientSet: fake.NewSimpleClientset(
+		newVolumeClaim("redis-data-0", "pvc-1", map[string]string{LabelInstance: "redis", LabelReleaseName: "redis"}),
+		newVolumeClaim("redis-legacy", "", map[string]string{LabelReleaseName: "redis"}),
+		newVolumeClaim("mysql-data-0", "pvc-3", map[string]string{LabelInstance: "mysql"}),
+		terminating,
+	)}
+	t.Run("get-volume-claims", func(t *testing.T) {
+		claims, err := k8s.GetVolumeClaims("paas", ReleaseSelectors("redis"))
+		if err != nil || len(claims) != 2 {
+			t.Fatalf("Expecting 2 volume claims, got %v [%v]", claims, err)
+		}
+		if claims[0].Name != "redis-data-0" || claims[0].Volume != "pvc-1" || claims[0].Capacity != "8Gi" || claims[0].StorageClass != "standard" {
+			t.Errorf("Unexpected volume claim %v", claims[0])
+		}
+		if claims[1].String() != "paas/redis-legacy" {
+			t.Errorf("Unexpected volume claim %v", claims[1])
+		}
+	})
+	t.Run("delete-volume-claims", func(t *testing.T) {
+		claims, _ := k8s.GetVolumeClaims("paas", ReleaseSelectors("redis"))
+		claims = append(claims, VolumeClaim{Namespace: "paas", Name: "gone"})
+		if err := k8s.DeleteVolumeClaims(claims); err != nil {
+			t.Fatalf("Error deleting volume claims [%v]", err)
+		}
+		pvcs, _ := k8s.clientSet.CoreV1().PersistentVolumeClaims("paas").List(context.TODO(), metav1.ListOptions{})
+		if len(pvcs.Items) != 2 {
+			t.Errorf("Expecting the mysql and terminating volume claims to be left, got %v", len(pvcs.Items))
+		}
+	})
+}
@@ -0,0 +1,28 @@
+package manifest
+
+import (
+	"latimer/core"
+	"latimer/helm"
+)
+
+// DriftReport is the drift of the releases of a manifest from their live objects
+type DriftReport struct {
+	Manifest string               `json:"manifest"`
+	Drifted  bool                 `json:"drifted"`
+	Releases []*helm.ReleaseDrift `json:"releases"`
+}
+
+// Drift compares the live objects of the releases of the (selected) charts with their release manifests
+func (m *Manifest) Drift(sc *core.SystemContext) (*DriftReport, error) {
+	report := &DriftReport{Manifest: m.GetID(), Releases: make([]*helm.ReleaseDrift, 0)}
+	for _, c := range m.selectedCharts() {
+		sysCtxt := *sc
+		drift, err := c.Drift(&sysCtxt)
+		if err != nil {
+			return nil, err
+		}
+		report.Drifted = report.Drifted || drift.Status != helm.InSync
+		report.Releases = append(report.Releases, drift)
+	}
+	return report, nil
+}
@@ -0,0 +1,83 @@
+package manifest
+
+import (
+	"fmt"
+	"latimer/core"
+	"latimer/kube"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// leasePrefix is the name prefix of the leases locking a manifest for the duration of a run
+	leasePrefix = "latimer-lock-"
+	// LeaseDuration is the time a manifest lease is held unless renewed (eg after the holder was killed)
+	LeaseDuration = 60 * time.Second
+	// leaseRenewal is the interval the lease of a running manifest is renewed at
+	leaseRenewal = LeaseDuration / 3
+)
+
+// AcquireLease locks the manifest in the cluster of the system context, and in the target clusters of its
+// (selected) charts, so that no other run installs or deletes it at the same time.  The leases are renewed in the
+// background until released, install and uninstall abort once one of them is lost.  A lease held by another run
+// is only taken over once expired or if force is set, otherwise a kube.LeaseHeldError is returned.
+func (m *Manifest) AcquireLease(sc *core.SystemContext, holder string, force bool) error {
+	// The charts without a target are installed in the cluster of the system context
+	targets := []string{""}
+	seen := map[string]bool{"": true, sc.Target: true}
+	for _, c := range m.selectedCharts() {
+		if target := c.Descriptor.Target; !seen[target] {
+			seen[target] = true
+			targets = append(targets, target)
+		}
+	}
+	for _, target := range targets {
+		lease, err := m.acquireTargetLease(sc, target, holder, force)
+		if err != nil {
+			m.ReleaseLease()
+			return err
+		}
+		lease.KeepAlive(leaseRenewal)
+		m.leases = append(m.leases, lease)
+	}
+	return nil
+}
+
+// acquireTargetLease takes the lease of the manifest in the named target cluster of the system context
+func (m *Manifest) acquireTargetLease(sc *core.SystemContext, target string, holder string, force bool) (*kube.Lease, error) {
+	targetSC, err := sc.ForTarget(target)
+	if err != nil {
+		return nil, err
+	}
+	lease, err := targetSC.GetKubeClient().AcquireLease(stateNamespace(targetSC), m.leaseName(), holder, LeaseDuration, force)
+	if err != nil && target != "" {
+		return nil, fmt.Errorf("Target %v: %w", target, err)
+	}
+	return lease, err
+}
+
+// ReleaseLease unlocks the manifest in all the clusters it was locked in
+func (m *Manifest) ReleaseLease() {
+	for _, lease := range m.leases {
+		if err := lease.Release(); err != nil {
+			logrus.Warningf("Error releasing lease %v/%v [%v]", lease.Namespace, lease.Name, err)
+		}
+	}
+	m.leases = nil
+}
+
+// leaseLost returns why a lease of the manifest was lost, nil while all of them are held
+func (m *Manifest) leaseLost() error {
+	for _, lease := range m.leases {
+		if err := lease.Lost(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// leaseName returns the name of the lease locking the manifest
+func (m *Manifest) leaseName() string {
+	return leasePrefix + kube.OwnerLabelValue(m.GetID())
+}
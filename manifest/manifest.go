@@ -6,8 +6,11 @@ import (
 	"fmt"
 	"latimer/core"
 	"latimer/helm"
+	"latimer/hook"
 	"latimer/kube"
 	"latimer/pkg"
+	"latimer/resource"
+	"sort"
 	"time"
 
 	"github.com/sirupsen/logrus"
@@ -24,36 +27,117 @@ type Manifest struct {
 	// The descriptor of the manifest
 	Descriptor *core.ManifestDescriptor
 
-	charts       map[string]*helm.Chart
-	packages     map[string]*pkg.Package
+	charts    map[string]*helm.Chart
+	packages  map[string]*pkg.Package
+	resources map[string]*resource.Resource
+	hooks     map[string]*hook.Hook
+	// phaseHooks holds the hooks run before or after all the items, by phase, in manifest order
+	phaseHooks   map[string][]*hook.Hook
+	manifests    map[string][]core.InstallableItem
 	dependencies map[string][]core.InstallableItem
+	skipped      []SkippedItem
+	// selected holds the names of the items to install/uninstall (nil for all)
+	selected map[string]bool
+	// leases lock the manifest in its clusters for the duration of the run (see AcquireLease)
+	leases []*kube.Lease
 }
 
-// NewManifest creates a new manifest object from file contents
-func NewManifest(filePath string, values map[string]string) (*Manifest, error) {
+// SkippedItem describes an item of the manifest excluded from install/uninstall
+type SkippedItem struct {
+	core.InstallableItem
+	// Reason tells why the item was skipped
+	Reason string `json:"reason"`
+}
+
+// NewManifest creates a new manifest object from file contents.  The environment (if not empty) selects the
+// manifest profile to apply.
+func NewManifest(filePath string, values map[string]string, environment string) (*Manifest, error) {
 	m := new(Manifest)
 
-	descriptor, err := core.LoadManifestDescriptor(filePath, values)
+	descriptor, err := core.LoadManifestDescriptor(filePath, values, environment)
 	if err != nil {
 		return nil, err
 	}
 	m.Descriptor = descriptor
 	m.charts = map[string]*helm.Chart{}
 	m.packages = map[string]*pkg.Package{}
+	m.resources = map[string]*resource.Resource{}
+	m.hooks = map[string]*hook.Hook{}
+	m.phaseHooks = map[string][]*hook.Hook{}
+	m.manifests = map[string][]core.InstallableItem{}
 	m.dependencies = map[string][]core.InstallableItem{}
+	m.skipped = make([]SkippedItem, 0)
 
 	manifestID := m.GetID()
 	manifestDeps := make([]core.InstallableItem, 0)
+	// The items merged from included manifests are templated with the values of their manifest
+	templateValues := func(name string) map[string]string {
+		return descriptor.ScopeOf(name).TemplateValues
+	}
+	// Evaluate the packages first since a disabled package disables all the charts it contains
+	disabledCharts := map[string]string{}
+	for idx := range descriptor.Packages {
+		p := &descriptor.Packages[idx]
+		if enabled, reason := p.IsEnabled(templateValues(p.Name)); !enabled {
+			m.skip(core.InstallableItem{Name: p.Name, Kind: core.PackageType}, reason)
+			for _, pkgChart := range p.Charts {
+				disabledCharts[pkgChart.Name] = "package " + p.Name + " is disabled"
+			}
+		}
+	}
 	// Index the charts by name into a map
 	for idx, c := range descriptor.Charts {
-		m.charts[c.Name] = helm.NewChart(&(descriptor.Charts[idx]))
-		manifestDeps = append(manifestDeps, core.InstallableItem{
+		chartItem := core.InstallableItem{
 			Name: c.Name,
 			Kind: core.ChartType,
-		})
+		}
+		if enabled, reason := c.IsEnabled(templateValues(c.Name)); !enabled {
+			m.skip(chartItem, reason)
+			continue
+		}
+		if reason, found := disabledCharts[c.Name]; found {
+			m.skip(chartItem, reason)
+			continue
+		}
+		m.charts[c.Name] = helm.NewChart(&(descriptor.Charts[idx]), templateValues(c.Name))
+		manifestDeps = append(manifestDeps, chartItem)
+	}
+	// Index the yaml manifests and kustomize resources by name into a map
+	for idx, r := range descriptor.Resources {
+		resourceItem := core.InstallableItem{
+			Name: r.Name,
+			Kind: r.Kind,
+		}
+		if enabled, reason := r.IsEnabled(templateValues(r.Name)); !enabled {
+			m.skip(resourceItem, reason)
+			continue
+		}
+		m.resources[r.Name] = resource.NewResource(&(descriptor.Resources[idx]))
+		manifestDeps = append(manifestDeps, resourceItem)
+	}
+	// Index the hooks by name into a map, hooks with a phase are kept out of the dependency graph
+	for idx, h := range descriptor.Hooks {
+		hookItem := core.InstallableItem{
+			Name: h.Name,
+			Kind: core.JobType,
+		}
+		if enabled, reason := h.IsEnabled(templateValues(h.Name)); !enabled {
+			m.skip(hookItem, reason)
+			continue
+		}
+		if h.Phase != "" {
+			m.phaseHooks[h.Phase] = append(m.phaseHooks[h.Phase], hook.NewHook(&(descriptor.Hooks[idx])))
+			continue
+		}
+		m.hooks[h.Name] = hook.NewHook(&(descriptor.Hooks[idx]))
+		manifestDeps = append(manifestDeps, hookItem)
 	}
 	// Index the packages by name into a map
-	for _, p := range descriptor.Packages {
+	for idx := range descriptor.Packages {
+		p := &descriptor.Packages[idx]
+		if m.isSkipped(p.Name) {
+			continue
+		}
 		charts := make([]*helm.Chart, 0)
 		for _, pkgChart := range p.Charts {
 			name := pkgChart.Name
@@ -62,20 +146,84 @@ func NewManifest(filePath string, values map[string]string) (*Manifest, error) {
 				charts = append(charts, helmChart)
 			}
 		}
-		m.packages[p.Name] = pkg.NewPackage(&p, charts)
+		m.packages[p.Name] = pkg.NewPackage(p, charts)
 		manifestDeps = append(manifestDeps, core.InstallableItem{
 			Name: p.Name,
 			Kind: core.PackageType,
 		})
 	}
-	// Index the dependencies
+	// Index the dependencies, applying the policy for dependencies on disabled items
 	for _, dItem := range descriptor.DependencyItems {
-		m.dependencies[dItem.Name] = dItem.Requires
+		if m.isSkipped(dItem.Name) {
+			continue
+		}
+		requires := make([]core.InstallableItem, 0)
+		for _, r := range dItem.Requires {
+			if !m.isSkipped(r.Name) {
+				requires = append(requires, r)
+				continue
+			}
+			if descriptor.ScopeOf(dItem.Name).DisabledDependencies == core.FailDisabledDependencies {
+				return nil, fmt.Errorf("%v requires %v %v which is disabled (%v)", dItem.Name, r.Kind, r.Name, m.skipReason(r.Name))
+			}
+			logrus.Infof("Dropping dependency of %v on disabled %v %v", dItem.Name, r.Kind, r.Name)
+		}
+		m.dependencies[dItem.Name] = requires
+	}
+	// Index the included manifests, which depend on all their enabled items
+	for _, included := range descriptor.Included {
+		items := make([]core.InstallableItem, 0)
+		for _, item := range included.Items {
+			if !m.isSkipped(item.Name) {
+				items = append(items, item)
+			}
+		}
+		m.manifests[included.Name] = items
+		m.dependencies[included.Name] = append(m.dependencies[included.Name], items...)
+		manifestDeps = append(manifestDeps, core.InstallableItem{
+			Name: included.Name,
+			Kind: core.ManifestType,
+		})
 	}
 	m.dependencies[manifestID] = manifestDeps
 	return m, nil
 }
 
+// Plan returns the ordered list of items to install and the list of items skipped
+func (m *Manifest) Plan() ([]core.InstallableItem, []SkippedItem) {
+	return m.installList(), m.skipped
+}
+
+// HookNames returns the names of the hooks run in the given phase, in run order
+func (m *Manifest) HookNames(phase string) []string {
+	names := make([]string, 0, len(m.phaseHooks[phase]))
+	for _, h := range m.phaseHooks[phase] {
+		names = append(names, h.Name)
+	}
+	return names
+}
+
+// skip records an item as excluded from install/uninstall
+func (m *Manifest) skip(item core.InstallableItem, reason string) {
+	logrus.Infof("%v %v is disabled, skipping (%v)", item.Kind, item.Name, reason)
+	m.skipped = append(m.skipped, SkippedItem{InstallableItem: item, Reason: reason})
+}
+
+// isSkipped returns whether the named item has been excluded from install/uninstall
+func (m *Manifest) isSkipped(name string) bool {
+	return m.skipReason(name) != ""
+}
+
+// skipReason returns the reason why the named item was skipped, or the empty string if not skipped
+func (m *Manifest) skipReason(name string) string {
+	for _, item := range m.skipped {
+		if item.Name == name {
+			return item.Reason
+		}
+	}
+	return ""
+}
+
 // GetID returns the identifier name for this Installable.
 func (m *Manifest) GetID() string {
 	return m.Descriptor.Metadata.Name
@@ -99,14 +247,38 @@ func (m *Manifest) StringYaml() string {
 	return string(manifestBytes)
 }
 
-// Install the contents of the installable
+// Install the contents of the installable.  Returns false if any item failed to install, the items after a
+// failed one are still installed.
 func (m *Manifest) Install(sc *core.SystemContext) bool {
+	return m.install(sc, false)
+}
+
+// Upgrade installs the manifest like Install, upgrading the releases of the charts already installed to the chart
+// versions and values of the manifest
+func (m *Manifest) Upgrade(sc *core.SystemContext) bool {
+	return m.install(sc, true)
+}
+
+// install installs the items of the manifest in install order, upgrading the existing releases if upgrade is set
+func (m *Manifest) install(sc *core.SystemContext, upgrade bool) bool {
 	installList := m.installList()
 	fmt.Printf("Installing manifest: %v [%v]\n", m.Descriptor.Metadata.Name, installList)
+	if !m.runHooks(sc, core.PreInstallPhase) {
+		return false
+	}
+	status := true
 	for _, installItem := range installList {
+		if err := m.leaseLost(); err != nil {
+			logrus.Errorf("Install of manifest %v aborted before %v: %v", m.GetID(), installItem.Name, err)
+			return false
+		}
 		// Clone the system context and override values.
 		sysCtxt := *sc
-		m.waitForDependencies(&sysCtxt, installItem.Name)
+		if err := m.waitForDependencies(&sysCtxt, installItem.Name); err != nil {
+			logrus.Errorf("Cannot install %v, its dependencies are not ready [%v]", installItem.Name, err)
+			status = false
+			continue
+		}
 		switch installItem.Kind {
 		case core.ChartType:
 			hc, found := m.charts[installItem.Name]
@@ -115,44 +287,134 @@ func (m *Manifest) Install(sc *core.SystemContext) bool {
 			}
 			c := hc.Descriptor
 			releaseName := c.ReleaseName
+			if upgrade {
+				fmt.Printf("Upgrading chart: %v\n", hc.Name)
+				status = hc.Upgrade(&sysCtxt) && status
+				logrus.Infof("Upgraded HELM chart %v", releaseName)
+				break
+			}
 			fmt.Printf("Installing chart: %v\n", hc.Name)
-			hc.Install(&sysCtxt)
+			status = hc.Install(&sysCtxt) && status
 			logrus.Infof("Installed HELM chart %v", releaseName)
 		case core.PackageType:
 			p := m.packages[installItem.Name]
+			if upgrade {
+				fmt.Printf("Upgrading package: %v\n", p.Name)
+				status = p.Upgrade(&sysCtxt) && status
+				fmt.Printf("Upgraded Package %v\n", p.Name)
+				break
+			}
 			fmt.Printf("Installing package: %v\n", p.Name)
-			p.Install(&sysCtxt)
+			status = p.Install(&sysCtxt) && status
 			fmt.Printf("Installed Package %v\n", p.Name)
+		case core.ManifestsType, core.KustomizeType:
+			r := m.resources[installItem.Name]
+			fmt.Printf("Installing %v: %v\n", installItem.Kind, r.Name)
+			status = r.Install(&sysCtxt) && status
+			logrus.Infof("Installed %v %v", installItem.Kind, r.Name)
+		case core.JobType:
+			h := m.hooks[installItem.Name]
+			fmt.Printf("Running hook: %v\n", h.Name)
+			status = h.Install(&sysCtxt) && status
 		case core.ManifestType:
 			fmt.Printf("Installed manifest: %v\n", installItem.Name)
 		}
 	}
-	return true
+	// Failed charts are recorded as well, their release may exist
+	if err := m.recordReleases(sc); err != nil {
+		logrus.Warningf("Cannot record the releases of manifest %v [%v]", m.GetID(), err)
+	}
+	if !status {
+		return false
+	}
+	return m.runHooks(sc, core.PostInstallPhase)
 }
 
-// Uninstall the contents of this installable
+// Wait waits for all the (selected) items of the manifest to be ready, each within its timeout
+func (m *Manifest) Wait(sc *core.SystemContext) error {
+	for _, item := range m.installList() {
+		if err := m.waitForItem(sc, m.GetID(), item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Uninstall the contents of this installable.  Items are uninstalled in reverse install order, each one once the
+// previous one is fully deleted.  Uninstall halts at the first failed item, so that the items it requires (eg an
+// operator handling its finalizers) are left in place.
 func (m *Manifest) Uninstall(sc *core.SystemContext) bool {
 	manifestID := m.GetID()
 	installList := m.installList()
 	logrus.Infof("Uninstall manifest %v : [%v]", manifestID, installList)
+	if !m.runHooks(sc, core.PreDeletePhase) {
+		return false
+	}
 	for idx := len(installList) - 1; idx >= 0; idx-- {
 		installItem := installList[idx]
+		if err := m.leaseLost(); err != nil {
+			logrus.Errorf("Uninstall of manifest %v aborted before %v: %v", manifestID, installItem.Name, err)
+			return false
+		}
 		sysCtxt := *sc
+		status := true
 		logrus.Infof("Uninstalling item: %v %v", installItem.Name, installItem.Kind)
 		switch installItem.Kind {
 		case core.ChartType:
 			hc := m.charts[installItem.Name]
 			c := hc.Descriptor
 			releaseName := c.ReleaseName
-			hc.Uninstall(&sysCtxt)
+			status = hc.Uninstall(&sysCtxt)
 			logrus.Infof("Uninstalled HELM chart %v", releaseName)
 		case core.PackageType:
 			p := m.packages[installItem.Name]
-			p.Uninstall(&sysCtxt)
+			status = p.Uninstall(&sysCtxt)
 			logrus.Infof("Uninstalled Package %v", p.Name)
+		case core.ManifestsType, core.KustomizeType:
+			r := m.resources[installItem.Name]
+			status = r.Uninstall(&sysCtxt)
+			logrus.Infof("Uninstalled %v %v", installItem.Kind, r.Name)
+		case core.JobType:
+			h := m.hooks[installItem.Name]
+			status = h.Uninstall(&sysCtxt)
+			logrus.Infof("Deleted jobs of hook %v", h.Name)
 		case core.ManifestType:
 			logrus.Infof("Uninstalled manifest %v", installItem.Name)
 		}
+		if !status {
+			remaining := make([]string, 0, idx)
+			for _, item := range installList[:idx] {
+				remaining = append(remaining, item.Name)
+			}
+			logrus.Errorf("Uninstall of manifest %v halted: %v failed, left installed %v", manifestID, installItem.Name, remaining)
+			return false
+		}
+	}
+	// Clean up the jobs of the hooks run around the items
+	for _, phase := range []string{core.PreInstallPhase, core.PostInstallPhase, core.PreDeletePhase} {
+		for _, h := range m.phaseHooks[phase] {
+			sysCtxt := *sc
+			h.Uninstall(&sysCtxt)
+		}
+	}
+	if err := m.forgetReleases(sc); err != nil {
+		logrus.Warningf("Cannot record the releases of manifest %v [%v]", m.GetID(), err)
+	}
+	return true
+}
+
+// runHooks runs the hooks of the given phase in manifest order, stopping at the first failure
+func (m *Manifest) runHooks(sc *core.SystemContext, phase string) bool {
+	for _, h := range m.phaseHooks[phase] {
+		if err := m.leaseLost(); err != nil {
+			logrus.Errorf("Hooks of manifest %v aborted before %v: %v", m.GetID(), h.Name, err)
+			return false
+		}
+		sysCtxt := *sc
+		fmt.Printf("Running %v hook: %v\n", phase, h.Name)
+		if !h.Install(&sysCtxt) {
+			return false
+		}
 	}
 	return true
 }
@@ -166,41 +428,137 @@ func (m *Manifest) Status(sc *core.SystemContext) kube.InstallStatus {
 			return kube.NotReady
 		}
 	}
+	for _, r := range m.resources {
+		resourceSC := *sc
+		if r.Status(&resourceSC) != kube.Ready {
+			return kube.NotReady
+		}
+	}
 	return kube.Ready
 }
 
+// Lock resolves the exact version and digest of every chart of the manifest
+func (m *Manifest) Lock(sc *core.SystemContext) (*core.LockFile, error) {
+	lf := &core.LockFile{
+		Manifest:  m.GetID(),
+		Generated: time.Now().UTC(),
+		Charts:    make([]core.LockedChart, 0, len(m.charts)),
+	}
+	names := make([]string, 0, len(m.charts))
+	for name := range m.charts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		locked, err := m.charts[name].Resolve(sc)
+		if err != nil {
+			return nil, fmt.Errorf("Error resolving chart %v: %v", name, err)
+		}
+		logrus.Infof("Locked chart %v to version %v [%v]", name, locked.Version, locked.Digest)
+		lf.Charts = append(lf.Charts, *locked)
+	}
+	return lf, nil
+}
+
+// Bundle packages the charts of the manifest, their resolved values and the images they reference in the bundle
+// directory
+func (m *Manifest) Bundle(sc *core.SystemContext, dir string) (*core.BundleIndex, error) {
+	bundle := &core.BundleIndex{
+		Manifest: m.GetID(),
+		Created:  time.Now().UTC(),
+		Charts:   make([]core.BundledChart, 0, len(m.charts)),
+		Dir:      dir,
+	}
+	names := make([]string, 0, len(m.charts))
+	for name := range m.charts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		bundled, err := m.charts[name].Bundle(sc, bundle)
+		if err != nil {
+			return nil, fmt.Errorf("Error bundling chart %v: %v", name, err)
+		}
+		logrus.Infof("Bundled chart %v version %v with %v images", name, bundled.Version, len(bundled.Images))
+		bundle.Charts = append(bundle.Charts, *bundled)
+	}
+	return bundle, nil
+}
+
 // Wait for all dependencies before installing the given itemID
 func (m *Manifest) waitForDependencies(sc *core.SystemContext, itemID string) error {
-	depItems, found := m.dependencies[itemID]
-	if found {
-		for _, item := range depItems {
-			// Default 5 minutes
-			timeout := 300 * time.Second
-
-			var installable core.Installable = nil
-			chart, foundChart := m.charts[item.Name]
-			if foundChart {
-				installable = chart
-				timeout = time.Duration(chart.Descriptor.Timeout) * time.Second
-			} else {
-				pkg, foundPkg := m.charts[item.Name]
-				if foundPkg {
-					installable = pkg
-				}
+	for _, item := range m.selectedDependencies(itemID) {
+		if err := m.waitForItem(sc, itemID, item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// selectedDependencies returns the items the named item requires which take part in install.  The prerequisites
+// left out of the selection are not waited for, nor the charts left out of a package.
+func (m *Manifest) selectedDependencies(itemID string) []core.InstallableItem {
+	items := make([]core.InstallableItem, 0)
+	for _, item := range m.dependencies[itemID] {
+		if !m.isSelected(item.Name) {
+			logrus.Infof("%v not waiting for %v, left out of the selection", itemID, item.Name)
+			continue
+		}
+		p, isPackage := m.packages[item.Name]
+		if !isPackage || m.selected == nil {
+			items = append(items, item)
+			continue
+		}
+		charts := make([]core.InstallableItem, 0)
+		for _, c := range p.Charts {
+			if m.isSelected(c.Name) {
+				charts = append(charts, core.InstallableItem{Name: c.Name, Kind: core.ChartType})
 			}
-			if installable != nil {
-				fmt.Printf("@@@@@@@ %v waiting for dependency %v to complete install\n", itemID, installable.GetID())
-				start := time.Now()
-				for installable.Status(sc) != kube.Ready {
-					time.Sleep(2 * time.Second)
-					end := time.Now()
-					elapsed := end.Sub(start)
-					if elapsed > timeout {
-						return errors.New("Timeout expired for: " + installable.GetID())
-					}
-					logrus.Debugf("       Waiting for release %v Elapsed=%v", installable.GetID(), elapsed)
-				}
+		}
+		if len(charts) == len(p.Charts) {
+			items = append(items, item)
+		} else {
+			items = append(items, charts...)
+		}
+	}
+	return items
+}
+
+// waitForItem waits for the given item, required by itemID, to be ready within its timeout
+func (m *Manifest) waitForItem(sc *core.SystemContext, itemID string, item core.InstallableItem) error {
+	// Default 5 minutes
+	timeout := 300 * time.Second
+
+	var installable core.Installable = nil
+	chart, foundChart := m.charts[item.Name]
+	if foundChart {
+		installable = chart
+		timeout = time.Duration(chart.Descriptor.Timeout) * time.Second
+	} else if pkg, foundPkg := m.packages[item.Name]; foundPkg {
+		installable = pkg
+	} else if r, foundResource := m.resources[item.Name]; foundResource {
+		installable = r
+		timeout = time.Duration(r.Descriptor.Timeout) * time.Second
+	} else if h, foundHook := m.hooks[item.Name]; foundHook {
+		installable = h
+		timeout = time.Duration(h.Descriptor.Timeout) * time.Second
+	} else if _, foundManifest := m.manifests[item.Name]; foundManifest {
+		// An included manifest is complete once all its items are
+		if err := m.waitForDependencies(sc, item.Name); err != nil {
+			return err
+		}
+	}
+	if installable != nil {
+		logrus.Infof("%v waiting for %v to be ready", itemID, installable.GetID())
+		start := time.Now()
+		for installable.Status(sc) != kube.Ready {
+			time.Sleep(2 * time.Second)
+			end := time.Now()
+			elapsed := end.Sub(start)
+			if elapsed > timeout {
+				return errors.New("Timeout expired for: " + installable.GetID())
 			}
+			logrus.Debugf("       Waiting for release %v Elapsed=%v", installable.GetID(), elapsed)
 		}
 	}
 	return nil
@@ -244,7 +602,12 @@ func (m *Manifest) installList() []core.InstallableItem {
 	installTable := make(map[string]bool, 0)
 	installList := make([]core.InstallableItem, 0)
 
+	keys := make([]string, 0, len(m.dependencies))
 	for k := range m.dependencies {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
 		list := []core.InstallableItem(nil)
 		if _, found := m.charts[k]; found {
 			list = m.followDeps(core.InstallableItem{
@@ -256,6 +619,16 @@ func (m *Manifest) installList() []core.InstallableItem {
 				Name: k,
 				Kind: core.PackageType,
 			}, installTable)
+		} else if r, found := m.resources[k]; found {
+			list = m.followDeps(core.InstallableItem{
+				Name: k,
+				Kind: r.Descriptor.Kind,
+			}, installTable)
+		} else if _, found := m.hooks[k]; found {
+			list = m.followDeps(core.InstallableItem{
+				Name: k,
+				Kind: core.JobType,
+			}, installTable)
 		}
 		installList = append(installList, list...)
 	}
@@ -264,5 +637,5 @@ func (m *Manifest) installList() []core.InstallableItem {
 		Kind: core.ManifestType,
 	}, installTable)
 	installList = append(installList, list...)
-	return installList
+	return m.filterSelected(installList)
 }
@@ -4,12 +4,21 @@ import (
 	"latimer/core"
 	"os/user"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 )
 
 const (
-	ManifestFilePath = "../test/install-manifest-3.yaml"
+	ManifestFilePath         = "../test/install-manifest-3.yaml"
+	EnvManifestFilePath      = "../test/install-manifest-4.yaml"
+	CondManifestFilePath     = "../test/install-manifest-5.yaml"
+	DepsManifestFilePath     = "../test/install-manifest-1.yaml"
+	IncludeManifestFilePath  = "../test/install-manifest-6.yaml"
+	CycleManifestFilePath    = "../test/install-manifest-cycle.yaml"
+	ScopeManifestFilePath    = "../test/install-manifest-9.yaml"
+	ResourceManifestFilePath = "../test/install-manifest-7.yaml"
+	HookManifestFilePath     = "../test/install-manifest-8.yaml"
 )
 
 // Returns an initialized system context
@@ -31,7 +40,7 @@ func getSystemContext(name string) *core.SystemContext {
 func Test_ManifestInstallOrder(t *testing.T) {
 	t.Run("manifest-install-order", func(t *testing.T) {
 		values := map[string]string{}
-		m, err := NewManifest(ManifestFilePath, values)
+		m, err := NewManifest(ManifestFilePath, values, "")
 		if err != nil {
 			t.Errorf("%v", err)
 		}
@@ -43,7 +52,7 @@ func Test_ManifestInstallOrder(t *testing.T) {
 func Test_ManifestInstall(t *testing.T) {
 	t.Run("manifest-installation", func(t *testing.T) {
 		values := map[string]string{}
-		m, err := NewManifest(ManifestFilePath, values)
+		m, err := NewManifest(ManifestFilePath, values, "")
 		if err != nil {
 			t.Errorf("%v", err)
 		}
@@ -61,7 +70,7 @@ func Test_ManifestDelete(t *testing.T) {
 	t.Run("manifest-deletion", func(t *testing.T) {
 		time.Sleep(5 * time.Second)
 		values := map[string]string{}
-		m, err := NewManifest(ManifestFilePath, values)
+		m, err := NewManifest(ManifestFilePath, values, "")
 		if err != nil {
 			t.Errorf("%v", err)
 		}
@@ -73,3 +82,375 @@ func Test_ManifestDelete(t *testing.T) {
 		t.Logf("==================== Manifest uninstalled: %v ======================", status)
 	})
 }
+
+func Test_ManifestEnvironment(t *testing.T) {
+	t.Run("manifest-environment-prod", func(t *testing.T) {
+		m, err := NewManifest(EnvManifestFilePath, map[string]string{}, "prod")
+		if err != nil {
+			t.Errorf("%v", err)
+		}
+		mysql := m.charts["mysql"].Descriptor
+		if mysql.Namespace != "db-paas" || mysql.Timeout != 600 {
+			t.Errorf("Environment overrides not applied to mysql: %v", mysql)
+		}
+		if redis := m.charts["redis"].Descriptor; redis.Namespace != "paas" {
+			t.Errorf("Environment values not applied to redis: %v", redis)
+		}
+	})
+	t.Run("manifest-environment-dev", func(t *testing.T) {
+		m, err := NewManifest(EnvManifestFilePath, map[string]string{"Namespace": "paas-test"}, "dev")
+		if err != nil {
+			t.Errorf("%v", err)
+		}
+		if _, found := m.charts["mysql"]; found {
+			t.Errorf("Expecting mysql chart to be disabled in dev")
+		}
+		if redis := m.charts["redis"].Descriptor; redis.Namespace != "paas-test" {
+			t.Errorf("Command line values should take precedence over environment values: %v", redis)
+		}
+		installList := m.installList()
+		t.Logf("Install Order List: %v", installList)
+		for _, item := range installList {
+			if item.Name == "mysql" {
+				t.Errorf("Disabled chart mysql in install list: %v", installList)
+			}
+		}
+	})
+	t.Run("manifest-environment-unknown", func(t *testing.T) {
+		_, err := NewManifest(EnvManifestFilePath, map[string]string{}, "qa")
+		if err == nil {
+			t.Errorf("Expecting error for unknown environment")
+		}
+	})
+}
+
+func Test_ManifestConditions(t *testing.T) {
+	t.Run("manifest-conditions-enabled", func(t *testing.T) {
+		m, err := NewManifest(CondManifestFilePath, map[string]string{"Monitoring": "true", "SkipDatabases": "false"}, "")
+		if err != nil {
+			t.Errorf("%v", err)
+		}
+		installList, skipped := m.Plan()
+		if len(skipped) != 0 || len(installList) != 6 {
+			t.Errorf("Expecting all items enabled: %v skipped=%v", installList, skipped)
+		}
+	})
+	t.Run("manifest-conditions-disabled-package", func(t *testing.T) {
+		m, err := NewManifest(CondManifestFilePath, map[string]string{"Monitoring": "true", "SkipDatabases": "true"}, "")
+		if err != nil {
+			t.Errorf("%v", err)
+		}
+		installList, skipped := m.Plan()
+		t.Logf("Install Order List: %v skipped=%v", installList, skipped)
+		if len(skipped) != 3 || m.skipReason("mysql") != "package databases is disabled" {
+			t.Errorf("Expecting databases package and its charts skipped: %v", skipped)
+		}
+	})
+	t.Run("manifest-conditions-disabled-dependency", func(t *testing.T) {
+		_, err := NewManifest(CondManifestFilePath, map[string]string{"Monitoring": "false"}, "")
+		if err == nil {
+			t.Errorf("Expecting error for dependency on disabled chart")
+		}
+		t.Logf("Disabled dependency error: %v", err)
+	})
+}
+
+// Returns the names of the items in an install list
+func itemNames(installList []core.InstallableItem) []string {
+	names := make([]string, 0)
+	for _, item := range installList {
+		names = append(names, item.Name)
+	}
+	return names
+}
+
+func Test_ManifestSelection(t *testing.T) {
+	tests := []struct {
+		name      string
+		selection Selection
+		uninstall bool
+		expected  []string
+	}{
+		{"install-only-with-prerequisites", Selection{Only: []string{"wordpress"}}, false,
+			[]string{"prometheus", "databases", "keycloak", "traefik", "wordpress"}},
+		{"install-only-chart-in-package", Selection{Only: []string{"mysql"}}, false,
+			[]string{"prometheus", "mysql"}},
+		{"install-only-no-deps", Selection{Only: []string{"traefik"}, NoDeps: true}, false,
+			[]string{"traefik"}},
+		{"install-exclude", Selection{Exclude: []string{"prometheus", "databases"}}, false,
+			[]string{"keycloak", "traefik", "grafana", "wordpress"}},
+		{"delete-only-cascade", Selection{Only: []string{"mysql"}, Cascade: true}, true,
+			[]string{"mysql", "keycloak", "traefik", "grafana", "wordpress"}},
+		{"delete-only-no-dependents", Selection{Only: []string{"wordpress", "grafana"}}, true,
+			[]string{"wordpress", "grafana"}},
+		{"delete-only-no-deps", Selection{Only: []string{"mysql"}, NoDeps: true}, true,
+			[]string{"mysql"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m, err := NewManifest(DepsManifestFilePath, map[string]string{}, "")
+			if err != nil {
+				t.Errorf("%v", err)
+			}
+			if err := m.Select(test.selection, test.uninstall); err != nil {
+				t.Errorf("%v", err)
+			}
+			names := itemNames(m.installList())
+			t.Logf("Install Order List: %v", names)
+			if len(names) != len(test.expected) {
+				t.Errorf("Expecting %v, got %v", test.expected, names)
+			}
+			for _, name := range test.expected {
+				if !containsString(names, name) {
+					t.Errorf("Expecting %v in %v", name, names)
+				}
+			}
+		})
+	}
+	t.Run("install-waits-for-selected-dependencies", func(t *testing.T) {
+		waits := []struct {
+			selection Selection
+			itemID    string
+			expected  []string
+		}{
+			{Selection{}, "keycloak", []string{"databases"}},
+			{Selection{Exclude: []string{"prometheus", "databases"}}, "databases", []string{}},
+			{Selection{Exclude: []string{"prometheus", "databases"}}, "traefik", []string{"keycloak"}},
+			{Selection{Exclude: []string{"redis"}}, "keycloak", []string{"postgresql", "mysql"}},
+			{Selection{Only: []string{"traefik"}, NoDeps: true}, "traefik", []string{}},
+		}
+		for _, wait := range waits {
+			m, err := NewManifest(DepsManifestFilePath, map[string]string{}, "")
+			if err != nil {
+				t.Fatalf("%v", err)
+			}
+			if err := m.Select(wait.selection, false); err != nil {
+				t.Fatalf("%v", err)
+			}
+			names := itemNames(m.selectedDependencies(wait.itemID))
+			if strings.Join(names, ",") != strings.Join(wait.expected, ",") {
+				t.Errorf("Expecting %v to wait for %v with selection %+v, got %v", wait.itemID, wait.expected, wait.selection, names)
+			}
+		}
+	})
+	t.Run("select-unknown-item", func(t *testing.T) {
+		m, err := NewManifest(DepsManifestFilePath, map[string]string{}, "")
+		if err != nil {
+			t.Errorf("%v", err)
+		}
+		if err := m.Select(Selection{Only: []string{"nginx"}}, false); err == nil {
+			t.Errorf("Expecting error selecting unknown item")
+		}
+	})
+	t.Run("delete-only-with-dependents", func(t *testing.T) {
+		m, err := NewManifest(DepsManifestFilePath, map[string]string{}, "")
+		if err != nil {
+			t.Errorf("%v", err)
+		}
+		err = m.Select(Selection{Only: []string{"mysql"}}, true)
+		if err == nil || !strings.Contains(err.Error(), "--cascade") || !strings.Contains(err.Error(), "wordpress") {
+			t.Errorf("Expecting deletion of mysql to be refused, got [%v]", err)
+		}
+		err = m.Select(Selection{Only: []string{"mysql"}, Exclude: []string{"grafana"}, Cascade: true}, true)
+		if err == nil || !strings.Contains(err.Error(), "grafana") {
+			t.Errorf("Expecting deletion of mysql to be refused with grafana excluded, got [%v]", err)
+		}
+	})
+}
+
+func Test_ManifestIncludes(t *testing.T) {
+	t.Run("manifest-includes-install-order", func(t *testing.T) {
+		m, err := NewManifest(IncludeManifestFilePath, map[string]string{}, "")
+		if err != nil {
+			t.Errorf("%v", err)
+		}
+		names := itemNames(m.installList())
+		t.Logf("Install Order List: %v", names)
+		expected := []string{"infra/redis", "infra/mysql", "infra", "traefik", "install-manifest-6"}
+		if len(names) != len(expected) {
+			t.Errorf("Expecting %v, got %v", expected, names)
+		}
+		for idx := range expected {
+			if idx < len(names) && names[idx] != expected[idx] {
+				t.Errorf("Expecting %v, got %v", expected, names)
+				break
+			}
+		}
+		if mysql := m.charts["infra/mysql"]; mysql == nil || mysql.Descriptor.ReleaseName != "test-mysql" {
+			t.Errorf("Expecting included chart infra/mysql: %v", mysql)
+		}
+	})
+	t.Run("manifest-includes-delete-dependents", func(t *testing.T) {
+		m, err := NewManifest(IncludeManifestFilePath, map[string]string{}, "")
+		if err != nil {
+			t.Errorf("%v", err)
+		}
+		if err := m.Select(Selection{Only: []string{"infra/redis"}, Cascade: true}, true); err != nil {
+			t.Errorf("%v", err)
+		}
+		names := itemNames(m.installList())
+		t.Logf("Uninstall List: %v", names)
+		if len(names) != 3 || !containsString(names, "infra/mysql") || !containsString(names, "traefik") {
+			t.Errorf("Expecting infra/redis, infra/mysql and traefik, got %v", names)
+		}
+	})
+	t.Run("manifest-includes-values", func(t *testing.T) {
+		m, err := NewManifest(ScopeManifestFilePath, map[string]string{"InfraMonitoring": "true", "Monitoring": "false"}, "")
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		if m.skipReason("grafana") == "" {
+			t.Errorf("Expecting grafana disabled by the values of the manifest")
+		}
+		scope := m.Descriptor.ScopeOf("infra/prometheus")
+		if m.charts["infra/prometheus"] == nil || scope.TemplateValues["Monitoring"] != "true" || scope.DisabledDependencies != core.FailDisabledDependencies {
+			t.Errorf("Expecting infra/prometheus enabled and templated by the values of its include: %v", scope)
+		}
+	})
+	t.Run("manifest-includes-policy", func(t *testing.T) {
+		_, err := NewManifest(ScopeManifestFilePath, map[string]string{"InfraMonitoring": "false"}, "")
+		if err == nil || !strings.Contains(err.Error(), "infra/traefik requires chart infra/prometheus") {
+			t.Errorf("Expecting the error policy of the include to apply to infra/traefik, got %v", err)
+		}
+	})
+	t.Run("manifest-includes-cycle", func(t *testing.T) {
+		_, err := NewManifest(CycleManifestFilePath, map[string]string{}, "")
+		if err == nil {
+			t.Errorf("Expecting include cycle error")
+		}
+		t.Logf("Include cycle error: %v", err)
+	})
+}
+
+func Test_ManifestResources(t *testing.T) {
+	t.Run("manifest-resources-install-order", func(t *testing.T) {
+		m, err := NewManifest(ResourceManifestFilePath, map[string]string{}, "")
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		names := itemNames(m.installList())
+		t.Logf("Install Order List: %v", names)
+		expected := []string{"overlay", "config", "traefik", "install-manifest-7"}
+		if len(names) != len(expected) {
+			t.Errorf("Expecting %v, got %v", expected, names)
+		}
+		for idx := range expected {
+			if idx < len(names) && names[idx] != expected[idx] {
+				t.Errorf("Expecting %v, got %v", expected, names)
+				break
+			}
+		}
+		if overlay := m.resources["overlay"]; overlay == nil || overlay.Descriptor.Kind != core.KustomizeType || overlay.Descriptor.Timeout != 120 {
+			t.Errorf("Expecting kustomize resource overlay: %v", overlay)
+		}
+		if config := m.resources["config"]; config == nil || config.Descriptor.Path != filepath.Join("../test", "resources", "config") {
+			t.Errorf("Expecting the path of resource config relative to the manifest: %v", config)
+		}
+	})
+	t.Run("manifest-resources-delete-dependents", func(t *testing.T) {
+		m, err := NewManifest(ResourceManifestFilePath, map[string]string{}, "")
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		if err := m.Select(Selection{Only: []string{"overlay"}, Cascade: true}, true); err != nil {
+			t.Errorf("%v", err)
+		}
+		names := itemNames(m.installList())
+		t.Logf("Uninstall List: %v", names)
+		if len(names) != 3 || !containsString(names, "config") || !containsString(names, "traefik") {
+			t.Errorf("Expecting overlay, config and traefik, got %v", names)
+		}
+	})
+}
+
+func Test_ManifestHooks(t *testing.T) {
+	t.Run("manifest-hooks-install-order", func(t *testing.T) {
+		m, err := NewManifest(HookManifestFilePath, map[string]string{}, "")
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		names := itemNames(m.installList())
+		t.Logf("Install Order List: %v", names)
+		expected := []string{"mysql", "migrate", "traefik", "install-manifest-8"}
+		if len(names) != len(expected) {
+			t.Errorf("Expecting %v, got %v", expected, names)
+		}
+		for idx := range expected {
+			if idx < len(names) && names[idx] != expected[idx] {
+				t.Errorf("Expecting %v, got %v", expected, names)
+				break
+			}
+		}
+		if hooks := m.HookNames(core.PostInstallPhase); len(hooks) != 1 || hooks[0] != "seed" {
+			t.Errorf("Expecting post-install hook seed, got %v", hooks)
+		}
+		if hooks := m.HookNames(core.PreDeletePhase); len(hooks) != 1 || hooks[0] != "backup" {
+			t.Errorf("Expecting pre-delete hook backup, got %v", hooks)
+		}
+		if seed := m.phaseHooks[core.PostInstallPhase][0]; seed.Descriptor.Dir != "../test" {
+			t.Errorf("Expecting the command of hook seed to run in the manifest directory: %v", seed)
+		}
+	})
+	t.Run("manifest-hooks-pre-install", func(t *testing.T) {
+		m, err := NewManifest(HookManifestFilePath, map[string]string{}, "")
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		m.phaseHooks[core.PreInstallPhase] = m.phaseHooks[core.PreDeletePhase]
+		m.phaseHooks[core.PreDeletePhase][0].Descriptor.Command = []string{"false"}
+		if m.Install(&core.SystemContext{Name: m.GetID()}) {
+			t.Errorf("Expecting install to stop on a failed pre-install hook")
+		}
+	})
+}
+
+func Test_ManifestReleases(t *testing.T) {
+	t.Run("manifest-releases-installed", func(t *testing.T) {
+		m, err := NewManifest(DepsManifestFilePath, map[string]string{}, "")
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		if err := m.Select(Selection{Only: []string{"keycloak"}}, false); err != nil {
+			t.Fatalf("%v", err)
+		}
+		releases := m.installedReleases()
+		names := make([]string, 0, len(releases))
+		for _, r := range releases {
+			names = append(names, r.ReleaseName)
+		}
+		t.Logf("Installed releases: %v", names)
+		if len(names) != 5 || names[0] != "test-prometheus" || names[4] != "test-keycloak" {
+			t.Errorf("Expecting the releases of prometheus, the databases and keycloak, got %v", names)
+		}
+	})
+	t.Run("manifest-releases-stale", func(t *testing.T) {
+		m, err := NewManifest(DepsManifestFilePath, map[string]string{}, "")
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		previous := []core.ManagedRelease{
+			{Chart: "redis", ReleaseName: "test-redis", Namespace: "paas"},
+			{Chart: "memcached", ReleaseName: "test-memcached", Namespace: "paas"},
+			{Chart: "cache", ReleaseName: "test-mysql", Namespace: "paas"},
+			{Chart: "redis", ReleaseName: "test-redis", Namespace: "cache"},
+		}
+		stale := m.staleReleases(previous)
+		if len(stale) != 2 || stale[0].Chart != "memcached" || stale[1].Namespace != "cache" {
+			t.Errorf("Expecting the memcached release and the redis release of namespace cache, got %v", stale)
+		}
+	})
+	t.Run("manifest-releases-stale-disabled", func(t *testing.T) {
+		m, err := NewManifest(CondManifestFilePath, map[string]string{"Monitoring": "true", "SkipDatabases": "true"}, "")
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		previous := []core.ManagedRelease{
+			{Chart: "mysql", ReleaseName: "test-mysql", Namespace: "paas"},
+			{Chart: "memcached", ReleaseName: "test-memcached", Namespace: "paas"},
+		}
+		stale := m.staleReleases(previous)
+		if len(stale) != 1 || stale[0].Chart != "memcached" {
+			t.Errorf("Expecting the release of the disabled mysql chart kept, got %v", stale)
+		}
+	})
+}
@@ -0,0 +1,172 @@
+package manifest
+
+import (
+	"encoding/json"
+	"fmt"
+	"latimer/core"
+	"latimer/helm"
+	"latimer/kube"
+
+	"github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+const (
+	// releasesPrefix is the name prefix of the config maps recording the releases managed for a manifest
+	releasesPrefix = "latimer-releases-"
+	// releasesKey is the config map key of the list of managed releases
+	releasesKey = "releases"
+)
+
+// ManagedReleases returns the helm releases latimer installed for the manifest in the cluster of the system
+// context, in install order
+func (m *Manifest) ManagedReleases(sc *core.SystemContext) ([]core.ManagedRelease, error) {
+	value, err := sc.GetKubeClient().GetConfigMapValue(stateNamespace(sc), m.releasesName(), releasesKey)
+	if apierrors.IsNotFound(err) {
+		return []core.ManagedRelease{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	releases := make([]core.ManagedRelease, 0)
+	if err := json.Unmarshal([]byte(value), &releases); err != nil {
+		return nil, fmt.Errorf("Invalid record of the releases of manifest %v: %v", m.GetID(), err)
+	}
+	return releases, nil
+}
+
+// StaleReleases returns the managed releases whose chart is no longer in the manifest (or disabled)
+func (m *Manifest) StaleReleases(sc *core.SystemContext) ([]core.ManagedRelease, error) {
+	releases, err := m.ManagedReleases(sc)
+	if err != nil {
+		return nil, err
+	}
+	return m.staleReleases(releases), nil
+}
+
+// Prune uninstalls the managed releases no longer in the manifest, in reverse install order, and forgets them
+func (m *Manifest) Prune(sc *core.SystemContext) bool {
+	releases, err := m.ManagedReleases(sc)
+	if err != nil {
+		logrus.Errorf("Cannot read the releases of manifest %v [%v]", m.GetID(), err)
+		return false
+	}
+	stale := m.staleReleases(releases)
+	status := true
+	pruned := make([]core.ManagedRelease, 0, len(stale))
+	for idx := len(stale) - 1; idx >= 0; idx-- {
+		r := stale[idx]
+		if err := m.leaseLost(); err != nil {
+			logrus.Errorf("Pruning of manifest %v aborted before %v: %v", m.GetID(), r, err)
+			status = false
+			break
+		}
+		fmt.Printf("Pruning release %v\n", r)
+		descriptor := &core.ChartDescriptor{
+			Name:          r.Chart,
+			ReleaseName:   r.ReleaseName,
+			Namespace:     r.Namespace,
+			Target:        r.Target,
+			Timeout:       core.DefaultChartTimeoutSeconds,
+			DeleteTimeout: core.DefaultChartTimeoutSeconds,
+			OnDelete:      core.DeletePolicy{PVCs: core.RetainVolumes},
+		}
+		sysCtxt := *sc
+		if !helm.NewChart(descriptor, m.Descriptor.TemplateValues).Uninstall(&sysCtxt) {
+			// Releases installed before the failed one may depend on it
+			status = false
+			break
+		}
+		pruned = append(pruned, r)
+	}
+	if len(pruned) > 0 {
+		if err := m.saveReleases(sc, core.RemoveManagedReleases(releases, pruned)); err != nil {
+			logrus.Errorf("Cannot record the releases of manifest %v [%v]", m.GetID(), err)
+			return false
+		}
+	}
+	return status
+}
+
+// recordReleases adds the releases of the charts installed by the run to the managed releases
+func (m *Manifest) recordReleases(sc *core.SystemContext) error {
+	releases, err := m.ManagedReleases(sc)
+	if err != nil {
+		return err
+	}
+	return m.saveReleases(sc, core.MergeManagedReleases(releases, m.installedReleases()))
+}
+
+// forgetReleases removes the releases of the charts uninstalled by the run from the managed releases
+func (m *Manifest) forgetReleases(sc *core.SystemContext) error {
+	releases, err := m.ManagedReleases(sc)
+	if err != nil {
+		return err
+	}
+	return m.saveReleases(sc, core.RemoveManagedReleases(releases, m.installedReleases()))
+}
+
+// saveReleases records the managed releases of the manifest, the record is deleted once empty
+func (m *Manifest) saveReleases(sc *core.SystemContext, releases []core.ManagedRelease) error {
+	k8s := sc.GetKubeClient()
+	if len(releases) == 0 {
+		return k8s.DeleteConfigMap(stateNamespace(sc), m.releasesName())
+	}
+	releasesBytes, err := json.Marshal(releases)
+	if err != nil {
+		return err
+	}
+	labels := map[string]string{kube.LabelManagedBy: kube.FieldManager, kube.LabelOwner: kube.OwnerLabelValue(m.GetID())}
+	return k8s.SaveConfigMapValue(stateNamespace(sc), m.releasesName(), releasesKey, string(releasesBytes), labels)
+}
+
+// installedReleases returns the releases of the (selected) charts of the manifest, in install order
+func (m *Manifest) installedReleases() []core.ManagedRelease {
+	releases := make([]core.ManagedRelease, 0)
+	for _, c := range m.selectedCharts() {
+		releases = append(releases, core.NewManagedRelease(c.Descriptor))
+	}
+	return releases
+}
+
+// selectedCharts returns the (selected) charts of the manifest, including the charts of packages, in install order
+func (m *Manifest) selectedCharts() []*helm.Chart {
+	charts := make([]*helm.Chart, 0)
+	for _, item := range m.installList() {
+		switch item.Kind {
+		case core.ChartType:
+			charts = append(charts, m.charts[item.Name])
+		case core.PackageType:
+			charts = append(charts, m.packages[item.Name].Charts...)
+		}
+	}
+	return charts
+}
+
+// staleReleases returns the managed releases of the charts removed from the manifest, in install order.  The
+// releases of the charts still declared but skipped (disabled, by environment or condition) are not stale.
+func (m *Manifest) staleReleases(releases []core.ManagedRelease) []core.ManagedRelease {
+	present := map[string]bool{}
+	for idx := range m.Descriptor.Charts {
+		present[core.NewManagedRelease(&m.Descriptor.Charts[idx]).Key()] = true
+	}
+	stale := make([]core.ManagedRelease, 0)
+	for _, r := range releases {
+		if !present[r.Key()] {
+			stale = append(stale, r)
+		}
+	}
+	return stale
+}
+
+// releasesName returns the name of the config map recording the releases managed for the manifest
+func (m *Manifest) releasesName() string {
+	return releasesPrefix + kube.OwnerLabelValue(m.GetID())
+}
+
+// stateNamespace returns the namespace of the records latimer keeps in the cluster
+func stateNamespace(sc *core.SystemContext) string {
+	if sc.Context == nil || sc.Context.StateNamespace == "" {
+		return core.DefaultStateNamespace
+	}
+	return sc.Context.StateNamespace
+}
@@ -0,0 +1,313 @@
+package manifest
+
+import (
+	"fmt"
+	"latimer/core"
+	"sort"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Selection restricts the items of a manifest to install or uninstall
+type Selection struct {
+	// Only lists the names of the charts/packages to target (all items if empty)
+	Only []string
+	// Exclude lists the names of the charts/packages to leave out
+	Exclude []string
+	// NoDeps disables the dependency closure of the targeted items, and the check of their dependents on uninstall
+	NoDeps bool
+	// Cascade brings in the transitive dependents of the items to uninstall, which are uninstalled first.  Without
+	// it, uninstalling items other items depend on is refused.
+	Cascade bool
+}
+
+// IsEmpty returns whether the selection targets the whole manifest
+func (s Selection) IsEmpty() bool {
+	return len(s.Only) == 0 && len(s.Exclude) == 0
+}
+
+// Select restricts the manifest to the items in the selection.  Unless NoDeps is set, the targeted items are
+// closed over their transitive prerequisites (install).  On uninstall, the targeted items are closed over their
+// transitive dependents if Cascade is set, otherwise the selection is refused if any item depends on them.
+func (m *Manifest) Select(selection Selection, uninstall bool) error {
+	if selection.IsEmpty() {
+		m.selected = nil
+		return nil
+	}
+	for _, name := range append(append([]string{}, selection.Only...), selection.Exclude...) {
+		if !m.hasItem(name) {
+			if reason := m.skipReason(name); reason != "" {
+				return fmt.Errorf("Item %v is disabled (%v)", name, reason)
+			}
+			return fmt.Errorf("Item %v not found in manifest %v", name, m.GetID())
+		}
+	}
+	selected := map[string]bool{}
+	targets := selection.Only
+	if len(targets) == 0 {
+		targets = m.itemNames()
+	}
+	for _, name := range targets {
+		selected[name] = true
+		// A package (or included manifest) always brings in its members
+		for _, memberName := range m.allMembers(name) {
+			selected[memberName] = true
+		}
+		if selection.NoDeps {
+			continue
+		}
+		closure := []string(nil)
+		if !uninstall {
+			closure = m.prerequisites(name, map[string]bool{})
+		} else if selection.Cascade {
+			closure = m.dependents(name, map[string]bool{})
+		}
+		for _, depName := range closure {
+			selected[depName] = true
+		}
+	}
+	for _, name := range selection.Exclude {
+		delete(selected, name)
+		for _, memberName := range m.allMembers(name) {
+			delete(selected, memberName)
+		}
+	}
+	if uninstall && !selection.NoDeps {
+		if err := m.checkDependents(selected, selection); err != nil {
+			return err
+		}
+	}
+	m.selected = selected
+	logrus.Infof("Selected items of manifest %v: %v", m.GetID(), m.selectedNames())
+	return nil
+}
+
+// checkDependents verifies that no item left installed depends on an item selected for uninstall
+func (m *Manifest) checkDependents(selected map[string]bool, selection Selection) error {
+	names := make([]string, 0, len(selected))
+	for name := range selected {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		blocking := make([]string, 0)
+		for _, depName := range m.dependents(name, map[string]bool{}) {
+			if !selected[depName] && !containsString(blocking, depName) {
+				blocking = append(blocking, depName)
+			}
+		}
+		if len(blocking) == 0 {
+			continue
+		}
+		sort.Strings(blocking)
+		hint := ""
+		if !selection.Cascade && len(selection.Exclude) == 0 {
+			hint = " (use --cascade to delete them first)"
+		}
+		return fmt.Errorf("Cannot delete %v: items left installed depend on it %v%v", name, blocking, hint)
+	}
+	return nil
+}
+
+// hasItem returns whether the named chart, package, resource, hook or included manifest is part of the manifest
+func (m *Manifest) hasItem(name string) bool {
+	_, isChart := m.charts[name]
+	_, isPackage := m.packages[name]
+	_, isResource := m.resources[name]
+	_, isHook := m.hooks[name]
+	_, isManifest := m.manifests[name]
+	return isChart || isPackage || isResource || isHook || isManifest
+}
+
+// itemNames returns the sorted names of all charts, packages, resources, hooks and included manifests in the
+// manifest
+func (m *Manifest) itemNames() []string {
+	names := make([]string, 0, len(m.charts)+len(m.packages)+len(m.resources)+len(m.hooks)+len(m.manifests))
+	for name := range m.charts {
+		names = append(names, name)
+	}
+	for name := range m.packages {
+		names = append(names, name)
+	}
+	for name := range m.resources {
+		names = append(names, name)
+	}
+	for name := range m.hooks {
+		names = append(names, name)
+	}
+	for name := range m.manifests {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// selectedNames returns the sorted names of the selected items
+func (m *Manifest) selectedNames() []string {
+	names := make([]string, 0, len(m.selected))
+	for name := range m.selected {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// isSelected returns whether the named item takes part in install/uninstall
+func (m *Manifest) isSelected(name string) bool {
+	return m.selected == nil || m.selected[name]
+}
+
+// containersOf returns the names of the packages and included manifests containing the named item
+func (m *Manifest) containersOf(itemName string) []string {
+	names := make([]string, 0)
+	for pkgName, p := range m.packages {
+		for _, c := range p.Charts {
+			if c.Name == itemName {
+				names = append(names, pkgName)
+			}
+		}
+	}
+	for manifestName, items := range m.manifests {
+		for _, item := range items {
+			if item.Name == itemName {
+				names = append(names, manifestName)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// members returns the names of the charts of a package, or the items of an included manifest
+func (m *Manifest) members(name string) []string {
+	names := make([]string, 0)
+	if p, found := m.packages[name]; found {
+		for _, c := range p.Charts {
+			names = append(names, c.Name)
+		}
+	}
+	for _, item := range m.manifests[name] {
+		names = append(names, item.Name)
+	}
+	return names
+}
+
+// allMembers returns the transitive members of a package or included manifest
+func (m *Manifest) allMembers(name string) []string {
+	names := make([]string, 0)
+	for _, memberName := range m.members(name) {
+		names = append(names, memberName)
+		names = append(names, m.allMembers(memberName)...)
+	}
+	return names
+}
+
+// prerequisites returns the transitive closure of the items the named item requires.  Packages and included
+// manifests bring in their members, and members inherit the requirements of their containers.
+func (m *Manifest) prerequisites(name string, visited map[string]bool) []string {
+	if visited[name] {
+		return nil
+	}
+	visited[name] = true
+	result := make([]string, 0)
+	required := make([]string, 0)
+	for _, d := range m.dependencies[name] {
+		required = append(required, d.Name)
+	}
+	members := m.members(name)
+	required = append(required, members...)
+	for _, containerName := range m.containersOf(name) {
+		containerMembers := m.members(containerName)
+		for _, d := range m.dependencies[containerName] {
+			if !containsString(containerMembers, d.Name) {
+				required = append(required, d.Name)
+			}
+		}
+	}
+	for _, reqName := range required {
+		if !m.hasItem(reqName) || visited[reqName] {
+			continue
+		}
+		result = append(result, reqName)
+		result = append(result, m.prerequisites(reqName, visited)...)
+	}
+	return result
+}
+
+// dependents returns the transitive closure of the items requiring the named item, directly or through its
+// containers.  Packages and included manifests bring in the dependents of their members.
+func (m *Manifest) dependents(name string, visited map[string]bool) []string {
+	if visited[name] {
+		return nil
+	}
+	visited[name] = true
+	requiredNames := append([]string{name}, m.containersOf(name)...)
+	requiredNames = append(requiredNames, m.members(name)...)
+	result := make([]string, 0)
+	for _, itemName := range m.itemNames() {
+		// Containers are not dependents of their own members
+		if visited[itemName] || containsString(m.members(itemName), name) {
+			continue
+		}
+		for _, d := range m.dependencies[itemName] {
+			if containsString(requiredNames, d.Name) {
+				result = append(result, itemName)
+				result = append(result, m.dependents(itemName, visited)...)
+				break
+			}
+		}
+	}
+	return result
+}
+
+// filterSelected restricts an ordered install list to the selected items.  A package is kept whole only when
+// all its charts are selected, otherwise its selected charts are kept individually.
+func (m *Manifest) filterSelected(installList []core.InstallableItem) []core.InstallableItem {
+	if m.selected == nil {
+		return installList
+	}
+	filtered := make([]core.InstallableItem, 0)
+	added := map[string]bool{}
+	for _, item := range installList {
+		if added[item.Name] {
+			continue
+		}
+		p, isPackage := m.packages[item.Name]
+		if !isPackage {
+			if m.isSelected(item.Name) {
+				filtered = append(filtered, item)
+				added[item.Name] = true
+			}
+			continue
+		}
+		wholePackage := m.isSelected(item.Name)
+		for _, c := range p.Charts {
+			wholePackage = wholePackage && m.isSelected(c.Name)
+		}
+		if wholePackage {
+			filtered = append(filtered, item)
+			added[item.Name] = true
+			for _, c := range p.Charts {
+				added[c.Name] = true
+			}
+		} else {
+			for _, c := range p.Charts {
+				if m.isSelected(c.Name) && !added[c.Name] {
+					filtered = append(filtered, core.InstallableItem{Name: c.Name, Kind: core.ChartType})
+					added[c.Name] = true
+				}
+			}
+		}
+	}
+	return filtered
+}
+
+// containsString returns whether the string slice contains the given value
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
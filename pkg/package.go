@@ -57,6 +57,16 @@ func (p *Package) Install(sc *core.SystemContext) bool {
 	return finalStatus
 }
 
+// Upgrade upgrades the releases of the charts of the package, installing the missing ones
+func (p *Package) Upgrade(sc *core.SystemContext) bool {
+	finalStatus := true
+	for _, swItem := range p.Charts {
+		status := swItem.Upgrade(sc)
+		finalStatus = finalStatus && status
+	}
+	return finalStatus
+}
+
 // Uninstall the contents of this installable
 func (p *Package) Uninstall(sc *core.SystemContext) bool {
 	finalStatus := true
@@ -0,0 +1,238 @@
+package resource
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"latimer/core"
+	"latimer/kube"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/cli-runtime/pkg/kustomize"
+	"sigs.k8s.io/kustomize/pkg/fs"
+)
+
+const (
+	// DefaultNamespace is the namespace of the objects (and of the inventory) of a resource without namespace
+	DefaultNamespace = "default"
+)
+
+// Resource is a set of plain kubernetes objects, a directory of yaml manifests or a kustomize overlay, applied
+// with server side apply.  The objects applied are recorded in an inventory so that objects removed from the
+// resource are pruned and uninstall deletes exactly what was applied.
+type Resource struct {
+	// Name of the resource
+	Name string `json:"name"`
+
+	// The resource descriptor
+	Descriptor *core.ResourceDescriptor `json:"descriptor"`
+}
+
+// NewResource creates a new instance of a resource
+func NewResource(descriptor *core.ResourceDescriptor) *Resource {
+	r := new(Resource)
+	r.Name = descriptor.Name
+	r.Descriptor = descriptor
+	return r
+}
+
+// GetID returns the identifier name for this Installable.
+func (r *Resource) GetID() string {
+	return r.Name
+}
+
+// Return string representation of the resource
+func (r *Resource) String() string {
+	resourceBytes, err := json.Marshal(r)
+	if err != nil {
+		logrus.Error("Error generating JSON representation")
+	}
+	return string(resourceBytes)
+}
+
+// StringYaml returns yaml representation of the resource
+func (r *Resource) StringYaml() string {
+	resourceBytes, err := yaml.Marshal(r)
+	if err != nil {
+		logrus.Error("Error generating YAML representation")
+	}
+	return string(resourceBytes)
+}
+
+// Install applies the objects of the resource and prunes the objects previously applied which are gone
+func (r *Resource) Install(sc *core.SystemContext) bool {
+	k8s := sc.GetKubeClient()
+	namespace := r.namespace()
+	objects, err := r.Render()
+	if err != nil {
+		logrus.Errorf("Cannot render %v %v [%v]", r.Descriptor.Kind, r.Name, err)
+		return false
+	}
+	previous, _, err := k8s.GetInventory(r.Name, namespace)
+	if err != nil {
+		logrus.Errorf("Cannot read the inventory of %v [%v]", r.Name, err)
+		return false
+	}
+	applied, err := k8s.ApplyObjects(r.Name, namespace, objects, r.Descriptor.Adopt)
+	if err != nil {
+		logrus.Errorf("Install failed [%v]", err)
+		// Keep track of everything applied so far so that uninstall can remove it
+		if err := k8s.SaveInventory(r.Name, namespace, mergeRefs(previous, applied)); err != nil {
+			logrus.Errorf("Cannot save the inventory of %v [%v]", r.Name, err)
+		}
+		return false
+	}
+	if err := k8s.DeleteObjects(missingRefs(previous, applied)); err != nil {
+		logrus.Errorf("Cannot prune the objects removed from %v [%v]", r.Name, err)
+		return false
+	}
+	if err := k8s.SaveInventory(r.Name, namespace, applied); err != nil {
+		logrus.Errorf("Cannot save the inventory of %v [%v]", r.Name, err)
+		return false
+	}
+	fmt.Printf("%v %v applied %v objects to namespace %v\n", strings.Title(r.Descriptor.Kind), r.Name, len(applied), namespace)
+	fmt.Println("----------------------------------------------------------------------------------------")
+	return true
+}
+
+// Uninstall deletes the objects recorded in the inventory of the resource, in reverse order of apply
+func (r *Resource) Uninstall(sc *core.SystemContext) bool {
+	k8s := sc.GetKubeClient()
+	namespace := r.namespace()
+	refs, found, err := k8s.GetInventory(r.Name, namespace)
+	if err != nil {
+		logrus.Errorf("Cannot read the inventory of %v [%v]", r.Name, err)
+		return false
+	}
+	// If nothing was applied we just return successful uninstall
+	if !found {
+		return true
+	}
+	if err := k8s.DeleteObjects(refs); err != nil {
+		logrus.Errorf("Delete failed [%v]", err)
+		return false
+	}
+	timeout := time.Duration(r.Descriptor.Timeout) * time.Second
+	pending, err := k8s.WaitForDeletion(kube.DeletionScope{Refs: refs}, timeout)
+	if err != nil {
+		logrus.Errorf("Error waiting for the deletion of %v [%v]", r.Name, err)
+		return false
+	}
+	if len(pending) > 0 {
+		// The inventory is kept so that the objects left are deleted on the next uninstall
+		logrus.Errorf("%v not fully deleted after %v, %v objects left:", r.Name, timeout, len(pending))
+		for _, p := range pending {
+			logrus.Errorf("  %v", p)
+		}
+		return false
+	}
+	if err := k8s.DeleteInventory(r.Name, namespace); err != nil {
+		logrus.Errorf("Cannot delete the inventory of %v [%v]", r.Name, err)
+		return false
+	}
+	fmt.Printf("%v %v deleted from namespace %v\n", strings.Title(r.Descriptor.Kind), r.Name, namespace)
+	return true
+}
+
+// Status returns the status of the workloads of the resource.  A resource without workloads is ready once applied.
+func (r *Resource) Status(sc *core.SystemContext) kube.InstallStatus {
+	k8s := sc.GetKubeClient()
+	if _, found, err := k8s.GetInventory(r.Name, r.namespace()); err != nil {
+		return kube.InstallationError
+	} else if !found {
+		return kube.NotInstalled
+	}
+	rr, err := k8s.GetResourcesOwnedBy(r.Name)
+	if err != nil {
+		return kube.InstallationError
+	}
+	status := rr.ReleaseStatus()
+	if status == kube.NotInstalled {
+		return kube.Ready
+	}
+	return status
+}
+
+// Render returns the objects of the resource: the yaml and json files of the directory in name order, or the
+// output of the kustomize build of the overlay
+func (r *Resource) Render() ([]*unstructured.Unstructured, error) {
+	var manifests []byte
+	var err error
+	switch r.Descriptor.Kind {
+	case core.ManifestsType:
+		manifests, err = readManifests(r.Descriptor.Path)
+	case core.KustomizeType:
+		var out bytes.Buffer
+		err = kustomize.RunKustomizeBuild(&out, fs.MakeRealFS(), r.Descriptor.Path)
+		manifests = out.Bytes()
+	default:
+		err = fmt.Errorf("Unsupported resource kind %v", r.Descriptor.Kind)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return kube.ParseObjects(manifests)
+}
+
+// namespace returns the namespace of the namespaced objects without one, and of the inventory
+func (r *Resource) namespace() string {
+	if r.Descriptor.Namespace == "" {
+		return DefaultNamespace
+	}
+	return r.Descriptor.Namespace
+}
+
+// readManifests concatenates the yaml and json files of a directory, in name order, into a multi document stream
+func readManifests(dir string) ([]byte, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(files))
+	for _, f := range files {
+		switch strings.ToLower(filepath.Ext(f.Name())) {
+		case ".yaml", ".yml", ".json":
+			if !f.IsDir() {
+				names = append(names, f.Name())
+			}
+		}
+	}
+	sort.Strings(names)
+	var b bytes.Buffer
+	for _, name := range names {
+		content, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		b.WriteString("\n---\n")
+		b.Write(content)
+	}
+	return b.Bytes(), nil
+}
+
+// missingRefs returns the references of previous which are not in current
+func missingRefs(previous []kube.ObjectRef, current []kube.ObjectRef) []kube.ObjectRef {
+	currentRefs := map[kube.ObjectRef]bool{}
+	for _, ref := range current {
+		currentRefs[ref] = true
+	}
+	missing := make([]kube.ObjectRef, 0)
+	for _, ref := range previous {
+		if !currentRefs[ref] {
+			missing = append(missing, ref)
+		}
+	}
+	return missing
+}
+
+// mergeRefs returns the references of previous followed by the references of current not in previous
+func mergeRefs(previous []kube.ObjectRef, current []kube.ObjectRef) []kube.ObjectRef {
+	return append(append([]kube.ObjectRef{}, previous...), missingRefs(current, previous)...)
+}
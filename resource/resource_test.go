@@ -0,0 +1,38 @@
+package resource
+
+import (
+	"latimer/core"
+	"path/filepath"
+	"testing"
+)
+
+func Test_resource_render(t *testing.T) {
+	t.Run("resource-render-manifests", func(t *testing.T) {
+		path, _ := filepath.Abs("../test/resources/config")
+		r := NewResource(&core.ResourceDescriptor{Name: "config", Kind: core.ManifestsType, Path: path})
+		objects, err := r.Render()
+		if err != nil {
+			t.Fatalf("Error rendering %v [%v]", path, err)
+		}
+		kinds := []string{"Namespace", "ConfigMap", "ServiceAccount"}
+		if len(objects) != len(kinds) {
+			t.Fatalf("Expecting %v objects, got %v", len(kinds), len(objects))
+		}
+		for idx, kind := range kinds {
+			if objects[idx].GetKind() != kind {
+				t.Errorf("Expecting %v at position %v, got %v", kind, idx, objects[idx].GetKind())
+			}
+		}
+	})
+	t.Run("resource-render-kustomize", func(t *testing.T) {
+		path, _ := filepath.Abs("../test/resources/overlay")
+		r := NewResource(&core.ResourceDescriptor{Name: "overlay", Kind: core.KustomizeType, Path: path})
+		objects, err := r.Render()
+		if err != nil {
+			t.Fatalf("Error building %v [%v]", path, err)
+		}
+		if len(objects) != 1 || objects[0].GetName() != "test-echo" || objects[0].GetLabels()["app"] != "echo" {
+			t.Errorf("Unexpected kustomize output %v", objects)
+		}
+	})
+}